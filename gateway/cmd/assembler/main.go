@@ -0,0 +1,57 @@
+// The assembler consumes uploads from the video-chunks topic, stores each
+// one in the repo service and announces it on the video-ready topic.
+package main
+
+import (
+	"codek7/common/pb"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"github.com/lumbrjx/codek7/gateway/internal/infra"
+	"github.com/lumbrjx/codek7/gateway/internal/ingest"
+	"github.com/lumbrjx/codek7/gateway/internal/watcher"
+)
+
+const groupID = "video-assembler"
+
+func main() {
+	godotenv.Load()
+
+	if err := infra.RDBConnect(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	reader, err := infra.MakeKafkaConsumer(ingest.Topic, groupID)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer reader.Close()
+
+	events, err := infra.MakeKafkaTopicProducer(ingest.ReadyTopic)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer events.Close()
+
+	notifier, err := watcher.NewNotificationSender(os.Getenv("RMQ_HOST"))
+	if err != nil {
+		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	}
+	defer notifier.Close()
+
+	handoff := ingest.NewRepoHandoff(pb.NewRepoServiceClient(infra.MakeGRPCClientConn()), events)
+	assembler := ingest.NewAssembler(ingest.AssemblerConfigFromEnv(), handoff, notifier)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("🚀 Assembling uploads from %s as %s", ingest.Topic, groupID)
+	if err := assembler.Run(ctx, reader); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf("Assembler stopped: %v", err)
+	}
+}
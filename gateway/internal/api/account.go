@@ -0,0 +1,194 @@
+package api
+
+import (
+	"codek7/common/pb"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/infra"
+	"github.com/lumbrjx/codek7/gateway/internal/mailer"
+	"github.com/lumbrjx/codek7/gateway/internal/session"
+	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// mailThrottle is the minimum time between two account mails of the
+	// same kind to one user
+	mailThrottle      = time.Minute
+	minPasswordLength = 8
+)
+
+// appBaseURL is the web app's origin, APP_BASE_URL defaults to the
+// development frontend
+func appBaseURL() string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return base
+	}
+	return "http://localhost:3000"
+}
+
+// appURL builds a link into the web app
+func appURL(path string, query url.Values) string {
+	return appBaseURL() + path + "?" + query.Encode()
+}
+
+// allowMail reports whether a mail of kind may be sent to userID now
+func allowMail(ctx context.Context, kind, userID string) (bool, error) {
+	return infra.GetRDB().SetNX(ctx, "auth:mail:"+kind+":"+userID, 1, mailThrottle).Result()
+}
+
+// sendVerificationMail issues a verification token for userID and mails it
+func (a API) sendVerificationMail(ctx context.Context, userID string) error {
+	res, err := a.RepoClient.RequestEmailVerification(ctx, &pb.EmailVerificationRequest{UserId: userID})
+	if err != nil {
+		return err
+	}
+	link := appURL("/verify-email", url.Values{"token": {res.Token}})
+	return a.Mailer.Send(ctx, mailer.Message{
+		To:      res.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address to start uploading videos:\n\n%s\n\nThe link expires at %s.\n",
+			res.Username, link, res.ExpiresAt),
+	})
+}
+
+// RequestEmailVerification mails a new verification link to the caller
+func (a API) RequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	allowed, err := allowMail(r.Context(), "verify", userID)
+	if err != nil {
+		log.Printf("Failed to throttle verification mail: %v", err)
+		http.Error(w, `{"status":"error","message":"Failed to send verification email"}`, http.StatusServiceUnavailable)
+		return
+	}
+	if !allowed {
+		http.Error(w, `{"status":"error","message":"A verification email was sent recently"}`, http.StatusTooManyRequests)
+		return
+	}
+
+	if err := a.sendVerificationMail(r.Context(), userID); err != nil {
+		if status.Code(err) == codes.FailedPrecondition {
+			http.Error(w, `{"status":"error","message":"Email already verified"}`, http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to send verification mail to user %s: %v", userID, err)
+		http.Error(w, `{"status":"error","message":"Failed to send verification email"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+type tokenRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password,omitempty"`
+}
+
+// ConfirmEmail spends a verification token. Live sessions gain the upload
+// scope with their next refresh.
+func (a API) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	res, err := a.RepoClient.ConfirmEmail(r.Context(), &pb.ConfirmUserTokenRequest{Token: req.Token})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	if err := session.UpdateGrants(r.Context(), session.User{ID: res.Id, Role: res.Role, Scopes: res.Scopes}); err != nil {
+		log.Printf("Failed to update sessions of user %s after verification: %v", res.Id, err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "email_verified": true})
+}
+
+// RequestPasswordReset mails a reset link. It answers the same whether or
+// not the address belongs to an account.
+func (a API) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := a.sendPasswordResetMail(r.Context(), req.Email); err != nil {
+		log.Printf("Failed to send password reset mail: %v", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "If the address belongs to an account, a reset link is on its way",
+	})
+}
+
+func (a API) sendPasswordResetMail(ctx context.Context, email string) error {
+	res, err := a.RepoClient.RequestPasswordReset(ctx, &pb.PasswordResetRequest{Email: email})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The token is already issued, throttling only stops mail floods
+	allowed, err := allowMail(ctx, "reset", res.UserId)
+	if err != nil || !allowed {
+		return err
+	}
+
+	link := appURL("/reset-password", url.Values{"token": {res.Token}})
+	return a.Mailer.Send(ctx, mailer.Message{
+		To:      res.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nChoose a new password here:\n\n%s\n\nThe link expires at %s. If you did not ask for a reset, ignore this email.\n",
+			res.Username, link, res.ExpiresAt),
+	})
+}
+
+// ResetPassword sets a new password with a reset token and ends every
+// session of the account
+func (a API) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, fmt.Sprintf(`{"status":"error","message":"Password must be at least %d characters"}`, minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	res, err := a.RepoClient.ResetPassword(r.Context(), &pb.ResetPasswordRequest{Token: req.Token, Password: req.Password})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	if err := session.RevokeUser(r.Context(), res.Id); err != nil {
+		log.Printf("Failed to revoke sessions of user %s after password reset: %v", res.Id, err)
+	}
+
+	clearAuthCookies(w)
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
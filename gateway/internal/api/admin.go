@@ -0,0 +1,86 @@
+package api
+
+import (
+	"codek7/common/pb"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lumbrjx/codek7/gateway/internal/session"
+	"google.golang.org/grpc/status"
+)
+
+// ListUsers pages through every account: GET /admin/users?limit=&offset=
+func (a API) ListUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var limit, offset int
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := a.RepoClient.ListUsers(r.Context(), &pb.ListUsersRequest{Limit: int32(limit), Offset: int32(offset)})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+type setUserRoleRequest struct {
+	Role   string   `json:"role"`
+	Scopes []string `json:"scopes"`
+}
+
+// SetUserRole changes the role and extra scopes of a user. Their sessions
+// are revoked so they log in again with the new grants.
+func (a API) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	var req setUserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	userID := chi.URLParam(r, "user_id")
+	res, err := a.RepoClient.SetUserRole(r.Context(), &pb.SetUserRoleRequest{
+		UserId: userID,
+		Role:   req.Role,
+		Scopes: req.Scopes,
+	})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	if err := session.RevokeUser(r.Context(), userID); err != nil {
+		log.Printf("Failed to revoke sessions of user %s after role change: %v", userID, err)
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// CollectOrphans runs the repo orphan collector once. It only reports
+// unless ?delete=true is given.
+func (a API) CollectOrphans(w http.ResponseWriter, r *http.Request) {
+	res, err := a.RepoClient.CollectOrphans(r.Context(), &pb.CollectOrphansRequest{
+		Delete: r.URL.Query().Get("delete") == "true",
+	})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
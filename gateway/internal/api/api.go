@@ -3,12 +3,20 @@ package api
 import (
 	"codek7/common/pb"
 
+	"github.com/lumbrjx/codek7/gateway/internal/ingest"
+	"github.com/lumbrjx/codek7/gateway/internal/loginguard"
+	"github.com/lumbrjx/codek7/gateway/internal/mailer"
+	"github.com/lumbrjx/codek7/gateway/internal/media"
+	"github.com/lumbrjx/codek7/gateway/internal/oidc"
 	"github.com/lumbrjx/codek7/gateway/internal/watcher"
-	"github.com/segmentio/kafka-go"
 )
 
 type API struct {
-	Producer   *kafka.Writer
+	Ingest     *ingest.Publisher
 	RepoClient pb.RepoServiceClient
 	Hub        *watcher.Hub
+	Mailer     mailer.Mailer
+	LoginGuard *loginguard.Guard
+	OIDC       map[string]*oidc.Provider
+	Media      *media.Validator
 }
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"codek7/common/pb"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// requireSession refuses requests authenticated with an API key, so a
+// leaked key cannot mint or revoke keys
+func requireSession(w http.ResponseWriter, r *http.Request) bool {
+	if _, isKey := utils.GetAPIKeyID(r.Context()); isKey {
+		http.Error(w, `{"status":"error","message":"API keys are managed from a logged in session"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// CreateAPIKey issues a key for the caller. The secret is only in this
+// response, the repo service keeps a hash.
+func (a API) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !requireSession(w, r) {
+		return
+	}
+
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	grpcReq := &pb.CreateApiKeyRequest{Name: req.Name, Scopes: req.Scopes}
+	if req.ExpiresAt != nil {
+		grpcReq.ExpiresAt = req.ExpiresAt.Format(time.RFC3339)
+	}
+
+	res, err := a.RepoClient.CreateApiKey(r.Context(), grpcReq)
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// ListAPIKeys lists the caller's keys without their secrets
+func (a API) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if !requireSession(w, r) {
+		return
+	}
+
+	res, err := a.RepoClient.ListApiKeys(r.Context(), &emptypb.Empty{})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// RevokeAPIKey stops a key from authenticating, effective immediately
+func (a API) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !requireSession(w, r) {
+		return
+	}
+
+	_, err := a.RepoClient.RevokeApiKey(r.Context(), &pb.RevokeApiKeyRequest{Id: chi.URLParam(r, "key_id")})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,13 +1,22 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"codek7/common/pb"
 
+	"github.com/lumbrjx/codek7/gateway/internal/session"
 	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func (a API) Register(w http.ResponseWriter, r *http.Request) {
@@ -22,20 +31,26 @@ func (a API) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := utils.HashPassword(user.Password)
-	if err != nil {
-		http.Error(w, "Failed to register user the request", http.StatusInternalServerError)
-	}
+	// The repo service hashes the password
 	ur, err := a.RepoClient.CreateUser(r.Context(), &pb.CreateUserRequest{
 		Username: user.Username,
-		Password: hashedPassword,
+		Password: user.Password,
 		Email:    user.Email,
 	})
+	if status.Code(err) == codes.InvalidArgument {
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
+	// The account works right away, uploads unlock once the email is verified
+	if err := a.sendVerificationMail(r.Context(), ur.Id); err != nil {
+		log.Printf("Failed to send verification mail to user %s: %v", ur.Id, err)
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(ur); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -44,8 +59,10 @@ func (a API) Register(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a API) Login(w http.ResponseWriter, r *http.Request) {
+	// username may also hold an email address, email is accepted as an alias
 	var user struct {
 		Username string `json:"username"`
+		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 
@@ -53,41 +70,66 @@ func (a API) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
+	login := user.Username
+	if login == "" {
+		login = user.Email
+	}
 
-	hashedPassword, err := utils.HashPassword(user.Password)
+	ip := clientIP(r)
+	account, err := a.loginAccount(r.Context(), login)
 	if err != nil {
-		http.Error(w, "Failed to login user the request", http.StatusInternalServerError)
+		log.Printf("Failed to resolve login: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
 	}
-	println("Username:", user.Username)
-	res, err := a.RepoClient.GetUser(r.Context(), &pb.GetUserRequest{
-		Username: user.Username,
-	})
+	wait, err := a.LoginGuard.Check(r.Context(), account, ip)
 	if err != nil {
-		fmt.Println("Failed to get user: %v", err)
-		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		log.Printf("Failed to check login throttle: %v", err)
+		http.Error(w, "Login temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if wait > 0 {
+		tooManyAttempts(w, wait)
 		return
 	}
 
-	fmt.Println("password:", res.Password)
-	fmt.Println("hashedPassword:", hashedPassword)
-	if res == nil || utils.CheckPasswordHash(user.Password, res.Password) == false {
+	// The repo service compares the password, unknown accounts answer the
+	// same as wrong passwords and count towards the lockout too
+	res, err := a.RepoClient.VerifyCredentials(r.Context(), &pb.VerifyCredentialsRequest{
+		UsernameOrEmail: login,
+		Password:        user.Password,
+	})
+	if status.Code(err) == codes.Unauthenticated {
+		if _, err := a.LoginGuard.Failure(r.Context(), account, ip); err != nil {
+			log.Printf("Failed to record login failure: %v", err)
+		}
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
+	if err != nil {
+		log.Printf("Failed to verify credentials: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	if err := a.LoginGuard.Success(r.Context(), account); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
+	// With two-factor enabled the password only earns an interim token,
+	// the session is issued by LoginSecondFactor
+	if res.TotpEnabled {
+		startSecondFactor(w, r, res.Id)
+		return
+	}
 
-	jwtT, err := utils.GenToken(res.Id)
+	tokens, err := session.Create(r.Context(), session.User{ID: res.Id, Role: res.Role, Scopes: res.Scopes})
 	if err != nil {
+		log.Printf("Failed to create session: %v", err)
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    jwtT,
-		Path:     "/",
-		MaxAge:   3600, // 1 hour
-		HttpOnly: true,
-	})
+	setAuthCookies(w, tokens)
 
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(res); err != nil {
@@ -98,14 +140,118 @@ func (a API) Login(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (a API) Logout(w http.ResponseWriter, r *http.Request) {
-	// delete cookie
+// loginAccount names the account a login is throttled under: the user ID,
+// so the username and the email share one budget, or the login itself when
+// no account matches
+func (a API) loginAccount(ctx context.Context, login string) (string, error) {
+	res, err := a.RepoClient.ResolveLogin(ctx, &pb.ResolveLoginRequest{UsernameOrEmail: login})
+	if err != nil {
+		return "", err
+	}
+	if res.UserId == "" {
+		return "name:" + login, nil
+	}
+	return res.UserId, nil
+}
+
+// clientIP is the address of the caller. middlewares.RealIP has already
+// applied the X-Forwarded-For or X-Real-IP header of a trusted proxy.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// tooManyAttempts refuses a throttled or locked out login
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many login attempts, try again later", http.StatusTooManyRequests)
+}
+
+// setAuthCookies stores the access token for every route and the refresh
+// token only for the auth routes
+func setAuthCookies(w http.ResponseWriter, tokens *session.Tokens) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
-		Value:    "",
+		Value:    tokens.AccessToken,
 		Path:     "/",
-		MaxAge:   -1,
+		MaxAge:   int(utils.AccessTokenTTL().Seconds()),
 		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    tokens.RefreshToken,
+		Path:     "/auth",
+		MaxAge:   int(session.RefreshTokenTTL().Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearAuthCookies(w http.ResponseWriter) {
+	for _, c := range []struct{ name, path string }{{"session_token", "/"}, {"refresh_token", "/auth"}} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     c.name,
+			Value:    "",
+			Path:     c.path,
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+	}
+}
+
+// Refresh rotates the refresh token and issues a new access token. A refresh
+// token that was already used revokes the whole session.
+func (a API) Refresh(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil || cookie.Value == "" {
+		http.Error(w, "Unauthorized: Missing refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := session.Refresh(r.Context(), cookie.Value)
+	if err != nil {
+		if errors.Is(err, session.ErrInvalidRefreshToken) || errors.Is(err, session.ErrRefreshTokenReused) {
+			log.Printf("Refresh rejected: %v", err)
+			clearAuthCookies(w)
+			http.Error(w, "Unauthorized: Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Failed to refresh session: %v", err)
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+	setAuthCookies(w, tokens)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":     "success",
+		"expires_at": tokens.AccessClaims.ExpiresAt.Time.UTC().Format(time.RFC3339),
+	})
+}
+
+func (a API) Logout(w http.ResponseWriter, r *http.Request) {
+	// End the session server-side so the tokens stop working even if copied
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		if claims, err := utils.ValidateToken(cookie.Value); err == nil {
+			if err := session.RevokeAccessToken(r.Context(), claims); err != nil {
+				log.Printf("Failed to revoke access token: %v", err)
+			}
+			if err := session.Revoke(r.Context(), claims.SessionID); err != nil {
+				log.Printf("Failed to revoke session: %v", err)
+			}
+		}
+	}
+
+	// delete cookies
+	clearAuthCookies(w)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// JWKS publishes the public keys access tokens can be verified with
+func (a API) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(utils.JWKS())
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"codek7/common/pb"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// repoFile is an io.ReadSeeker over a file served by the repo DownloadVideo
+// RPC. Reads continue the open stream; after a seek elsewhere the next read
+// asks the repo for the range starting at the new offset, limited to what
+// is left of the requested range holding it. This lets http.ServeContent
+// answer Range requests without buffering or over-fetching the file.
+type repoFile struct {
+	ctx      context.Context
+	client   pb.RepoServiceClient
+	videoID  string
+	fileName string
+	etag     string
+	size     int64
+	pos      int64
+	// ranges maps the start of each requested range to its length
+	ranges map[int64]int64
+
+	stream    pb.RepoService_DownloadVideoClient
+	cancel    context.CancelFunc
+	streamPos int64
+	streamEnd int64
+	buf       []byte
+}
+
+// openRepoFile starts streaming the original of videoID, or fileName when
+// videoID is empty, and returns the reader along with the file metadata sent
+// ahead of the chunks. rangeHeader is the Range header of the request that
+// will be served from the file.
+func openRepoFile(ctx context.Context, client pb.RepoServiceClient, videoID, fileName, rangeHeader string) (*repoFile, *pb.VideoFileMetadata, error) {
+	f := &repoFile{ctx: ctx, client: client, videoID: videoID, fileName: fileName}
+
+	// Without a Range header the whole file is read from the start.
+	// Otherwise only the metadata is needed until the ranges are known.
+	var length int64
+	if rangeHeader != "" {
+		length = 1
+	}
+	meta, err := f.open(0, length)
+	if err != nil {
+		return nil, nil, err
+	}
+	f.etag = meta.Etag
+	f.size = meta.FileSize
+	f.ranges = parseRanges(rangeHeader, f.size)
+	return f, meta, nil
+}
+
+// open streams length bytes from offset, through the end of the file when
+// length is 0
+func (f *repoFile) open(offset, length int64) (*pb.VideoFileMetadata, error) {
+	f.Close()
+
+	ctx, cancel := context.WithCancel(f.ctx)
+	stream, err := f.client.DownloadVideo(ctx, &pb.DownloadVideoRequest{
+		VideoId:  f.videoID,
+		FileName: f.fileName,
+		Offset:   offset,
+		Length:   length,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	meta := first.GetMetadata()
+	if meta == nil {
+		cancel()
+		return nil, errors.New("expected metadata")
+	}
+	if f.etag != "" && meta.Etag != f.etag {
+		cancel()
+		return nil, fmt.Errorf("file %s changed while it was being read", meta.FileName)
+	}
+
+	f.stream = stream
+	f.cancel = cancel
+	f.streamPos = offset
+	f.streamEnd = meta.Offset + meta.Length
+	return meta, nil
+}
+
+func (f *repoFile) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if f.stream == nil || f.streamPos != f.pos || f.streamPos >= f.streamEnd {
+		// Ranges that are not known, as when If-Range fails and the whole
+		// file is served, read through the end
+		if _, err := f.open(f.pos, f.rangeLength(f.pos)); err != nil {
+			return 0, err
+		}
+	}
+
+	for len(f.buf) == 0 {
+		res, err := f.stream.Recv()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		f.buf = res.GetChunk().GetData()
+	}
+
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	f.pos += int64(n)
+	f.streamPos += int64(n)
+	return n, nil
+}
+
+// rangeLength returns how many bytes from pos are left in the requested
+// range that holds pos, 0 when no range does
+func (f *repoFile) rangeLength(pos int64) int64 {
+	var n int64
+	for start, length := range f.ranges {
+		if start <= pos && pos < start+length {
+			n = max(n, start+length-pos)
+		}
+	}
+	return n
+}
+
+func (f *repoFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close drops the open stream, if any
+func (f *repoFile) Close() error {
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.stream = nil
+	f.cancel = nil
+	f.buf = nil
+	return nil
+}
+
+// parseRanges reads the byte ranges of a Range header for a file of size
+// bytes. Malformed headers yield no ranges, http.ServeContent rejects or
+// ignores them itself.
+func parseRanges(header string, size int64) map[int64]int64 {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil
+	}
+	ranges := make(map[int64]int64)
+	for _, part := range strings.Split(spec, ",") {
+		first, last, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil
+		}
+		var start, end int64
+		if first == "" {
+			// -n is the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n <= 0 {
+				return nil
+			}
+			start, end = max(size-n, 0), size-1
+		} else {
+			var err error
+			if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+				return nil
+			}
+			end = size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil
+				}
+				end = min(end, size-1)
+			}
+		}
+		if start >= size {
+			continue
+		}
+		ranges[start] = max(ranges[start], end-start+1)
+	}
+	return ranges
+}
+
+// quoteETag turns a storage ETag into the quoted form HTTP expects
+func quoteETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return `"` + etag + `"`
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"codek7/common/pb"
+	"context"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		size   int64
+		want   map[int64]int64
+	}{
+		{"no header", "", 1000, nil},
+		{"closed range", "bytes=0-99", 1000, map[int64]int64{0: 100}},
+		{"open range", "bytes=900-", 1000, map[int64]int64{900: 100}},
+		{"suffix range", "bytes=-100", 1000, map[int64]int64{900: 100}},
+		{"suffix longer than file", "bytes=-5000", 1000, map[int64]int64{0: 1000}},
+		{"end past size", "bytes=500-4999", 1000, map[int64]int64{500: 500}},
+		{"several ranges", "bytes=0-9, 20-29", 1000, map[int64]int64{0: 10, 20: 10}},
+		{"same start keeps longest", "bytes=0-9,0-49", 1000, map[int64]int64{0: 50}},
+		{"start past size", "bytes=2000-", 1000, map[int64]int64{}},
+		{"other unit", "items=0-9", 1000, nil},
+		{"end before start", "bytes=10-5", 1000, nil},
+		{"missing dash", "bytes=10", 1000, nil},
+		{"empty suffix", "bytes=-0", 1000, nil},
+		{"not a number", "bytes=a-b", 1000, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRanges(tt.header, tt.size)
+			if (got == nil) != (tt.want == nil) || !maps.Equal(got, tt.want) {
+				t.Errorf("parseRanges(%q, %d) = %v, want %v", tt.header, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeDownloads serves data through DownloadVideo and records the ranges
+// asked for
+type fakeDownloads struct {
+	pb.RepoServiceClient
+	data     []byte
+	requests [][2]int64
+}
+
+func (c *fakeDownloads) DownloadVideo(ctx context.Context, in *pb.DownloadVideoRequest, opts ...grpc.CallOption) (pb.RepoService_DownloadVideoClient, error) {
+	end := int64(len(c.data))
+	if in.Length > 0 {
+		end = min(in.Offset+in.Length, end)
+	}
+	c.requests = append(c.requests, [2]int64{in.Offset, in.Length})
+
+	s := &fakeDownloadStream{msgs: []*pb.VideoFileResponse{{Data: &pb.VideoFileResponse_Metadata{Metadata: &pb.VideoFileMetadata{
+		FileName: "clip.mp4",
+		FileSize: int64(len(c.data)),
+		Etag:     "etag",
+		Offset:   in.Offset,
+		Length:   end - in.Offset,
+	}}}}}
+	// Small chunks so reads span several of them
+	for i := in.Offset; i < end; i += 7 {
+		chunk := c.data[i:min(i+7, end)]
+		s.msgs = append(s.msgs, &pb.VideoFileResponse{Data: &pb.VideoFileResponse_Chunk{Chunk: &pb.VideoFileChunk{Data: chunk}}})
+	}
+	return s, nil
+}
+
+// fetched returns how many bytes the requests asked for
+func (c *fakeDownloads) fetched() int64 {
+	var n int64
+	for _, r := range c.requests {
+		if r[1] == 0 {
+			n += int64(len(c.data)) - r[0]
+		} else {
+			n += min(r[1], int64(len(c.data))-r[0])
+		}
+	}
+	return n
+}
+
+type fakeDownloadStream struct {
+	grpc.ClientStream
+	msgs []*pb.VideoFileResponse
+}
+
+func (s *fakeDownloadStream) Recv() (*pb.VideoFileResponse, error) {
+	if len(s.msgs) == 0 {
+		return nil, io.EOF
+	}
+	msg := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return msg, nil
+}
+
+func TestRepoFileServeContent(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+		parts  [][2]int // byte ranges expected in the body
+		// fetched is the most the repo may be asked for, 1 byte more than
+		// the ranges for the metadata read
+		fetched int64
+	}{
+		{"whole file", "", http.StatusOK, [][2]int{{0, 1000}}, 1000},
+		{"range at 0", "bytes=0-99", http.StatusPartialContent, [][2]int{{0, 100}}, 101},
+		{"range inside", "bytes=500-599", http.StatusPartialContent, [][2]int{{500, 600}}, 101},
+		{"open range", "bytes=990-", http.StatusPartialContent, [][2]int{{990, 1000}}, 11},
+		{"suffix range", "bytes=-10", http.StatusPartialContent, [][2]int{{990, 1000}}, 11},
+		{"several ranges", "bytes=0-9,100-109", http.StatusPartialContent, [][2]int{{0, 10}, {100, 110}}, 21},
+		{"several ranges past 0", "bytes=20-29,40-49,60-69", http.StatusPartialContent, [][2]int{{20, 30}, {40, 50}, {60, 70}}, 31},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeDownloads{data: data}
+			r := httptest.NewRequest(http.MethodGet, "/videos/v/download", nil)
+			if tt.header != "" {
+				r.Header.Set("Range", tt.header)
+			}
+			f, meta, err := openRepoFile(r.Context(), client, "v", "", tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			w := httptest.NewRecorder()
+			http.ServeContent(w, r, meta.FileName, time.Time{}, f)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			body := w.Body.Bytes()
+			if len(tt.parts) == 1 {
+				want := data[tt.parts[0][0]:tt.parts[0][1]]
+				if !bytes.Equal(body, want) {
+					t.Errorf("body has %d bytes, want %d bytes from %d", len(body), len(want), tt.parts[0][0])
+				}
+			} else {
+				for _, p := range tt.parts {
+					if !bytes.Contains(body, data[p[0]:p[1]]) {
+						t.Errorf("multipart body lacks bytes %d-%d", p[0], p[1]-1)
+					}
+				}
+			}
+			if got := client.fetched(); got > tt.fetched {
+				t.Errorf("fetched %d bytes in %v, want at most %d", got, client.requests, tt.fetched)
+			}
+		})
+	}
+}
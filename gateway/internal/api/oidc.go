@@ -0,0 +1,191 @@
+package api
+
+import (
+	"codek7/common/pb"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lumbrjx/codek7/gateway/internal/audit"
+	"github.com/lumbrjx/codek7/gateway/internal/oidc"
+	"github.com/lumbrjx/codek7/gateway/internal/session"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// oidcStateCookie binds a login to the browser that started it, so a
+// callback URL lured into another browser cannot log it into our account
+const oidcStateCookie = "oidc_state"
+
+// safeReturnTo keeps post-login redirects inside the web app
+func safeReturnTo(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+// oidcLoginFailed sends the browser back to the web app's login page with a
+// short reason it can show
+func oidcLoginFailed(w http.ResponseWriter, r *http.Request, reason string) {
+	http.Redirect(w, r, appURL("/login", url.Values{"error": {reason}}), http.StatusFound)
+}
+
+// OIDCProviders lists the configured identity providers for the login page
+func (a API) OIDCProviders(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(a.OIDC))
+	for name := range a.OIDC {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	json.NewEncoder(w).Encode(map[string]any{"providers": names})
+}
+
+// OIDCLogin starts a login at a provider. return_to is the web app path the
+// browser lands on afterwards.
+func (a API) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := a.OIDC[chi.URLParam(r, "provider")]
+	if !ok {
+		http.Error(w, `{"status":"error","message":"Unknown identity provider"}`, http.StatusNotFound)
+		return
+	}
+
+	var state, nonce, verifier string
+	var err error
+	for _, v := range []*string{&state, &nonce, &verifier} {
+		if *v, err = oidc.NewRandom(); err != nil {
+			http.Error(w, `{"status":"error","message":"Failed to start login"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("Failed to start OIDC login with %s: %v", provider.Name(), err)
+		http.Error(w, `{"status":"error","message":"Identity provider unavailable"}`, http.StatusBadGateway)
+		return
+	}
+
+	err = oidc.SaveState(r.Context(), state, oidc.LoginState{
+		Provider: provider.Name(),
+		Verifier: verifier,
+		Nonce:    nonce,
+		ReturnTo: safeReturnTo(r.URL.Query().Get("return_to")),
+	})
+	if err != nil {
+		log.Printf("Failed to save OIDC login state: %v", err)
+		http.Error(w, `{"status":"error","message":"Failed to start login"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Lax, not Strict: the callback is a cross-site top-level navigation
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidc.StateTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a login: it checks the state, redeems the code,
+// verifies the ID token and issues a session like a password login does
+func (a API) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := a.OIDC[chi.URLParam(r, "provider")]
+	if !ok {
+		http.Error(w, `{"status":"error","message":"Unknown identity provider"}`, http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	cookie, err := r.Cookie(oidcStateCookie)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/auth/oidc", MaxAge: -1, HttpOnly: true})
+	if err != nil || q.Get("state") == "" || cookie.Value != q.Get("state") {
+		oidcLoginFailed(w, r, "invalid_state")
+		return
+	}
+
+	ls, err := oidc.TakeState(r.Context(), q.Get("state"))
+	if err != nil || ls.Provider != provider.Name() {
+		if err != nil && !errors.Is(err, oidc.ErrInvalidState) {
+			log.Printf("Failed to load OIDC login state: %v", err)
+		}
+		oidcLoginFailed(w, r, "invalid_state")
+		return
+	}
+
+	// The user declined or the provider refused
+	if e := q.Get("error"); e != "" {
+		log.Printf("OIDC login with %s failed at the provider: %s %s", provider.Name(), e, q.Get("error_description"))
+		oidcLoginFailed(w, r, "provider_error")
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), q.Get("code"), ls.Verifier, ls.Nonce)
+	if err != nil {
+		log.Printf("OIDC login with %s failed: %v", provider.Name(), err)
+		oidcLoginFailed(w, r, "provider_error")
+		return
+	}
+
+	res, err := a.RepoClient.LoginWithIdentity(r.Context(), &pb.IdentityLoginRequest{
+		Provider:      provider.Name(),
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		DisplayName:   identity.Name,
+		AvatarUrl:     identity.Picture,
+	})
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.AlreadyExists:
+		oidcLoginFailed(w, r, "account_exists")
+		return
+	case codes.FailedPrecondition:
+		oidcLoginFailed(w, r, "email_required")
+		return
+	default:
+		log.Printf("Failed to log in identity from %s: %v", provider.Name(), err)
+		oidcLoginFailed(w, r, "server_error")
+		return
+	}
+
+	user := res.User
+	if user.TotpEnabled {
+		token, err := session.StartMFA(r.Context(), user.Id)
+		if err != nil {
+			log.Printf("Failed to start second factor: %v", err)
+			oidcLoginFailed(w, r, "server_error")
+			return
+		}
+		http.Redirect(w, r, appURL("/login/2fa", url.Values{"mfa_token": {token}, "return_to": {ls.ReturnTo}}), http.StatusFound)
+		return
+	}
+
+	tokens, err := session.Create(r.Context(), session.User{ID: user.Id, Role: user.Role, Scopes: user.Scopes})
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		oidcLoginFailed(w, r, "server_error")
+		return
+	}
+	setAuthCookies(w, tokens)
+
+	event := "login.oidc"
+	if res.Created {
+		event = "signup.oidc"
+	}
+	audit.Record(r.Context(), event, map[string]string{
+		"provider": provider.Name(),
+		"user_id":  user.Id,
+		"ip":       clientIP(r),
+	})
+
+	http.Redirect(w, r, appBaseURL()+ls.ReturnTo, http.StatusFound)
+}
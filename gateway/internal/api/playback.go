@@ -0,0 +1,195 @@
+package api
+
+import (
+	"codek7/common/pb"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lumbrjx/codek7/gateway/internal/infra"
+	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/status"
+)
+
+const defaultPlaybackTokenTTL = time.Hour
+
+// playbackTokenTTL reads PLAYBACK_TOKEN_TTL, it must cover a whole viewing
+// since players fetch segments with the token they got from the playlist
+func playbackTokenTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("PLAYBACK_TOKEN_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return defaultPlaybackTokenTTL
+}
+
+func playbackRevokedKey(videoID string) string {
+	return "playback:revoked:" + videoID
+}
+
+// playbackAssetsKey holds the object keys of a video's asset manifest, as of
+// the last token issued for it
+func playbackAssetsKey(videoID string) string {
+	return "playback:assets:" + videoID
+}
+
+type playbackTokenResponse struct {
+	Token     string `json:"token"`
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// CreatePlaybackToken issues a signed token for streaming one video over /hls
+func (a API) CreatePlaybackToken(w http.ResponseWriter, r *http.Request) {
+	videoID := chi.URLParam(r, "video_id")
+
+	if _, err := a.RepoClient.GetVideoByID(r.Context(), &pb.GetVideoRequest{VideoId: videoID}); err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+	assets, err := a.RepoClient.ListVideoAssets(r.Context(), &pb.GetVideoRequest{VideoId: videoID})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+	if err := cachePlaybackAssets(r.Context(), videoID, assets.Assets); err != nil {
+		log.Printf("Failed to cache assets of %s: %v", videoID, err)
+		http.Error(w, `{"status":"error","message":"Failed to issue playback token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	token, claims, err := utils.GenPlaybackToken(videoID, playbackTokenTTL())
+	if err != nil {
+		log.Printf("Failed to sign playback token: %v", err)
+		http.Error(w, `{"status":"error","message":"Failed to issue playback token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(playbackTokenResponse{
+		Token:     token,
+		URL:       "/hls/" + videoID + "_master.m3u8?token=" + url.QueryEscape(token),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339),
+	})
+}
+
+// RevokePlaybackTokens invalidates every playback token issued so far for a
+// video. Only the owner may revoke.
+func (a API) RevokePlaybackTokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+	videoID := chi.URLParam(r, "video_id")
+
+	video, err := a.RepoClient.GetVideoByID(r.Context(), &pb.GetVideoRequest{VideoId: videoID})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+	if video.UserId != userID {
+		http.Error(w, `{"status":"error","message":"Only the owner can revoke playback"}`, http.StatusForbidden)
+		return
+	}
+
+	if err := revokePlayback(r.Context(), videoID); err != nil {
+		log.Printf("Failed to revoke playback for %s: %v", videoID, err)
+		http.Error(w, `{"status":"error","message":"Failed to revoke playback"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokePlayback refuses every token issued for the video up to now. Tokens
+// carry their issue time, so the marker can expire with the last token it covers.
+func revokePlayback(ctx context.Context, videoID string) error {
+	return infra.GetRDB().Set(ctx, playbackRevokedKey(videoID), time.Now().UnixNano(), playbackTokenTTL()).Err()
+}
+
+// cachePlaybackAssets keeps the object keys of assets for the playback
+// tokens of a video to check against, as long as the tokens last
+func cachePlaybackAssets(ctx context.Context, videoID string, assets []*pb.VideoAsset) error {
+	key := playbackAssetsKey(videoID)
+	_, err := infra.GetRDB().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		for _, a := range assets {
+			pipe.SAdd(ctx, key, a.ObjectKey)
+		}
+		pipe.Expire(ctx, key, playbackTokenTTL())
+		return nil
+	})
+	return err
+}
+
+// authorizePlayback checks the token query parameter against the object key
+// being fetched and the revocation marker of its video
+func authorizePlayback(ctx context.Context, objectKey, token string) (*utils.PlaybackClaims, int, error) {
+	claims, err := utils.ValidatePlaybackToken(token)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	if strings.Contains(objectKey, "..") {
+		return nil, http.StatusForbidden, errors.New("token does not cover this object")
+	}
+	registered, err := infra.GetRDB().SIsMember(ctx, playbackAssetsKey(claims.VideoID), objectKey).Result()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !registered {
+		return nil, http.StatusForbidden, errors.New("token does not cover this object")
+	}
+
+	revokedAt, err := infra.GetRDB().Get(ctx, playbackRevokedKey(claims.VideoID)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, http.StatusInternalServerError, err
+	}
+	if claims.IssuedAt <= revokedAt {
+		return nil, http.StatusUnauthorized, errors.New("playback token revoked")
+	}
+	return claims, http.StatusOK, nil
+}
+
+var playlistURIAttr = regexp.MustCompile(`URI="([^"]*)"`)
+
+// rewritePlaylist appends the playback token to every relative URI of an
+// HLS playlist, both URI lines and URI="..." tag attributes
+func rewritePlaylist(body []byte, token string) []byte {
+	lines := strings.Split(string(body), "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.TrimSpace(line) == "":
+		case strings.HasPrefix(line, "#"):
+			line = playlistURIAttr.ReplaceAllStringFunc(line, func(attr string) string {
+				uri := playlistURIAttr.FindStringSubmatch(attr)[1]
+				return `URI="` + withPlaybackToken(uri, token) + `"`
+			})
+		default:
+			line = withPlaybackToken(strings.TrimSpace(line), token)
+		}
+		lines[i] = line
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// withPlaybackToken adds the token to a relative URI. Absolute URIs point
+// elsewhere and are left alone so the token never leaves this host.
+func withPlaybackToken(uri, token string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.IsAbs() || u.Host != "" {
+		return uri
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"codek7/common/pb"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/lumbrjx/codek7/gateway/internal/session"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// updateProfileRequest leaves absent fields unchanged, an empty string
+// clears the display name or avatar
+type updateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	Email       *string `json:"email"`
+	AvatarURL   *string `json:"avatar_url"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// checkCurrentPassword verifies the caller's password before a sensitive
+// change. Wrong passwords count towards the login lockout so a stolen
+// session cannot be used to guess it.
+func (a API) checkCurrentPassword(w http.ResponseWriter, r *http.Request, password string) (*pb.UserResponse, bool) {
+	me, ok := a.guardPasswordCheck(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	res, err := a.RepoClient.VerifyCredentials(r.Context(), &pb.VerifyCredentialsRequest{
+		UsernameOrEmail: me.Username,
+		Password:        password,
+	})
+	if status.Code(err) == codes.Unauthenticated {
+		a.rejectCurrentPassword(w, r, me)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return nil, false
+	}
+
+	return res, true
+}
+
+// guardPasswordCheck loads the caller and refuses the request while their
+// password checks are throttled
+func (a API) guardPasswordCheck(w http.ResponseWriter, r *http.Request) (*pb.UserResponse, bool) {
+	me, err := a.RepoClient.GetMe(r.Context(), &emptypb.Empty{})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return nil, false
+	}
+
+	wait, err := a.LoginGuard.Check(r.Context(), me.Id, clientIP(r))
+	if err != nil {
+		log.Printf("Failed to check login throttle: %v", err)
+		http.Error(w, `{"status":"error","message":"Password check temporarily unavailable"}`, http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if wait > 0 {
+		tooManyAttempts(w, wait)
+		return nil, false
+	}
+	return me, true
+}
+
+// rejectCurrentPassword counts a wrong current password towards the login
+// lockout and answers 403
+func (a API) rejectCurrentPassword(w http.ResponseWriter, r *http.Request, me *pb.UserResponse) {
+	if _, err := a.LoginGuard.Failure(r.Context(), me.Id, clientIP(r)); err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+	}
+	http.Error(w, `{"status":"error","message":"Current password is incorrect"}`, http.StatusForbidden)
+}
+
+// renewSession ends every session of the user and logs this client back in,
+// for changes that narrow the user's grants or invalidate old credentials
+func (a API) renewSession(ctx context.Context, w http.ResponseWriter, user *pb.UserResponse) {
+	if err := session.RevokeUser(ctx, user.Id); err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", user.Id, err)
+	}
+	tokens, err := session.Create(ctx, session.User{ID: user.Id, Role: user.Role, Scopes: user.Scopes})
+	if err != nil {
+		log.Printf("Failed to create session for user %s: %v", user.Id, err)
+		clearAuthCookies(w)
+		return
+	}
+	setAuthCookies(w, tokens)
+}
+
+// GetMe returns the caller's profile
+func (a API) GetMe(w http.ResponseWriter, r *http.Request) {
+	res, err := a.RepoClient.GetMe(r.Context(), &emptypb.Empty{})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// UpdateProfile changes the caller's display name, email or avatar. A new
+// email has to be verified again, which takes the upload scope away until
+// then, so the caller's sessions are renewed.
+func (a API) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	if !requireSession(w, r) {
+		return
+	}
+
+	var req updateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	before, err := a.RepoClient.GetMe(r.Context(), &emptypb.Empty{})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	res, err := a.RepoClient.UpdateProfile(r.Context(), &pb.UpdateProfileRequest{
+		DisplayName: req.DisplayName,
+		Email:       req.Email,
+		AvatarUrl:   req.AvatarURL,
+	})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	if res.Email != before.Email {
+		a.renewSession(r.Context(), w, res)
+		if err := a.sendVerificationMail(r.Context(), res.Id); err != nil {
+			log.Printf("Failed to send verification mail to user %s: %v", res.Id, err)
+		}
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// ChangePassword sets a new password once the current one is confirmed.
+// Every other session of the account ends.
+func (a API) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if !requireSession(w, r) {
+		return
+	}
+
+	var req changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.NewPassword) < minPasswordLength {
+		http.Error(w, fmt.Sprintf(`{"status":"error","message":"Password must be at least %d characters"}`, minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	me, ok := a.guardPasswordCheck(w, r)
+	if !ok {
+		return
+	}
+
+	// The repo service confirms the current password before storing the new one
+	_, err := a.RepoClient.ChangePassword(r.Context(), &pb.ChangePasswordRequest{
+		Password:        req.NewPassword,
+		CurrentPassword: req.CurrentPassword,
+	})
+	if status.Code(err) == codes.PermissionDenied {
+		a.rejectCurrentPassword(w, r, me)
+		return
+	}
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	a.renewSession(r.Context(), w, me)
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// DeleteAccount removes the caller's account, videos and stored files once
+// the password is confirmed
+func (a API) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	if !requireSession(w, r) {
+		return
+	}
+
+	var req deleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := a.checkCurrentPassword(w, r, req.Password)
+	if !ok {
+		return
+	}
+
+	res, err := a.RepoClient.DeleteAccount(r.Context(), &emptypb.Empty{})
+	if err != nil {
+		log.Printf("Failed to delete account of user %s: %v", user.Id, err)
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	if err := session.RevokeUser(r.Context(), user.Id); err != nil {
+		log.Printf("Failed to revoke sessions of deleted user %s: %v", user.Id, err)
+	}
+
+	clearAuthCookies(w)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":         "success",
+		"videos_removed": res.VideosRemoved,
+	})
+}
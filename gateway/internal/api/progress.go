@@ -0,0 +1,64 @@
+package api
+
+import (
+	"io"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/watcher"
+)
+
+// progressInterval spaces out progress events of a stage, the last one is
+// always sent
+const progressInterval = time.Second
+
+// progressReporter turns byte counts of an upload stage into throttled
+// progress events on the notification hub
+type progressReporter struct {
+	hub      *watcher.Hub
+	userID   string
+	videoID  string
+	uploadID string
+	stage    string
+	total    int64
+	last     int64
+	lastSent time.Time
+}
+
+func (a API) newProgress(userID, videoID, uploadID, stage string, total int64) *progressReporter {
+	return &progressReporter{
+		hub:      a.Hub,
+		userID:   userID,
+		videoID:  videoID,
+		uploadID: uploadID,
+		stage:    stage,
+		total:    total,
+		last:     -1,
+	}
+}
+
+func (p *progressReporter) report(done int64) {
+	if p.hub == nil || done == p.last || (done < p.total && time.Since(p.lastSent) < progressInterval) {
+		return
+	}
+	p.last = done
+	p.lastSent = time.Now()
+
+	n := watcher.NewProgress(p.userID, p.videoID, p.stage, "gateway", done, p.total)
+	n.UploadID = p.uploadID
+	p.hub.SendNotification(n)
+}
+
+// progressBody reports the bytes read from a request body, starting at
+// offset
+type progressBody struct {
+	io.ReadCloser
+	progress *progressReporter
+	offset   int64
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.offset += int64(n)
+	b.progress.report(b.offset)
+	return n, err
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"codek7/common/pb"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// GetQuota returns the caller's storage usage and limits
+func (a API) GetQuota(w http.ResponseWriter, r *http.Request) {
+	res, err := a.RepoClient.GetQuota(r.Context(), &emptypb.Empty{})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// checkUploadQuota asks the repo service whether the caller may upload an
+// original of size bytes, 0 if not known yet. It answers the request when
+// they may not.
+func (a API) checkUploadQuota(w http.ResponseWriter, r *http.Request, size int64) bool {
+	_, err := a.RepoClient.CheckUploadQuota(r.Context(), &pb.CheckUploadQuotaRequest{FileSize: size})
+	if err == nil {
+		return true
+	}
+	code := repoErrorStatus(err)
+	if code == http.StatusInternalServerError {
+		log.Printf("Failed to check upload quota: %v", err)
+	}
+	body, _ := json.Marshal(map[string]string{"status": "error", "message": status.Convert(err).Message()})
+	http.Error(w, string(body), code)
+	return false
+}
+
+// GetUserQuota returns the storage usage and limits of a user
+func (a API) GetUserQuota(w http.ResponseWriter, r *http.Request) {
+	res, err := a.RepoClient.GetUserQuota(r.Context(), &pb.GetUserQuotaRequest{UserId: chi.URLParam(r, "user_id")})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// setUserQuotaRequest leaves absent limits unchanged, a negative value
+// returns a limit to the default and 0 makes it unlimited
+type setUserQuotaRequest struct {
+	MaxBytes     *int64 `json:"max_bytes"`
+	MaxVideos    *int32 `json:"max_videos"`
+	MaxFileBytes *int64 `json:"max_file_bytes"`
+}
+
+// SetUserQuota changes the storage limits of a user
+func (a API) SetUserQuota(w http.ResponseWriter, r *http.Request) {
+	var req setUserQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	res, err := a.RepoClient.SetUserQuota(r.Context(), &pb.SetUserQuotaRequest{
+		UserId:       chi.URLParam(r, "user_id"),
+		MaxBytes:     req.MaxBytes,
+		MaxVideos:    req.MaxVideos,
+		MaxFileBytes: req.MaxFileBytes,
+	})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/lumbrjx/codek7/gateway/internal/media"
+	"github.com/lumbrjx/codek7/gateway/internal/watcher"
+	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+)
+
+// Resumable uploads follow the shape of the tus protocol: a session is
+// created with the total length, byte ranges are appended with PATCH at the
+// current Upload-Offset, HEAD reports the offset to resume from, and the
+// finalize call hands the assembled file to the Kafka pipeline.
+
+type createUploadRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	FileName    string `json:"file_name"`
+	Length      int64  `json:"length"`
+}
+
+type uploadSessionResponse struct {
+	UploadID  string `json:"upload_id"`
+	VideoID   string `json:"video_id"`
+	Offset    int64  `json:"offset"`
+	Length    int64  `json:"length"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func setUploadHeaders(w http.ResponseWriter, s *UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(s.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// loadOwnedUpload fetches the session and hides sessions owned by other users
+func loadOwnedUpload(w http.ResponseWriter, r *http.Request) (*UploadSession, bool) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	s, err := getUploadSession(r.Context(), chi.URLParam(r, "upload_id"))
+	if errors.Is(err, errUploadNotFound) || (err == nil && s.UserID != userID) {
+		http.Error(w, `{"status":"error","message":"Upload not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load upload session: %v", err)
+		http.Error(w, `{"status":"error","message":"Failed to load upload"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return s, true
+}
+
+// acquireUpload takes the per-upload lock of an upload owned by the caller,
+// answering 423 when another request holds it. The session is loaded again
+// once locked since the previous holder may have changed it; callers release
+// the lock with unlock.
+func acquireUpload(w http.ResponseWriter, r *http.Request) (s *UploadSession, unlock func(), ok bool) {
+	if s, ok = loadOwnedUpload(w, r); !ok {
+		return nil, nil, false
+	}
+
+	id, token := s.ID, uuid.New().String()
+	locked, err := lockUpload(r.Context(), id, token)
+	if err != nil {
+		log.Printf("Failed to lock upload %s: %v", id, err)
+		http.Error(w, `{"status":"error","message":"Failed to lock upload"}`, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if !locked {
+		http.Error(w, `{"status":"error","message":"Upload is being modified by another request"}`, http.StatusLocked)
+		return nil, nil, false
+	}
+	unlock = func() { unlockUpload(context.Background(), id, token) }
+
+	if s, ok = loadOwnedUpload(w, r); !ok {
+		unlock()
+		return nil, nil, false
+	}
+	return s, unlock, true
+}
+
+func (a API) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req createUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Title == "" || req.Description == "" || req.Length <= 0 {
+		http.Error(w, `{"status":"error","message":"Missing required fields: title, description, or length"}`, http.StatusBadRequest)
+		return
+	}
+	if err := a.Media.CheckSize(req.Length); err != nil {
+		rejectUpload(w, err)
+		return
+	}
+	if !a.checkUploadQuota(w, r, req.Length) {
+		return
+	}
+
+	if err := os.MkdirAll(uploadDir(), 0o700); err != nil {
+		log.Printf("Failed to create upload dir: %v", err)
+		http.Error(w, `{"status":"error","message":"Failed to create upload"}`, http.StatusInternalServerError)
+		return
+	}
+
+	s := &UploadSession{
+		ID:          uuid.New().String(),
+		VideoID:     uuid.New().String(),
+		UserID:      userID,
+		Title:       req.Title,
+		Description: req.Description,
+		FileName:    req.FileName,
+		Length:      req.Length,
+		CreatedAt:   time.Now(),
+	}
+
+	f, err := os.OpenFile(uploadPartPath(s.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Failed to create upload file: %v", err)
+		http.Error(w, `{"status":"error","message":"Failed to create upload"}`, http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	if err := saveUploadSession(r.Context(), s); err != nil {
+		os.Remove(uploadPartPath(s.ID))
+		log.Printf("Failed to save upload session: %v", err)
+		http.Error(w, `{"status":"error","message":"Failed to create upload"}`, http.StatusInternalServerError)
+		return
+	}
+
+	setUploadHeaders(w, s)
+	w.Header().Set("Location", "/videos/uploads/"+s.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(uploadSessionResponse{
+		UploadID:  s.ID,
+		VideoID:   s.VideoID,
+		Offset:    s.Offset,
+		Length:    s.Length,
+		ExpiresAt: s.CreatedAt.Add(uploadSessionTTL).Format(time.RFC3339),
+	})
+}
+
+func (a API) GetUploadOffset(w http.ResponseWriter, r *http.Request) {
+	s, ok := loadOwnedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	setUploadHeaders(w, s)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a API) PatchUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, `{"status":"error","message":"Content-Type must be application/offset+octet-stream"}`, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, `{"status":"error","message":"Invalid Upload-Offset header"}`, http.StatusBadRequest)
+		return
+	}
+
+	s, unlock, ok := acquireUpload(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	id := s.ID
+
+	if offset != s.Offset {
+		setUploadHeaders(w, s)
+		http.Error(w, `{"status":"error","message":"Upload-Offset does not match the current offset"}`, http.StatusConflict)
+		return
+	}
+	if r.ContentLength > 0 && offset+r.ContentLength > s.Length {
+		http.Error(w, `{"status":"error","message":"Chunk exceeds the declared upload length"}`, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	f, err := os.OpenFile(uploadPartPath(id), os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		log.Printf("Failed to open upload file %s: %v", id, err)
+		http.Error(w, `{"status":"error","message":"Failed to open upload"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Drop any bytes written past the recorded offset by an interrupted request
+	if err := f.Truncate(offset); err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		log.Printf("Failed to position upload file %s: %v", id, err)
+		http.Error(w, `{"status":"error","message":"Failed to write upload"}`, http.StatusInternalServerError)
+		return
+	}
+
+	body := &progressBody{
+		ReadCloser: r.Body,
+		progress:   a.newProgress(s.UserID, s.VideoID, s.ID, watcher.StageReceiving, s.Length),
+		offset:     offset,
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(body, s.Length-offset))
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	// Keep whatever arrived so the client can resume from there
+	s.Offset = offset + n
+	if err := setUploadOffset(context.Background(), id, s.Offset); err != nil {
+		log.Printf("Failed to record upload offset %s: %v", id, err)
+		http.Error(w, `{"status":"error","message":"Failed to record upload offset"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Reject files that are not videos as soon as their start is in
+	if offset < media.SniffLen && s.Offset >= min(media.SniffLen, s.Length) {
+		if _, err := media.SniffFile(uploadPartPath(id)); errors.Is(err, media.ErrUnsupported) {
+			log.Printf("Rejected upload %s of user %s: %v", id, s.UserID, err)
+			discardUpload(r.Context(), id)
+			rejectUpload(w, err)
+			return
+		}
+	}
+
+	setUploadHeaders(w, s)
+	if copyErr != nil {
+		log.Printf("Upload %s interrupted at offset %d: %v", id, s.Offset, copyErr)
+		http.Error(w, `{"status":"error","message":"Upload interrupted, resume from Upload-Offset"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// discardUpload removes a rejected upload and its session
+func discardUpload(ctx context.Context, id string) {
+	if err := os.Remove(uploadPartPath(id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove upload file %s: %v", id, err)
+	}
+	if err := deleteUploadSession(ctx, id); err != nil {
+		log.Printf("Failed to delete upload session %s: %v", id, err)
+	}
+}
+
+func (a API) FinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	s, unlock, ok := acquireUpload(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	id := s.ID
+
+	if s.Offset != s.Length {
+		setUploadHeaders(w, s)
+		http.Error(w, `{"status":"error","message":"Upload is incomplete"}`, http.StatusConflict)
+		return
+	}
+
+	path := uploadPartPath(id)
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != s.Length {
+		log.Printf("Upload file %s does not match session length: %v", id, err)
+		http.Error(w, `{"status":"error","message":"Upload data is missing or corrupt"}`, http.StatusUnprocessableEntity)
+		return
+	}
+
+	mediaInfo, err := a.Media.Check(r.Context(), path)
+	if err != nil {
+		// Keep the upload when it could not be checked, finalizing again
+		// may work
+		if media.Rejected(err) {
+			log.Printf("Rejected upload %s of user %s: %v", id, s.UserID, err)
+			discardUpload(r.Context(), id)
+		}
+		rejectUpload(w, err)
+		return
+	}
+
+	// Other uploads may have used up the quota since the session was created
+	if !a.checkUploadQuota(w, r, s.Length) {
+		return
+	}
+
+	if err := deleteUploadSession(r.Context(), id); err != nil {
+		log.Printf("Failed to delete upload session %s: %v", id, err)
+		http.Error(w, `{"status":"error","message":"Failed to finalize upload"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    "success",
+		"message":   "Upload finalized and processing started",
+		"upload_id": s.ID,
+		"video_id":  s.VideoID,
+	})
+
+	go a.processFile(uploadJob{
+		Path:        path,
+		VideoID:     s.VideoID,
+		UploadID:    s.ID,
+		UserID:      s.UserID,
+		Title:       s.Title,
+		Description: s.Description,
+		FileName:    s.FileName,
+		Media:       mediaInfo,
+	})
+}
+
+func (a API) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	s, unlock, ok := acquireUpload(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	id := s.ID
+
+	if err := os.Remove(uploadPartPath(id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove upload file %s: %v", id, err)
+	}
+	if err := deleteUploadSession(r.Context(), id); err != nil {
+		log.Printf("Failed to delete upload session %s: %v", id, err)
+		http.Error(w, `{"status":"error","message":"Failed to delete upload"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,26 +1,42 @@
 package api
 
 import (
+	"bytes"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/lumbrjx/codek7/gateway/internal/infra"
 	"github.com/minio/minio-go/v7"
 )
 
+// maxPlaylistSize bounds how much of a playlist is read for rewriting
+const maxPlaylistSize = 1 << 20
+
 func (a *API) StreamFromMinIO(w http.ResponseWriter, r *http.Request) {
 	objectKey := chi.URLParam(r, "*")
 
 	log.Println("Requested object key:", objectKey)
+
+	token := r.URL.Query().Get("token")
+	if _, code, err := authorizePlayback(r.Context(), objectKey, token); err != nil {
+		log.Printf("Playback refused for %s: %v", objectKey, err)
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
 	var contentType string
+	isPlaylist := strings.HasSuffix(objectKey, ".m3u8")
 	switch {
-	case strings.HasSuffix(objectKey, ".m3u8"):
+	case isPlaylist:
 		contentType = "application/vnd.apple.mpegurl"
 	case strings.HasSuffix(objectKey, ".ts"):
 		contentType = "video/MP2T"
+	case strings.HasSuffix(objectKey, ".mp4"):
+		contentType = "video/mp4"
 	default:
 		contentType = "application/octet-stream"
 	}
@@ -32,19 +48,37 @@ func (a *API) StreamFromMinIO(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Object fetch failed", http.StatusInternalServerError)
 		return
 	}
+	defer obj.Close()
 
-	_, err = obj.Stat()
+	info, err := obj.Stat()
 	if err != nil {
 		log.Printf("MinIO Stat error: %v", err)
 		http.Error(w, "Object not found", http.StatusNotFound)
 		return
 	}
-	defer obj.Close()
 
 	w.Header().Set("Content-Type", contentType)
-	w.WriteHeader(http.StatusOK)
 
-	if _, err := io.Copy(w, obj); err != nil {
-		http.Error(w, "Failed to stream object", http.StatusInternalServerError)
+	// Playlists are rewritten so every child URI carries the token, the
+	// result depends on the token and must not be cached by shared caches
+	if isPlaylist {
+		body, err := io.ReadAll(io.LimitReader(obj, maxPlaylistSize))
+		if err != nil {
+			log.Printf("MinIO read error: %v", err)
+			http.Error(w, "Failed to read playlist", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.ServeContent(w, r, objectKey, time.Time{}, bytes.NewReader(rewritePlaylist(body, token)))
+		return
 	}
+
+	if info.ETag != "" {
+		w.Header().Set("ETag", quoteETag(info.ETag))
+	}
+	w.Header().Set("Cache-Control", "private")
+
+	// ServeContent answers Range and conditional requests, seeking the
+	// object so only the requested bytes are fetched from MinIO
+	http.ServeContent(w, r, objectKey, info.LastModified, obj)
 }
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"codek7/common/pb"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/lumbrjx/codek7/gateway/internal/session"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type secondFactorRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type disableTotpRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// startSecondFactor answers a correct password of a 2FA user with an
+// interim token instead of a session
+func startSecondFactor(w http.ResponseWriter, r *http.Request, userID string) {
+	token, err := session.StartMFA(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to start second factor: %v", err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":     "mfa_required",
+		"mfa_token":  token,
+		"expires_in": int(session.MFATokenTTL.Seconds()),
+	})
+}
+
+// LoginSecondFactor completes a login with a TOTP or recovery code and the
+// interim token from the password step
+func (a API) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req secondFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// The attempt is counted before the code is checked, so parallel
+	// requests cannot try more codes than the token allows
+	userID, err := session.MFAAttempt(r.Context(), req.MFAToken)
+	if err == session.ErrInvalidMFAToken {
+		http.Error(w, "Login expired, enter your password again", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load mfa token: %v", err)
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+
+	wait, err := a.LoginGuard.CheckMFA(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to check mfa throttle: %v", err)
+		http.Error(w, "Login temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
+
+	res, err := a.RepoClient.VerifyTotp(r.Context(), &pb.VerifyTotpRequest{UserId: userID, Code: req.Code})
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.Unauthenticated:
+		if _, err := a.LoginGuard.MFAFailure(r.Context(), userID, clientIP(r)); err != nil {
+			log.Printf("Failed to record mfa failure: %v", err)
+		}
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	case codes.FailedPrecondition:
+		// Two-factor was disabled since the password step
+		http.Error(w, "Login expired, enter your password again", http.StatusUnauthorized)
+		return
+	default:
+		log.Printf("Failed to verify second factor of user %s: %v", userID, err)
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+
+	if ok, err := session.FinishMFA(r.Context(), req.MFAToken); err != nil || !ok {
+		http.Error(w, "Login expired, enter your password again", http.StatusUnauthorized)
+		return
+	}
+	if err := a.LoginGuard.MFASuccess(r.Context(), userID); err != nil {
+		log.Printf("Failed to reset mfa failures: %v", err)
+	}
+
+	tokens, err := session.Create(r.Context(), session.User{ID: res.Id, Role: res.Role, Scopes: res.Scopes})
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	setAuthCookies(w, tokens)
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// GetTwoFactor reports whether 2FA is on and how many recovery codes are left
+func (a API) GetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	res, err := a.RepoClient.GetTotpStatus(r.Context(), &emptypb.Empty{})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// EnrollTwoFactor creates a pending secret. The provisioning URI is meant
+// to be shown as a QR code; nothing changes until it is confirmed.
+func (a API) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !requireSession(w, r) {
+		return
+	}
+
+	res, err := a.RepoClient.BeginTotpEnrollment(r.Context(), &emptypb.Empty{})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// ConfirmTwoFactor enables 2FA with a first code and returns the recovery
+// codes. Other sessions, which were opened without the second factor, end.
+func (a API) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !requireSession(w, r) {
+		return
+	}
+
+	var req totpCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	res, err := a.RepoClient.ConfirmTotpEnrollment(r.Context(), &pb.TotpCodeRequest{Code: req.Code})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	if me, err := a.RepoClient.GetMe(r.Context(), &emptypb.Empty{}); err == nil {
+		a.renewSession(r.Context(), w, me)
+	} else {
+		log.Printf("Failed to renew session after enabling two-factor: %v", err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "recovery_codes": res.Codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, the old ones stop
+// working
+func (a API) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if !requireSession(w, r) {
+		return
+	}
+
+	var req totpCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	res, err := a.RepoClient.RegenerateTotpRecoveryCodes(r.Context(), &pb.TotpCodeRequest{Code: req.Code})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "recovery_codes": res.Codes})
+}
+
+// DisableTwoFactor turns 2FA off. It takes both the password and a code, so
+// neither a stolen session nor a stolen phone is enough.
+func (a API) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !requireSession(w, r) {
+		return
+	}
+
+	var req disableTotpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := a.checkCurrentPassword(w, r, req.Password); !ok {
+		return
+	}
+
+	if _, err := a.RepoClient.DisableTotp(r.Context(), &pb.TotpCodeRequest{Code: req.Code}); err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
@@ -2,29 +2,100 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lumbrjx/codek7/gateway/internal/ingest"
+	"github.com/lumbrjx/codek7/gateway/internal/media"
+	"github.com/lumbrjx/codek7/gateway/internal/watcher"
 	"github.com/lumbrjx/codek7/gateway/pkg/utils"
-	"github.com/segmentio/kafka-go"
 )
 
-func (a API) UploadFile(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseMultipartForm(0)
-	if err != nil {
-		http.Error(w, `{"status":"error","message":"Failed to parse form"}`, http.StatusBadRequest)
+// uploadJob is an uploaded file waiting to be published. UploadID is set
+// for resumable uploads.
+type uploadJob struct {
+	Path        string
+	VideoID     string
+	UploadID    string
+	UserID      string
+	Title       string
+	Description string
+	FileName    string
+	Media       media.Info
+}
+
+// multipartOverhead is allowed on top of the file size for the form
+// fields and part headers of an upload
+const multipartOverhead = 1 << 20
+
+// rejectUpload answers with the status that fits a validation error
+func rejectUpload(w http.ResponseWriter, err error) {
+	var code int
+	switch {
+	case errors.Is(err, media.ErrUnsupported):
+		code = http.StatusUnsupportedMediaType
+	case errors.Is(err, media.ErrTooLarge):
+		code = http.StatusRequestEntityTooLarge
+	case errors.Is(err, media.ErrCorrupt), errors.Is(err, media.ErrTooLong):
+		code = http.StatusUnprocessableEntity
+	default:
+		log.Printf("Failed to check upload: %v", err)
+		http.Error(w, `{"status":"error","message":"Failed to check file"}`, http.StatusInternalServerError)
 		return
 	}
+	body, _ := json.Marshal(map[string]string{"status": "error", "message": err.Error()})
+	http.Error(w, string(body), code)
+}
+
+func (a API) UploadFile(w http.ResponseWriter, r *http.Request) {
 	userID, ok := utils.GetUserID(r.Context())
 	if !ok {
 		http.Error(w, "User ID not found in context", http.StatusUnauthorized)
 		return
 	}
+
+	// Refuse oversized uploads before reading them
+	if max := a.Media.MaxBytes(); max > 0 {
+		if r.ContentLength > max+multipartOverhead {
+			rejectUpload(w, a.Media.CheckSize(r.ContentLength-multipartOverhead))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max+multipartOverhead)
+	}
+	// Users over their quota are turned away before sending the file
+	if !a.checkUploadQuota(w, r, 0) {
+		return
+	}
+
+	// The repo service stores the video under this ID. It is returned once
+	// the body is in, its receiving progress goes to all of the user's
+	// connections.
+	videoID := uuid.New().String()
+	if r.ContentLength > 0 {
+		r.Body = &progressBody{
+			ReadCloser: r.Body,
+			progress:   a.newProgress(userID, videoID, "", watcher.StageReceiving, r.ContentLength),
+		}
+	}
+
+	err := r.ParseMultipartForm(0)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		rejectUpload(w, fmt.Errorf("%w: the limit is %d bytes", media.ErrTooLarge, a.Media.MaxBytes()))
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"status":"error","message":"Failed to parse form"}`, http.StatusBadRequest)
+		return
+	}
 	title := r.FormValue("title")
 	description := r.FormValue("description")
 
@@ -41,10 +112,23 @@ func (a API) UploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	if handler.Size == 0 {
+		http.Error(w, `{"status":"error","message":"File is empty"}`, http.StatusBadRequest)
+		return
+	}
+	if err := a.Media.CheckSize(handler.Size); err != nil {
+		rejectUpload(w, err)
+		return
+	}
+	if !a.checkUploadQuota(w, r, handler.Size) {
+		return
+	}
+
 	fmt.Printf("Receiving file: %s\n", handler.Filename)
 
-	// Save file to temp location
-	tmpFile, err := os.CreateTemp("", "upload-*.mp4")
+	// Save file to temp location, the container is only known once it
+	// has been checked
+	tmpFile, err := os.CreateTemp("", "upload-*")
 	if err != nil {
 		http.Error(w, `{"status":"error","message":"Failed to create temp file"}`, http.StatusInternalServerError)
 		return
@@ -53,84 +137,102 @@ func (a API) UploadFile(w http.ResponseWriter, r *http.Request) {
 
 	_, err = io.Copy(tmpFile, file)
 	if err != nil {
+		os.Remove(tmpFile.Name())
 		http.Error(w, `{"status":"error","message":"Failed to save file"}`, http.StatusInternalServerError)
 		return
 	}
 
+	info, err := a.Media.Check(r.Context(), tmpFile.Name())
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		log.Printf("Rejected upload %s of user %s: %v", videoID, userID, err)
+		rejectUpload(w, err)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(`{"status":"success","message":"File received and processing started"}`))
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "success",
+		"message":  "File received and processing started",
+		"video_id": videoID,
+	})
 
 	// Start async processing
-	go processFile(a.Producer, tmpFile.Name(), userID, title, description)
+	go a.processFile(uploadJob{
+		Path:        tmpFile.Name(),
+		VideoID:     videoID,
+		UserID:      userID,
+		Title:       title,
+		Description: description,
+		FileName:    handler.Filename,
+		Media:       info,
+	})
 }
 
-func processFile(producer *kafka.Writer, filePath string, userID, title, description string) {
-	defer os.Remove(filePath)
+// processFile publishes an uploaded file to Kafka and removes it. An upload
+// that cannot be delivered is aborted, marked failed and reported to the
+// user.
+func (a API) processFile(job uploadJob) {
+	defer os.Remove(job.Path)
 
-	videoID := uuid.New().String()
-	sem := make(chan struct{}, 40)
-	chunkBuf := make([]byte, 32*1024)
+	ctx := context.Background()
+	ingest.SetStatus(ctx, job.VideoID, job.UserID, ingest.StatusPublishing, "")
 
-	f, err := os.Open(filePath)
-	if err != nil {
-		log.Printf("❌ Failed to open file: %v", err)
+	m, err := a.publishFile(ctx, job)
+	if err == nil {
+		ingest.SetStatus(ctx, job.VideoID, job.UserID, ingest.StatusPublished, "")
+		log.Printf("Published upload %s: %d chunks, %d bytes", job.VideoID, m.TotalChunks, m.Size)
 		return
 	}
-	defer f.Close()
-
-	var chunks [][]byte
 
-	for {
-		n, err := f.Read(chunkBuf)
-		if err != nil && err != io.EOF {
-			log.Printf("❌ Error reading chunk: %v", err)
-			return
-		}
-		if n == 0 {
-			break
+	log.Printf("❌ Failed to publish upload %s: %v", job.VideoID, err)
+	if m.VideoID != "" {
+		if err := a.Ingest.Abort(ctx, m, err.Error()); err != nil {
+			log.Printf("Failed to abort upload %s: %v", job.VideoID, err)
 		}
-
-		chunk := make([]byte, n)
-		copy(chunk, chunkBuf[:n])
-		chunks = append(chunks, chunk)
 	}
-
-	totalChunks := len(chunks)
-	fmt.Printf("📦 Total chunks: %d\n", totalChunks)
-
-	for i, chunk := range chunks {
-		sem <- struct{}{}
-		go func(idx int, data []byte) {
-			defer func() { <-sem }()
-			produceChunk(producer, videoID, int32(idx), int32(totalChunks), data, filePath, userID, title, description)
-		}(i, chunk)
+	ingest.SetStatus(ctx, job.VideoID, job.UserID, ingest.StatusFailed, err.Error())
+	if a.Hub != nil {
+		a.Hub.SendNotification(watcher.Notification{
+			UserID:      job.UserID,
+			EventType:   watcher.EventError,
+			VideoID:     job.VideoID,
+			UploadID:    job.UploadID,
+			ServiceName: "gateway",
+			Description: fmt.Sprintf("Upload of %q failed, please try again", job.Title),
+			Timestamp:   time.Now(),
+		})
 	}
+}
 
-	// Wait for all goroutines to finish
-	for i := 0; i < cap(sem); i++ {
-		sem <- struct{}{}
+// publishFile hashes the file and publishes it. The returned manifest has a
+// video ID once anything may have reached Kafka.
+func (a API) publishFile(ctx context.Context, job uploadJob) (ingest.Manifest, error) {
+	f, err := os.Open(job.Path)
+	if err != nil {
+		return ingest.Manifest{}, fmt.Errorf("open upload: %w", err)
 	}
-}
+	defer f.Close()
 
-func produceChunk(producer *kafka.Writer, videoID string, index int32, totalChunks int32, chunk []byte, filepath string, userID, title, description string) {
-	msg := kafka.Message{
-		Key:   []byte(videoID),
-		Value: chunk,
-		Headers: []kafka.Header{
-			{Key: "chunk_index", Value: []byte(strconv.Itoa(int(index)))},
-			{Key: "total_chunks", Value: []byte(strconv.Itoa(int(totalChunks)))},
-			{Key: "file_path", Value: []byte(filepath)},
-			{Key: "user_id", Value: []byte(userID)},
-			{Key: "title", Value: []byte(title)},
-			{Key: "description", Value: []byte(description)},
-		},
-	}
-
-	err := producer.WriteMessages(context.Background(), msg)
+	h := sha256.New()
+	size, err := io.Copy(h, f)
 	if err != nil {
-		fmt.Printf("❌ Failed to send chunk %d: %v\n", index, err)
-	} else {
-		fmt.Printf("✅ Sent chunk %d/%d to Kafka\n", index+1, totalChunks)
+		return ingest.Manifest{}, fmt.Errorf("hash upload: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return ingest.Manifest{}, fmt.Errorf("rewind upload: %w", err)
 	}
+
+	if size == 0 {
+		return ingest.Manifest{}, ingest.ErrEmptyUpload
+	}
+
+	m := ingest.NewManifest(job.VideoID, job.UserID, job.Title, job.Description, job.FileName, size, h.Sum(nil))
+	m.Media = job.Media
+	progress := a.newProgress(job.UserID, job.VideoID, job.UploadID, watcher.StagePublishing, size)
+	progress.report(0)
+	return m, a.Ingest.Publish(ctx, m, f, func(sent int) {
+		progress.report(min(int64(sent)*m.ChunkSize, m.Size))
+	})
 }
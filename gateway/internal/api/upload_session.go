@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/infra"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	uploadSessionTTL = 24 * time.Hour
+	uploadLockTTL    = 5 * time.Minute
+)
+
+var errUploadNotFound = errors.New("upload session not found")
+
+// UploadSession is the state of a resumable upload, stored as a Redis hash
+type UploadSession struct {
+	ID string
+	// VideoID is assigned at creation, the repo service stores the video
+	// under it
+	VideoID     string
+	UserID      string
+	Title       string
+	Description string
+	FileName    string
+	Length      int64
+	Offset      int64
+	CreatedAt   time.Time
+}
+
+func uploadSessionKey(id string) string {
+	return "upload:" + id
+}
+
+func uploadLockKey(id string) string {
+	return "upload:" + id + ":lock"
+}
+
+// uploadDir returns the directory holding the partial upload files
+func uploadDir() string {
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "codek7-uploads")
+}
+
+// uploadPartPath returns the path of the partial file backing an upload session
+func uploadPartPath(id string) string {
+	return filepath.Join(uploadDir(), id+".part")
+}
+
+func saveUploadSession(ctx context.Context, s *UploadSession) error {
+	key := uploadSessionKey(s.ID)
+	rdb := infra.GetRDB()
+
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"video_id", s.VideoID,
+			"user_id", s.UserID,
+			"title", s.Title,
+			"description", s.Description,
+			"file_name", s.FileName,
+			"length", s.Length,
+			"offset", s.Offset,
+			"created_at", s.CreatedAt.Unix(),
+		)
+		pipe.Expire(ctx, key, uploadSessionTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("save upload session: %w", err)
+	}
+	return nil
+}
+
+func getUploadSession(ctx context.Context, id string) (*UploadSession, error) {
+	fields, err := infra.GetRDB().HGetAll(ctx, uploadSessionKey(id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get upload session: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, errUploadNotFound
+	}
+
+	length, _ := strconv.ParseInt(fields["length"], 10, 64)
+	offset, _ := strconv.ParseInt(fields["offset"], 10, 64)
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+
+	return &UploadSession{
+		ID:          id,
+		VideoID:     fields["video_id"],
+		UserID:      fields["user_id"],
+		Title:       fields["title"],
+		Description: fields["description"],
+		FileName:    fields["file_name"],
+		Length:      length,
+		Offset:      offset,
+		CreatedAt:   time.Unix(createdAt, 0),
+	}, nil
+}
+
+// setUploadOffset records the new offset and extends the session lifetime
+func setUploadOffset(ctx context.Context, id string, offset int64) error {
+	key := uploadSessionKey(id)
+	rdb := infra.GetRDB()
+
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "offset", offset)
+		pipe.Expire(ctx, key, uploadSessionTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("update upload offset: %w", err)
+	}
+	return nil
+}
+
+func deleteUploadSession(ctx context.Context, id string) error {
+	return infra.GetRDB().Del(ctx, uploadSessionKey(id)).Err()
+}
+
+// lockUpload guards an upload against concurrent writers. The lock stores
+// token, which unlockUpload needs to release it.
+func lockUpload(ctx context.Context, id, token string) (bool, error) {
+	return infra.GetRDB().SetNX(ctx, uploadLockKey(id), token, uploadLockTTL).Result()
+}
+
+// unlockScript deletes a lock only while it still holds the caller's token,
+// so a request whose lock expired cannot release the next holder's
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func unlockUpload(ctx context.Context, id, token string) {
+	if err := unlockScript.Run(ctx, infra.GetRDB(), []string{uploadLockKey(id)}, token).Err(); err != nil {
+		log.Printf("Failed to release upload lock %s: %v", id, err)
+	}
+}
+
+// StartUploadJanitor periodically removes partial files whose session expired
+func StartUploadJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sweepUploadDir()
+			}
+		}
+	}()
+}
+
+func sweepUploadDir() {
+	entries, err := os.ReadDir(uploadDir())
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < uploadSessionTTL {
+			continue
+		}
+		path := filepath.Join(uploadDir(), e.Name())
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to remove stale upload %s: %v", path, err)
+		}
+	}
+}
@@ -2,16 +2,42 @@ package api
 
 import (
 	"codek7/common/pb"
-	"context"
 	"encoding/json"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// repoErrorStatus maps a repo service error to an HTTP status
+func repoErrorStatus(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.FailedPrecondition, codes.AlreadyExists:
+		// Users at their video limit are refused this way, whatever the size
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		// Only the byte limits of storage quotas are reported as exhausted
+		return http.StatusRequestEntityTooLarge
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
 func (a API) GetVideoByID(w http.ResponseWriter, r *http.Request) {
 	videoID := chi.URLParam(r, "video_id")
 	if videoID == "" {
@@ -24,14 +50,74 @@ func (a API) GetVideoByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call gRPC
-	res, err := a.RepoClient.GetVideoByID(context.Background(), req)
+	res, err := a.RepoClient.GetVideoByID(r.Context(), req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
 		return
 	}
 
 	json.NewEncoder(w).Encode(res)
 }
+
+// GetVideoStatusHistory lists the processing status transitions of a video,
+// oldest first
+func (a API) GetVideoStatusHistory(w http.ResponseWriter, r *http.Request) {
+	videoID := chi.URLParam(r, "video_id")
+
+	res, err := a.RepoClient.GetVideoStatusHistory(r.Context(), &pb.GetVideoRequest{VideoId: videoID})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// ListVideos pages through the caller's videos:
+// GET /videos?cursor=&limit=&q=&status=&from=&to=&sort=&order=
+func (a API) ListVideos(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	var limit int
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 100)
+	}
+
+	res, err := a.RepoClient.ListVideos(r.Context(), &pb.ListVideosRequest{
+		UserId:        userID,
+		Cursor:        q.Get("cursor"),
+		Limit:         int32(limit),
+		Query:         q.Get("q"),
+		Status:        q.Get("status"),
+		CreatedAfter:  q.Get("from"),
+		CreatedBefore: q.Get("to"),
+		SortBy:        q.Get("sort"),
+		SortOrder:     q.Get("order"),
+	})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Videos     []*pb.VideoMetadataResponse `json:"videos"`
+		NextCursor string                      `json:"next_cursor"`
+	}{
+		Videos:     res.Videos,
+		NextCursor: res.NextCursor,
+	})
+}
+
 func (a API) GetRecentUserVideos(w http.ResponseWriter, r *http.Request) {
 	userID, ok := utils.GetUserID(r.Context())
 	if !ok {
@@ -43,9 +129,9 @@ func (a API) GetRecentUserVideos(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res, err := a.RepoClient.GetLast3UserVideos(context.Background(), &pb.GetLast3UserVideosRequest{UserId: userID})
+	res, err := a.RepoClient.GetLast3UserVideos(r.Context(), &pb.GetLast3UserVideosRequest{UserId: userID})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
 		return
 	}
 
@@ -62,9 +148,9 @@ func (a API) GetUserVideos(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res, err := a.RepoClient.GetUserVideos(context.Background(), &pb.GetUserVideosRequest{UserId: userID})
+	res, err := a.RepoClient.GetUserVideos(r.Context(), &pb.GetUserVideosRequest{UserId: userID})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
 		return
 	}
 
@@ -78,40 +164,77 @@ func (a API) DownloadVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stream, err := a.RepoClient.DownloadVideo(context.Background(), &pb.DownloadVideoRequest{FileName: videoID})
+	// Range, If-Range, If-None-Match and If-Modified-Since are handled by
+	// ServeContent, which reads only the requested ranges from the repo
+	file, metadata, err := openRepoFile(r.Context(), a.RepoClient, videoID, "", r.Header.Get("Range"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
 		return
 	}
+	defer file.Close()
 
-	first, err := stream.Recv()
+	modTime, _ := time.Parse(time.RFC3339, metadata.LastModified)
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", metadata.FileName))
+	w.Header().Set("Content-Type", metadata.ContentType)
+	if metadata.Etag != "" {
+		w.Header().Set("ETag", quoteETag(metadata.Etag))
+	}
+
+	http.ServeContent(w, r, metadata.FileName, modTime, file)
+}
+
+type updateVisibilityRequest struct {
+	Visibility string   `json:"visibility"`
+	SharedWith []string `json:"shared_with"`
+}
+
+// UpdateVideoVisibility sets who may see a video: owner, shared or public
+func (a API) UpdateVideoVisibility(w http.ResponseWriter, r *http.Request) {
+	var req updateVisibilityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"status":"error","message":"Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	res, err := a.RepoClient.UpdateVideoVisibility(r.Context(), &pb.UpdateVideoVisibilityRequest{
+		VideoId:    chi.URLParam(r, "video_id"),
+		Visibility: req.Visibility,
+		SharedWith: req.SharedWith,
+	})
 	if err != nil {
-		http.Error(w, "failed to receive metadata", http.StatusInternalServerError)
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
 		return
 	}
 
-	metadata := first.GetMetadata()
-	if metadata == nil {
-		http.Error(w, "expected metadata", http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(res)
+}
+
+// DeleteVideo moves a video to the trash, it can be restored until the
+// repo service purges it
+func (a API) DeleteVideo(w http.ResponseWriter, r *http.Request) {
+	videoID := chi.URLParam(r, "video_id")
+
+	if _, err := a.RepoClient.RemoveVideo(r.Context(), &pb.GetVideoRequest{VideoId: videoID}); err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
 		return
 	}
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", metadata.FileName))
-	w.Header().Set("Content-Type", metadata.ContentType)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", metadata.FileSize))
+	// Stop playback of the removed video right away
+	if err := revokePlayback(r.Context(), videoID); err != nil {
+		log.Printf("Failed to revoke playback for removed video %s: %v", videoID, err)
+	}
 
-	for {
-		res, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-		if chunk := res.GetChunk(); chunk != nil {
-			_, _ = w.Write(chunk.Data)
-		}
+// RestoreVideo takes a video out of the trash
+func (a API) RestoreVideo(w http.ResponseWriter, r *http.Request) {
+	res, err := a.RepoClient.RestoreVideo(r.Context(), &pb.GetVideoRequest{VideoId: chi.URLParam(r, "video_id")})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), repoErrorStatus(err))
+		return
 	}
+
+	json.NewEncoder(w).Encode(res)
 }
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRepoErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{status.Error(codes.InvalidArgument, "bad title"), http.StatusBadRequest},
+		{status.Error(codes.PermissionDenied, "not yours"), http.StatusForbidden},
+		{status.Error(codes.NotFound, "video not found"), http.StatusNotFound},
+		// A user at their video limit, whatever they upload
+		{status.Error(codes.FailedPrecondition, "storage quota exceeded: video limit reached: at most 10 videos"), http.StatusConflict},
+		{status.Error(codes.ResourceExhausted, "storage quota exceeded: 900 of 1000 bytes used"), http.StatusRequestEntityTooLarge},
+		{status.Error(codes.Internal, "boom"), http.StatusInternalServerError},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := repoErrorStatus(tt.err); got != tt.want {
+			t.Errorf("repoErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
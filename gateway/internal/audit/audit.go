@@ -0,0 +1,49 @@
+// Package audit records security relevant events. Each event is logged and
+// appended to the capped Redis stream audit:auth, so recent events can be
+// read back with XRANGE after the log has rotated.
+package audit
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/infra"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	streamKey    = "audit:auth"
+	streamMaxLen = 100000
+)
+
+// Record writes an event with its fields. Failing to store it is logged
+// and never fails the caller.
+func Record(ctx context.Context, event string, fields map[string]string) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var line strings.Builder
+	values := make([]any, 0, 2*len(fields)+4)
+	values = append(values, "event", event, "at", time.Now().UTC().Format(time.RFC3339Nano))
+	for _, k := range keys {
+		line.WriteString(" " + k + "=" + fields[k])
+		values = append(values, k, fields[k])
+	}
+	log.Printf("audit: %s%s", event, line.String())
+
+	err := infra.GetRDB().XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey,
+		MaxLen: streamMaxLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+	if err != nil {
+		log.Printf("audit: failed to store %s event: %v", event, err)
+	}
+}
@@ -6,10 +6,32 @@ import (
     "net"
     "os"
 
+    "github.com/lumbrjx/codek7/gateway/pkg/utils"
     "google.golang.org/grpc"
     "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/metadata"
 )
 
+// UserIDMetadataKey carries the authenticated user to the repo service,
+// which authorizes video access against it
+const UserIDMetadataKey = "x-user-id"
+
+// withCaller forwards the user ID stored in ctx by the auth middleware
+func withCaller(ctx context.Context) context.Context {
+    if userID, ok := utils.GetUserID(ctx); ok && userID != "" {
+        return metadata.AppendToOutgoingContext(ctx, UserIDMetadataKey, userID)
+    }
+    return ctx
+}
+
+func callerUnaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+    return invoker(withCaller(ctx), method, req, reply, cc, opts...)
+}
+
+func callerStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+    return streamer(withCaller(ctx), desc, cc, method, opts...)
+}
+
 func MakeGRPCClientConn() *grpc.ClientConn {
     addr := os.Getenv("REPO_SERVICE_ADDR")
     if addr == "" {
@@ -23,6 +45,8 @@ func MakeGRPCClientConn() *grpc.ClientConn {
             dialer := &net.Dialer{}
             return dialer.DialContext(ctx, "tcp", s)
         }),
+        grpc.WithUnaryInterceptor(callerUnaryInterceptor),
+        grpc.WithStreamInterceptor(callerStreamInterceptor),
     )
     if err != nil {
         log.Fatalf("failed to connect to Repo Service at %s: %v", addr, err)
@@ -3,22 +3,61 @@ package infra
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
+func kafkaHost() (string, error) {
+	host := os.Getenv("KAFKA_HOST")
+	if host == "" {
+		return "", fmt.Errorf("KAFKA_HOST environment variable is not set")
+	}
+	return host, nil
+}
+
+// MakeKafkaProducer returns the writer for the video-chunks topic
 func MakeKafkaProducer() (*kafka.Writer, error) {
-	kafkaHost := os.Getenv("KAFKA_HOST")
-	if kafkaHost == "" {
-		return nil, fmt.Errorf("KAFKA_HOST environment variable is not set")
+	return MakeKafkaTopicProducer("video-chunks")
+}
+
+// MakeKafkaTopicProducer returns a writer for topic
+func MakeKafkaTopicProducer(topic string) (*kafka.Writer, error) {
+	host, err := kafkaHost()
+	if err != nil {
+		return nil, err
 	}
 
+	// Hashing by key keeps the messages of an upload on one partition, in
+	// order. Retries are left to ingest.Publisher, which backs off.
 	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:  []string{kafkaHost},
-		Topic:    "video-chunks",
-		Balancer: &kafka.LeastBytes{},
+		Brokers:      []string{host},
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: int(kafka.RequireAll),
+		MaxAttempts:  1,
+		BatchTimeout: 10 * time.Millisecond,
 	})
 
 	return writer, nil
 }
 
+// MakeKafkaConsumer returns a reader for topic in consumer group groupID.
+// Offsets are committed explicitly, a new group starts at the oldest
+// message.
+func MakeKafkaConsumer(topic, groupID string) (*kafka.Reader, error) {
+	host, err := kafkaHost()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{host},
+		Topic:       topic,
+		GroupID:     groupID,
+		StartOffset: kafka.FirstOffset,
+		MaxBytes:    10e6,
+	})
+
+	return reader, nil
+}
@@ -0,0 +1,394 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/watcher"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultSpillThreshold = 16 << 20
+	defaultMemoryLimit    = 256 << 20
+	defaultTimeout        = 30 * time.Minute
+	commitInterval        = time.Second
+)
+
+// AssemblerConfig holds the limits of an Assembler, see AssemblerConfigFromEnv
+type AssemblerConfig struct {
+	// Dir holds the spilled chunks. It is emptied on start and must not
+	// be shared between assemblers.
+	Dir string
+	// An upload larger than SpillThreshold, or one arriving while all
+	// uploads in memory exceed MemoryLimit, is kept on disk
+	SpillThreshold int64
+	MemoryLimit    int64
+	// Timeout is how long an incomplete upload may go without a message
+	// before it is dropped
+	Timeout time.Duration
+}
+
+// AssemblerConfigFromEnv reads INGEST_DIR, INGEST_SPILL_THRESHOLD,
+// INGEST_MEMORY_LIMIT and INGEST_TIMEOUT
+func AssemblerConfigFromEnv() AssemblerConfig {
+	cfg := AssemblerConfig{
+		Dir:            filepath.Join(os.TempDir(), "codek7-ingest"),
+		SpillThreshold: defaultSpillThreshold,
+		MemoryLimit:    defaultMemoryLimit,
+		Timeout:        defaultTimeout,
+	}
+	if dir := os.Getenv("INGEST_DIR"); dir != "" {
+		cfg.Dir = dir
+	}
+	if n, err := strconv.ParseInt(os.Getenv("INGEST_SPILL_THRESHOLD"), 10, 64); err == nil && n >= 0 {
+		cfg.SpillThreshold = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("INGEST_MEMORY_LIMIT"), 10, 64); err == nil && n >= 0 {
+		cfg.MemoryLimit = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("INGEST_TIMEOUT")); err == nil && d > 0 {
+		cfg.Timeout = d
+	}
+	return cfg
+}
+
+// Handoff receives every assembled and verified upload
+type Handoff interface {
+	Deliver(ctx context.Context, m Manifest, content io.Reader) error
+}
+
+// Notifier tells users about uploads that failed, watcher.NotificationSender
+// is one
+type Notifier interface {
+	SendNotification(watcher.Notification) error
+}
+
+// Reader is the part of kafka.Reader the assembler uses
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+type partitionState struct {
+	topic string
+	// next is the offset after the last handled message, committed the
+	// last committed offset
+	next      int64
+	committed int64
+}
+
+type deliveryResult struct {
+	videoID string
+	err     error
+}
+
+// Assembler consumes uploads from the video-chunks topic and hands each
+// one off once all of its chunks and its commit have arrived.
+//
+// An offset is only committed once every upload that started before it is
+// finished, so after a crash or a rebalance the uploads in progress are
+// read again from their first message. Uploads handed off before are
+// recognized by their ingest status and skipped.
+type Assembler struct {
+	cfg      AssemblerConfig
+	handoff  Handoff
+	notifier Notifier
+
+	uploads    map[string]*assembly
+	finished   map[string]time.Time
+	partitions map[int]*partitionState
+	memBytes   int64
+	results    chan deliveryResult
+}
+
+// NewAssembler hands uploads to handoff. notifier may be nil.
+func NewAssembler(cfg AssemblerConfig, handoff Handoff, notifier Notifier) *Assembler {
+	return &Assembler{
+		cfg:        cfg,
+		handoff:    handoff,
+		notifier:   notifier,
+		uploads:    make(map[string]*assembly),
+		finished:   make(map[string]time.Time),
+		partitions: make(map[int]*partitionState),
+		results:    make(chan deliveryResult),
+	}
+}
+
+// Run consumes r until ctx is done or fetching fails
+func (a *Assembler) Run(ctx context.Context, r Reader) error {
+	// Spilled chunks of a previous run are read again from Kafka
+	if err := os.RemoveAll(a.cfg.Dir); err != nil {
+		return fmt.Errorf("clean ingest directory: %w", err)
+	}
+	if err := os.MkdirAll(a.cfg.Dir, 0o700); err != nil {
+		return fmt.Errorf("create ingest directory: %w", err)
+	}
+
+	msgs := make(chan kafka.Message)
+	fetchErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := r.FetchMessage(ctx)
+			if err != nil {
+				fetchErr <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	commit := time.NewTicker(commitInterval)
+	defer commit.Stop()
+	collect := time.NewTicker(max(a.cfg.Timeout/10, time.Second))
+	defer collect.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			a.commit(context.Background(), r)
+			return ctx.Err()
+		case err := <-fetchErr:
+			a.commit(context.Background(), r)
+			if errors.Is(err, context.Canceled) {
+				return ctx.Err()
+			}
+			return fmt.Errorf("fetch message: %w", err)
+		case msg := <-msgs:
+			a.handle(ctx, msg)
+		case res := <-a.results:
+			a.finish(ctx, res)
+		case <-commit.C:
+			a.commit(ctx, r)
+		case <-collect.C:
+			a.collect(ctx)
+		}
+	}
+}
+
+func (a *Assembler) handle(ctx context.Context, msg kafka.Message) {
+	ps, ok := a.partitions[msg.Partition]
+	if !ok {
+		ps = &partitionState{topic: msg.Topic, committed: msg.Offset}
+		a.partitions[msg.Partition] = ps
+	}
+	defer func() { ps.next = msg.Offset + 1 }()
+
+	m, err := ParseMessage(msg)
+	if err != nil {
+		log.Printf("Skipping message %d/%d of upload %q: %v", msg.Partition, msg.Offset, msg.Key, err)
+		return
+	}
+	if a.isFinished(ctx, m.VideoID) {
+		return
+	}
+
+	up := a.uploads[m.VideoID]
+	if m.Type == TypeAbort {
+		if up != nil && up.delivering {
+			return
+		}
+		log.Printf("Upload %s aborted by the gateway: %s", m.VideoID, m.Reason)
+		if up != nil {
+			a.drop(up)
+		}
+		a.finished[m.VideoID] = time.Now()
+		return
+	}
+	if up == nil {
+		up = newAssembly(m.VideoID, msg.Partition, msg.Offset, a.cfg.Dir)
+		a.uploads[m.VideoID] = up
+	}
+	if up.delivering {
+		return
+	}
+	up.lastSeen = time.Now()
+
+	if up.totalChunks == 0 {
+		up.totalChunks = m.TotalChunks
+		up.contentSHA256 = m.ContentSHA256
+		up.userID = m.UserID
+		up.title = m.Title
+	} else if m.TotalChunks != up.totalChunks || m.ContentSHA256 != up.contentSHA256 {
+		log.Printf("Skipping %s message of upload %s that does not match its earlier messages", m.Type, m.VideoID)
+		return
+	}
+
+	switch m.Type {
+	case TypeBegin, TypeCommit:
+		if up.manifest == nil {
+			up.manifest = m.Manifest
+		}
+		if m.Type == TypeCommit {
+			up.committed = true
+		}
+	case TypeChunk:
+		if err := a.store(up, m.ChunkIndex, m.Data); err != nil {
+			a.fail(ctx, up, err)
+			return
+		}
+	}
+
+	if up.complete() {
+		a.deliver(ctx, up)
+	}
+}
+
+// isFinished reports whether videoID was handed off or given up already,
+// in this run or, for a new upload, by an earlier one
+func (a *Assembler) isFinished(ctx context.Context, videoID string) bool {
+	if _, ok := a.finished[videoID]; ok {
+		return true
+	}
+	if _, ok := a.uploads[videoID]; ok {
+		return false
+	}
+	state, err := Status(ctx, videoID)
+	if err != nil {
+		log.Printf("Failed to load ingest status of %s: %v", videoID, err)
+		return false
+	}
+	if state == StatusReady {
+		a.finished[videoID] = time.Now()
+		return true
+	}
+	return false
+}
+
+func (a *Assembler) store(up *assembly, index int, data []byte) error {
+	before := up.memBytes
+	defer func() { a.memBytes += up.memBytes - before }()
+
+	if !up.onDisk && !up.has(index) {
+		size := int64(len(data))
+		if up.memBytes+size > a.cfg.SpillThreshold || a.memBytes+size > a.cfg.MemoryLimit {
+			if err := up.spill(); err != nil {
+				return err
+			}
+		}
+	}
+	return up.add(index, data)
+}
+
+// deliver verifies a complete upload and hands it off in the background
+func (a *Assembler) deliver(ctx context.Context, up *assembly) {
+	if err := up.verify(); err != nil {
+		a.fail(ctx, up, err)
+		return
+	}
+
+	up.delivering = true
+	m := *up.manifest
+	go func() {
+		content := up.open()
+		err := a.handoff.Deliver(ctx, m, content)
+		content.Close()
+		select {
+		case a.results <- deliveryResult{videoID: up.videoID, err: err}:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func (a *Assembler) finish(ctx context.Context, res deliveryResult) {
+	up, ok := a.uploads[res.videoID]
+	if !ok {
+		return
+	}
+	if res.err != nil {
+		a.fail(ctx, up, fmt.Errorf("hand off: %w", res.err))
+		return
+	}
+	log.Printf("Upload %s assembled and handed off", up.videoID)
+	SetStatus(ctx, up.videoID, up.userID, StatusReady, "")
+	a.notify(watcher.NewProgress(up.userID, up.videoID, watcher.StageAssembled, "assembler", up.manifest.Size, up.manifest.Size))
+	a.drop(up)
+	a.finished[up.videoID] = time.Now()
+}
+
+func (a *Assembler) notify(n watcher.Notification) {
+	if a.notifier == nil || n.UserID == "" {
+		return
+	}
+	if err := a.notifier.SendNotification(n); err != nil {
+		log.Printf("Failed to notify user %s about upload %s: %v", n.UserID, n.VideoID, err)
+	}
+}
+
+// fail gives up on an upload and tells its user
+func (a *Assembler) fail(ctx context.Context, up *assembly, cause error) {
+	log.Printf("❌ Upload %s failed: %v", up.videoID, cause)
+	a.drop(up)
+	a.finished[up.videoID] = time.Now()
+
+	SetStatus(ctx, up.videoID, up.userID, StatusFailed, cause.Error())
+	a.notify(watcher.Notification{
+		UserID:      up.userID,
+		EventType:   watcher.EventError,
+		VideoID:     up.videoID,
+		ServiceName: "assembler",
+		Description: fmt.Sprintf("Upload of %q failed, please try again", up.title),
+		Timestamp:   time.Now(),
+	})
+}
+
+func (a *Assembler) drop(up *assembly) {
+	a.memBytes -= up.memBytes
+	up.remove()
+	delete(a.uploads, up.videoID)
+}
+
+// collect drops uploads that stopped receiving messages, and forgets
+// finished ones once duplicates of their messages are no longer expected
+func (a *Assembler) collect(ctx context.Context) {
+	now := time.Now()
+	for _, up := range a.uploads {
+		if up.delivering || now.Sub(up.lastSeen) < a.cfg.Timeout {
+			continue
+		}
+		// Another assembler may own the partition since a rebalance
+		if state, err := Status(ctx, up.videoID); err == nil && state == StatusReady {
+			a.drop(up)
+			continue
+		}
+		a.fail(ctx, up, fmt.Errorf("timed out with %d of %d chunks", up.received(), up.totalChunks))
+	}
+	for videoID, at := range a.finished {
+		if now.Sub(at) > a.cfg.Timeout {
+			delete(a.finished, videoID)
+		}
+	}
+}
+
+// commit advances each partition's offset up to its oldest unfinished
+// upload
+func (a *Assembler) commit(ctx context.Context, r Reader) {
+	for partition, ps := range a.partitions {
+		watermark := ps.next
+		for _, up := range a.uploads {
+			if up.partition == partition && up.firstOffset < watermark {
+				watermark = up.firstOffset
+			}
+		}
+		if watermark <= ps.committed {
+			continue
+		}
+		err := r.CommitMessages(ctx, kafka.Message{Topic: ps.topic, Partition: partition, Offset: watermark - 1})
+		if err != nil {
+			log.Printf("Failed to commit offset %d of partition %d: %v", watermark, partition, err)
+			continue
+		}
+		ps.committed = watermark
+	}
+}
@@ -0,0 +1,186 @@
+package ingest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// assembly collects the chunks of one upload. Chunks are kept in memory
+// until the upload grows past the spill threshold, from then on each chunk
+// is a file in the upload's spill directory.
+type assembly struct {
+	videoID       string
+	manifest      *Manifest
+	totalChunks   int
+	contentSHA256 string
+	userID        string
+	title         string
+
+	// partition and firstOffset locate the oldest message of the upload,
+	// offsets are not committed past it while the upload is incomplete
+	partition   int
+	firstOffset int64
+
+	chunks   map[int][]byte
+	spilled  map[int]bool
+	memBytes int64
+	onDisk   bool
+	dir      string
+
+	committed bool
+	// delivering is set while the upload is handed off
+	delivering bool
+	lastSeen   time.Time
+}
+
+func newAssembly(videoID string, partition int, offset int64, baseDir string) *assembly {
+	return &assembly{
+		videoID:     videoID,
+		partition:   partition,
+		firstOffset: offset,
+		chunks:      make(map[int][]byte),
+		spilled:     make(map[int]bool),
+		dir:         filepath.Join(baseDir, videoID),
+		lastSeen:    time.Now(),
+	}
+}
+
+func (a *assembly) has(index int) bool {
+	_, ok := a.chunks[index]
+	return ok || a.spilled[index]
+}
+
+func (a *assembly) received() int {
+	return len(a.chunks) + len(a.spilled)
+}
+
+func (a *assembly) complete() bool {
+	return a.committed && a.manifest != nil && a.received() == a.totalChunks
+}
+
+func (a *assembly) chunkPath(index int) string {
+	return filepath.Join(a.dir, strconv.Itoa(index))
+}
+
+// add stores a chunk, duplicates are ignored
+func (a *assembly) add(index int, data []byte) error {
+	if a.has(index) {
+		return nil
+	}
+	if a.onDisk {
+		if err := os.WriteFile(a.chunkPath(index), data, 0o600); err != nil {
+			return fmt.Errorf("spill chunk %d: %w", index, err)
+		}
+		a.spilled[index] = true
+		return nil
+	}
+	a.chunks[index] = data
+	a.memBytes += int64(len(data))
+	return nil
+}
+
+// spill moves the chunks held in memory to disk
+func (a *assembly) spill() error {
+	if err := os.MkdirAll(a.dir, 0o700); err != nil {
+		return fmt.Errorf("create spill directory: %w", err)
+	}
+	a.onDisk = true
+	for index, data := range a.chunks {
+		if err := os.WriteFile(a.chunkPath(index), data, 0o600); err != nil {
+			return fmt.Errorf("spill chunk %d: %w", index, err)
+		}
+		a.spilled[index] = true
+		delete(a.chunks, index)
+		a.memBytes -= int64(len(data))
+	}
+	return nil
+}
+
+// remove frees the memory and the spill files of the upload
+func (a *assembly) remove() {
+	a.chunks = nil
+	a.memBytes = 0
+	if a.onDisk {
+		os.RemoveAll(a.dir)
+	}
+}
+
+// open reads the chunks back in order
+func (a *assembly) open() io.ReadCloser {
+	return &chunkReader{a: a}
+}
+
+// verify compares the assembled file with the manifest
+func (a *assembly) verify() error {
+	r := a.open()
+	defer r.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	if size != a.manifest.Size {
+		return fmt.Errorf("assembled %d bytes, manifest says %d", size, a.manifest.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != a.manifest.SHA256 {
+		return fmt.Errorf("assembled file has SHA-256 %s, manifest says %s", sum, a.manifest.SHA256)
+	}
+	return nil
+}
+
+type chunkReader struct {
+	a     *assembly
+	index int
+	cur   io.Reader
+	file  *os.File
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur != nil {
+			n, err := r.cur.Read(p)
+			if err != io.EOF {
+				return n, err
+			}
+			r.closeFile()
+			r.cur = nil
+			r.index++
+			if n > 0 {
+				return n, nil
+			}
+		}
+		if r.index >= r.a.totalChunks {
+			return 0, io.EOF
+		}
+		if data, ok := r.a.chunks[r.index]; ok {
+			r.cur = bytes.NewReader(data)
+			continue
+		}
+		f, err := os.Open(r.a.chunkPath(r.index))
+		if err != nil {
+			return 0, fmt.Errorf("open chunk %d: %w", r.index, err)
+		}
+		r.file = f
+		r.cur = f
+	}
+}
+
+func (r *chunkReader) closeFile() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+func (r *chunkReader) Close() error {
+	r.closeFile()
+	return nil
+}
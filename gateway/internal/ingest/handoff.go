@@ -0,0 +1,153 @@
+package ingest
+
+import (
+	"codek7/common/pb"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/media"
+	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+	"github.com/segmentio/kafka-go"
+)
+
+// ReadyTopic carries a ReadyEvent for every upload stored in the repo
+// service, for transcoding to pick up
+const ReadyTopic = "video-ready"
+
+// uploadChunkSize is the size of the UploadVideo stream messages
+const uploadChunkSize = 256 * 1024
+
+// ReadyEvent announces an original that is stored and waits for transcoding
+type ReadyEvent struct {
+	VideoID     string    `json:"video_id"`
+	UserID      string    `json:"user_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	FileName    string    `json:"file_name"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	StoredAt    time.Time `json:"stored_at"`
+}
+
+// RepoHandoff streams uploads into the repo service's UploadVideo and
+// announces them on ReadyTopic. The repo service stores the video under the
+// ID the gateway issued, so delivering an upload again, after a crash between
+// the handoff and its ingest status, returns the video stored the first time.
+type RepoHandoff struct {
+	repo   pb.RepoServiceClient
+	events MessageWriter
+}
+
+func NewRepoHandoff(repo pb.RepoServiceClient, events MessageWriter) *RepoHandoff {
+	return &RepoHandoff{repo: repo, events: events}
+}
+
+func (h *RepoHandoff) Deliver(ctx context.Context, m Manifest, content io.Reader) error {
+	video, err := h.upload(ctx, m, content)
+	if err != nil {
+		return err
+	}
+	// A replayed upload whose video went past transcoding is not queued again
+	if video.Status != "transcoding" {
+		log.Printf("Upload %s was stored before as video %s (%s), not queueing it again", m.VideoID, video.Id, video.Status)
+		return nil
+	}
+
+	value, err := json.Marshal(ReadyEvent{
+		VideoID:     video.Id,
+		UserID:      m.UserID,
+		Title:       m.Title,
+		Description: m.Description,
+		FileName:    video.FileName,
+		Size:        m.Size,
+		SHA256:      m.SHA256,
+		StoredAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("encode ready event: %w", err)
+	}
+	err = NewPublisher(h.events).write(ctx, kafka.Message{Key: []byte(video.Id), Value: value})
+	if err != nil {
+		// Nothing will transcode the stored video, fail it rather than
+		// leave it transcoding forever. Only the owner may change it.
+		_, statusErr := h.repo.UpdateVideoStatus(utils.WithUserID(context.Background(), m.UserID), &pb.UpdateVideoStatusRequest{
+			VideoId: video.Id,
+			Status:  "failed",
+			Reason:  "could not be queued for transcoding",
+		})
+		if statusErr != nil {
+			return fmt.Errorf("publish ready event for video %s: %w (marking it failed: %v)", video.Id, err, statusErr)
+		}
+		return fmt.Errorf("publish ready event for video %s: %w", video.Id, err)
+	}
+	return nil
+}
+
+func (h *RepoHandoff) upload(ctx context.Context, m Manifest, content io.Reader) (*pb.VideoMetadataResponse, error) {
+	// The repo service only stores uploads on behalf of their owner
+	stream, err := h.repo.UploadVideo(utils.WithUserID(ctx, m.UserID))
+	if err != nil {
+		return nil, fmt.Errorf("open upload stream: %w", err)
+	}
+
+	// The repo service names the original after the file, so it keeps the
+	// container's extension
+	fileName := m.VideoID + media.Extension(m.Media.Container)
+
+	err = stream.Send(&pb.UploadVideoRequest{
+		Data: &pb.UploadVideoRequest_Metadata{
+			Metadata: &pb.VideoMetadata{
+				UserId:      m.UserID,
+				UploadId:    m.VideoID,
+				Title:       m.Title,
+				Description: m.Description,
+				FileName:    fileName,
+				FileSize:    m.Size,
+				Media: &pb.MediaInfo{
+					Container:  m.Media.Container,
+					Duration:   m.Media.Duration,
+					VideoCodec: m.Media.VideoCodec,
+					AudioCodec: m.Media.AudioCodec,
+					Width:      int32(m.Media.Width),
+					Height:     int32(m.Media.Height),
+					FrameRate:  m.Media.FrameRate,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("send video metadata: %w", err)
+	}
+
+	buf := make([]byte, uploadChunkSize)
+	for number := int32(0); ; number++ {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 {
+			sendErr := stream.Send(&pb.UploadVideoRequest{
+				Data: &pb.UploadVideoRequest_Chunk{
+					Chunk: &pb.VideoChunk{Data: buf[:n], ChunkNumber: number, FileName: fileName},
+				},
+			})
+			if sendErr != nil {
+				break // CloseAndRecv reports why the stream ended
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			stream.CloseSend()
+			return nil, fmt.Errorf("read assembled upload: %w", err)
+		}
+	}
+
+	video, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, fmt.Errorf("upload video: %w", err)
+	}
+	return video, nil
+}
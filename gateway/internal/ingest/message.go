@@ -0,0 +1,93 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Message is a decoded message of the protocol
+type Message struct {
+	Type    string
+	VideoID string
+	// Manifest is set on begin and commit
+	Manifest *Manifest
+
+	ChunkIndex    int
+	TotalChunks   int
+	Checksum      string
+	ContentSHA256 string
+	UserID        string
+	Title         string
+	Description   string
+	Data          []byte
+
+	Reason string
+}
+
+// ParseMessage decodes msg. Messages of other protocol versions and
+// malformed ones are refused.
+func ParseMessage(msg kafka.Message) (Message, error) {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+
+	m := Message{
+		Type:          headers[HeaderMessageType],
+		VideoID:       string(msg.Key),
+		Checksum:      headers[HeaderChunkCRC32C],
+		ContentSHA256: headers[HeaderContentSHA256],
+		UserID:        headers[HeaderUserID],
+		Title:         headers[HeaderTitle],
+		Description:   headers[HeaderDescription],
+		Reason:        headers[HeaderReason],
+	}
+	if v := headers[HeaderProtocolVersion]; v != ProtocolVersion {
+		return m, fmt.Errorf("unsupported protocol version %q", v)
+	}
+	if m.VideoID == "" {
+		return m, fmt.Errorf("message without a video ID")
+	}
+
+	switch m.Type {
+	case TypeBegin, TypeCommit:
+		var manifest Manifest
+		if err := json.Unmarshal(msg.Value, &manifest); err != nil {
+			return m, fmt.Errorf("decode manifest: %w", err)
+		}
+		if manifest.VideoID != m.VideoID || manifest.TotalChunks <= 0 || manifest.Size <= 0 {
+			return m, fmt.Errorf("invalid manifest for %s", m.VideoID)
+		}
+		m.Manifest = &manifest
+		m.TotalChunks = manifest.TotalChunks
+		m.ContentSHA256 = manifest.SHA256
+		m.UserID = manifest.UserID
+		m.Title = manifest.Title
+		m.Description = manifest.Description
+
+	case TypeChunk:
+		var err error
+		if m.ChunkIndex, err = strconv.Atoi(headers[HeaderChunkIndex]); err != nil {
+			return m, fmt.Errorf("invalid chunk index: %w", err)
+		}
+		if m.TotalChunks, err = strconv.Atoi(headers[HeaderTotalChunks]); err != nil {
+			return m, fmt.Errorf("invalid total chunks: %w", err)
+		}
+		if m.ChunkIndex < 0 || m.ChunkIndex >= m.TotalChunks {
+			return m, fmt.Errorf("chunk index %d out of range of %d chunks", m.ChunkIndex, m.TotalChunks)
+		}
+		if m.Checksum != ChunkChecksum(msg.Value) {
+			return m, fmt.Errorf("chunk %d fails its checksum", m.ChunkIndex)
+		}
+		m.Data = msg.Value
+
+	case TypeAbort:
+
+	default:
+		return m, fmt.Errorf("unknown message type %q", m.Type)
+	}
+	return m, nil
+}
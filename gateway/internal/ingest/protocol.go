@@ -0,0 +1,151 @@
+// Package ingest publishes uploaded files to the video-chunks Kafka topic.
+//
+// An upload is a sequence of messages keyed by its video ID, so they all
+// land on one partition in order:
+//
+//	begin   the Manifest as JSON
+//	chunk   ChunkSize bytes of the file, the last one shorter
+//	commit  the Manifest again, sent once every chunk was acknowledged
+//	abort   sent instead of commit when the upload cannot be completed
+//
+// Every message carries message_type and protocol_version headers. Chunks
+// also carry chunk_index, total_chunks, a CRC-32C of their payload and the
+// SHA-256 of the whole file, plus the user_id, title and description
+// headers older consumers read. Writes are retried, so a message may be
+// delivered more than once; consumers dedupe chunks by video ID and
+// chunk_index and verify the file against content_sha256 on commit.
+package ingest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/media"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// Topic is the topic uploads are published to
+	Topic = "video-chunks"
+	// ProtocolVersion is bumped on incompatible changes
+	ProtocolVersion = "1"
+	// ChunkSize keeps a chunk with its headers well under Kafka's default
+	// 1 MiB message limit
+	ChunkSize = 512 * 1024
+)
+
+// Message types
+const (
+	TypeBegin  = "begin"
+	TypeChunk  = "chunk"
+	TypeCommit = "commit"
+	TypeAbort  = "abort"
+)
+
+// Header keys
+const (
+	HeaderMessageType     = "message_type"
+	HeaderProtocolVersion = "protocol_version"
+	HeaderChunkIndex      = "chunk_index"
+	HeaderTotalChunks     = "total_chunks"
+	HeaderChunkCRC32C     = "chunk_crc32c"
+	HeaderContentSHA256   = "content_sha256"
+	HeaderUserID          = "user_id"
+	HeaderTitle           = "title"
+	HeaderDescription     = "description"
+	HeaderReason          = "reason"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Manifest describes an upload. It is the payload of begin and commit.
+type Manifest struct {
+	VideoID     string    `json:"video_id"`
+	UserID      string    `json:"user_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	FileName    string    `json:"file_name"`
+	Size        int64     `json:"size"`
+	ChunkSize   int64     `json:"chunk_size"`
+	TotalChunks int       `json:"total_chunks"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Media is what validation found out about the file, it has no
+	// container for uploads published before validation
+	Media media.Info `json:"media"`
+}
+
+// ChunkChecksum returns the checksum carried in the chunk_crc32c header
+func ChunkChecksum(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(data, castagnoli))
+}
+
+// NewManifest describes a file of size bytes with the given SHA-256
+func NewManifest(videoID, userID, title, description, fileName string, size int64, sum []byte) Manifest {
+	return Manifest{
+		VideoID:     videoID,
+		UserID:      userID,
+		Title:       title,
+		Description: description,
+		FileName:    fileName,
+		Size:        size,
+		ChunkSize:   ChunkSize,
+		TotalChunks: int((size + ChunkSize - 1) / ChunkSize),
+		SHA256:      hex.EncodeToString(sum),
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+func (m Manifest) message(messageType string, value []byte, headers ...kafka.Header) kafka.Message {
+	return kafka.Message{
+		Key:   []byte(m.VideoID),
+		Value: value,
+		Headers: append([]kafka.Header{
+			{Key: HeaderMessageType, Value: []byte(messageType)},
+			{Key: HeaderProtocolVersion, Value: []byte(ProtocolVersion)},
+		}, headers...),
+	}
+}
+
+func (m Manifest) manifestMessage(messageType string) (kafka.Message, error) {
+	value, err := json.Marshal(m)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("encode manifest: %w", err)
+	}
+	return m.message(messageType, value,
+		kafka.Header{Key: HeaderTotalChunks, Value: []byte(strconv.Itoa(m.TotalChunks))},
+		kafka.Header{Key: HeaderContentSHA256, Value: []byte(m.SHA256)},
+	), nil
+}
+
+// BeginMessage announces the upload
+func (m Manifest) BeginMessage() (kafka.Message, error) {
+	return m.manifestMessage(TypeBegin)
+}
+
+// CommitMessage tells consumers every chunk has been written
+func (m Manifest) CommitMessage() (kafka.Message, error) {
+	return m.manifestMessage(TypeCommit)
+}
+
+// AbortMessage tells consumers to drop what they have of the upload
+func (m Manifest) AbortMessage(reason string) kafka.Message {
+	return m.message(TypeAbort, nil, kafka.Header{Key: HeaderReason, Value: []byte(reason)})
+}
+
+// ChunkMessage carries chunk index of the file
+func (m Manifest) ChunkMessage(index int, data []byte) kafka.Message {
+	return m.message(TypeChunk, data,
+		kafka.Header{Key: HeaderChunkIndex, Value: []byte(strconv.Itoa(index))},
+		kafka.Header{Key: HeaderTotalChunks, Value: []byte(strconv.Itoa(m.TotalChunks))},
+		kafka.Header{Key: HeaderChunkCRC32C, Value: []byte(ChunkChecksum(data))},
+		kafka.Header{Key: HeaderContentSHA256, Value: []byte(m.SHA256)},
+		kafka.Header{Key: HeaderUserID, Value: []byte(m.UserID)},
+		kafka.Header{Key: HeaderTitle, Value: []byte(m.Title)},
+		kafka.Header{Key: HeaderDescription, Value: []byte(m.Description)},
+	)
+}
@@ -0,0 +1,204 @@
+package ingest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// testManifest describes size bytes of a repeating pattern
+func testManifest(t *testing.T, size int) (Manifest, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	sum := sha256.Sum256(data)
+	return NewManifest("video-1", "user-1", "Title", "Description", "clip.mp4", int64(size), sum[:]), data
+}
+
+func TestNewManifestTotalChunks(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int
+	}{
+		{1, 1},
+		{ChunkSize - 1, 1},
+		{ChunkSize, 1},
+		{ChunkSize + 1, 2},
+		{3 * ChunkSize, 3},
+		{3*ChunkSize + 7, 4},
+	}
+	for _, tt := range tests {
+		m := NewManifest("v", "u", "", "", "f", tt.size, nil)
+		if m.TotalChunks != tt.want {
+			t.Errorf("TotalChunks(%d bytes) = %d, want %d", tt.size, m.TotalChunks, tt.want)
+		}
+	}
+}
+
+func TestChunkChecksum(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		// CRC-32C check value of RFC 3720 and the empty input
+		{"123456789", "e3069283"},
+		{"", "00000000"},
+	}
+	for _, tt := range tests {
+		if got := ChunkChecksum([]byte(tt.in)); got != tt.want {
+			t.Errorf("ChunkChecksum(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	m, data := testManifest(t, ChunkSize+100)
+
+	begin, err := m.BeginMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := m.CommitMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		msg   kafka.Message
+		check func(Message) bool
+	}{
+		{begin, func(got Message) bool {
+			return got.Type == TypeBegin && got.Manifest != nil && got.Manifest.SHA256 == m.SHA256 &&
+				got.TotalChunks == 2 && got.UserID == "user-1" && got.Title == "Title"
+		}},
+		{m.ChunkMessage(0, data[:ChunkSize]), func(got Message) bool {
+			return got.Type == TypeChunk && got.ChunkIndex == 0 && got.TotalChunks == 2 &&
+				bytes.Equal(got.Data, data[:ChunkSize]) && got.ContentSHA256 == m.SHA256
+		}},
+		{m.ChunkMessage(1, data[ChunkSize:]), func(got Message) bool {
+			return got.Type == TypeChunk && got.ChunkIndex == 1 && bytes.Equal(got.Data, data[ChunkSize:])
+		}},
+		{commit, func(got Message) bool {
+			return got.Type == TypeCommit && got.Manifest != nil && got.Manifest.Size == m.Size
+		}},
+		{m.AbortMessage("client went away"), func(got Message) bool {
+			return got.Type == TypeAbort && got.Reason == "client went away"
+		}},
+	}
+	for _, tt := range tests {
+		if string(tt.msg.Key) != m.VideoID {
+			t.Errorf("message key = %q, want the video ID", tt.msg.Key)
+		}
+		got, err := ParseMessage(tt.msg)
+		if err != nil {
+			t.Errorf("ParseMessage: %v", err)
+			continue
+		}
+		if got.VideoID != m.VideoID || !tt.check(got) {
+			t.Errorf("ParseMessage = %+v", got)
+		}
+	}
+}
+
+func TestParseMessageRejects(t *testing.T) {
+	m, data := testManifest(t, 1000)
+
+	// with replaces or adds a header of msg
+	with := func(msg kafka.Message, key, value string) kafka.Message {
+		headers := make([]kafka.Header, 0, len(msg.Headers)+1)
+		for _, h := range msg.Headers {
+			if h.Key != key {
+				headers = append(headers, h)
+			}
+		}
+		msg.Headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+		return msg
+	}
+	manifestWith := func(edit func(*Manifest)) kafka.Message {
+		c := m
+		edit(&c)
+		value, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := m.BeginMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Value = value
+		return msg
+	}
+	chunk := m.ChunkMessage(0, data)
+
+	tests := []struct {
+		name string
+		msg  kafka.Message
+	}{
+		{"other protocol version", with(chunk, HeaderProtocolVersion, "2")},
+		{"no protocol version", kafka.Message{Key: []byte(m.VideoID), Headers: []kafka.Header{{Key: HeaderMessageType, Value: []byte(TypeAbort)}}}},
+		{"no video ID", func() kafka.Message { c := chunk; c.Key = nil; return c }()},
+		{"unknown type", with(chunk, HeaderMessageType, "resume")},
+		{"bad checksum", func() kafka.Message { c := chunk; c.Value = append([]byte{}, data...); c.Value[0]++; return c }()},
+		{"index out of range", with(chunk, HeaderChunkIndex, "1")},
+		{"negative index", with(chunk, HeaderChunkIndex, "-1")},
+		{"index not a number", with(chunk, HeaderChunkIndex, "zero")},
+		{"total not a number", with(chunk, HeaderTotalChunks, "")},
+		{"manifest not JSON", func() kafka.Message { c := manifestWith(func(*Manifest) {}); c.Value = []byte("{"); return c }()},
+		{"manifest of another video", manifestWith(func(c *Manifest) { c.VideoID = "video-2" })},
+		{"manifest without chunks", manifestWith(func(c *Manifest) { c.TotalChunks = 0 })},
+		{"empty manifest", manifestWith(func(c *Manifest) { c.Size = 0 })},
+	}
+	for _, tt := range tests {
+		if _, err := ParseMessage(tt.msg); err == nil {
+			t.Errorf("%s: ParseMessage succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestAssemblyVerify(t *testing.T) {
+	m, data := testManifest(t, 2*ChunkSize+10)
+
+	tests := []struct {
+		name   string
+		spill  bool
+		chunks [][]byte
+		ok     bool
+	}{
+		{"in memory", false, [][]byte{data[:ChunkSize], data[ChunkSize : 2*ChunkSize], data[2*ChunkSize:]}, true},
+		{"spilled", true, [][]byte{data[:ChunkSize], data[ChunkSize : 2*ChunkSize], data[2*ChunkSize:]}, true},
+		{"short", false, [][]byte{data[:ChunkSize], data[ChunkSize : 2*ChunkSize], data[2*ChunkSize : 2*ChunkSize+5]}, false},
+		{"swapped", false, [][]byte{data[ChunkSize : 2*ChunkSize], data[:ChunkSize], data[2*ChunkSize:]}, false},
+	}
+	for _, tt := range tests {
+		a := newAssembly(m.VideoID, 0, 0, t.TempDir())
+		a.manifest = &m
+		a.totalChunks = m.TotalChunks
+		a.committed = true
+		if tt.spill {
+			if err := a.spill(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, chunk := range tt.chunks {
+			if err := a.add(i, chunk); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// A redelivered chunk is ignored
+		if err := a.add(0, []byte("duplicate")); err != nil {
+			t.Fatal(err)
+		}
+		if !a.complete() {
+			t.Errorf("%s: assembly not complete with %d of %d chunks", tt.name, a.received(), a.totalChunks)
+		}
+		if err := a.verify(); (err == nil) != tt.ok {
+			t.Errorf("%s: verify = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		a.remove()
+	}
+}
@@ -0,0 +1,133 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultBatchSize   = 16
+	defaultMaxAttempts = 5
+	defaultBackoff     = 250 * time.Millisecond
+	defaultMaxBackoff  = 10 * time.Second
+)
+
+// ErrEmptyUpload is returned for files without content, they would
+// produce an upload of zero chunks
+var ErrEmptyUpload = errors.New("upload is empty")
+
+// MessageWriter is the part of kafka.Writer the publisher uses
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Publisher writes uploads in batches, one batch at a time, retrying each
+// with exponential backoff
+type Publisher struct {
+	w           MessageWriter
+	batchSize   int
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+// NewPublisher publishes through w, which should balance by key so an
+// upload stays on one partition
+func NewPublisher(w MessageWriter) *Publisher {
+	return &Publisher{
+		w:           w,
+		batchSize:   defaultBatchSize,
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		maxBackoff:  defaultMaxBackoff,
+	}
+}
+
+// write sends msgs, retrying the whole batch on failure. Messages of a
+// failed batch may have been partly delivered, which the protocol allows.
+func (p *Publisher) write(ctx context.Context, msgs ...kafka.Message) error {
+	delay := p.backoff
+	var err error
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		if err = p.w.WriteMessages(ctx, msgs...); err == nil {
+			return nil
+		}
+		if attempt == p.maxAttempts {
+			break
+		}
+		log.Printf("Kafka write of %d messages failed (attempt %d/%d), retrying in %s: %v",
+			len(msgs), attempt, p.maxAttempts, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, p.maxBackoff)
+	}
+	return fmt.Errorf("after %d attempts: %w", p.maxAttempts, err)
+}
+
+// Publish sends begin, the chunks read from r and commit. r must yield
+// exactly m.Size bytes. onChunks, which may be nil, is called with the
+// number of chunks written after each batch. On error consumers may hold a
+// partial upload and should be sent Abort.
+func (p *Publisher) Publish(ctx context.Context, m Manifest, r io.Reader, onChunks func(sent int)) error {
+	if m.Size == 0 {
+		return ErrEmptyUpload
+	}
+
+	begin, err := m.BeginMessage()
+	if err != nil {
+		return err
+	}
+	if err := p.write(ctx, begin); err != nil {
+		return fmt.Errorf("publish begin: %w", err)
+	}
+
+	batch := make([]kafka.Message, 0, p.batchSize)
+	for index := 0; index < m.TotalChunks; index++ {
+		data := make([]byte, min(m.ChunkSize, m.Size-int64(index)*m.ChunkSize))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("read chunk %d: %w", index, err)
+		}
+		batch = append(batch, m.ChunkMessage(index, data))
+
+		if len(batch) == p.batchSize || index == m.TotalChunks-1 {
+			if err := p.write(ctx, batch...); err != nil {
+				return fmt.Errorf("publish chunks %d-%d: %w", index+1-len(batch), index, err)
+			}
+			batch = batch[:0]
+			if onChunks != nil {
+				onChunks(index + 1)
+			}
+		}
+	}
+
+	// Trailing data means the file changed since it was hashed
+	if n, _ := r.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("file is larger than its manifest size %d", m.Size)
+	}
+
+	commit, err := m.CommitMessage()
+	if err != nil {
+		return err
+	}
+	if err := p.write(ctx, commit); err != nil {
+		return fmt.Errorf("publish commit: %w", err)
+	}
+	return nil
+}
+
+// Abort tells consumers to discard the upload
+func (p *Publisher) Abort(ctx context.Context, m Manifest, reason string) error {
+	if err := p.write(ctx, m.AbortMessage(reason)); err != nil {
+		return fmt.Errorf("publish abort: %w", err)
+	}
+	return nil
+}
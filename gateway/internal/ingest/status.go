@@ -0,0 +1,54 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/infra"
+	"github.com/redis/go-redis/v9"
+)
+
+// States of an upload, kept in Redis from the moment it is published until
+// it is handed off to transcoding
+const (
+	StatusPublishing = "publishing"
+	StatusPublished  = "published"
+	StatusReady      = "ready"
+	StatusFailed     = "failed"
+)
+
+const statusTTL = 24 * time.Hour
+
+func statusKey(videoID string) string {
+	return "ingest:" + videoID
+}
+
+// SetStatus records the state of an upload. Failing to store it is logged
+// and never fails the caller.
+func SetStatus(ctx context.Context, videoID, userID, state, reason string) {
+	key := statusKey(videoID)
+	_, err := infra.GetRDB().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"user_id", userID,
+			"status", state,
+			"reason", reason,
+			"updated_at", time.Now().Unix(),
+		)
+		pipe.Expire(ctx, key, statusTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to record ingest status of %s: %v", videoID, err)
+	}
+}
+
+// Status returns the state of an upload, "" when none is recorded
+func Status(ctx context.Context, videoID string) (string, error) {
+	state, err := infra.GetRDB().HGet(ctx, statusKey(videoID), "status").Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return state, err
+}
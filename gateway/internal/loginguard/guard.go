@@ -0,0 +1,245 @@
+// Package loginguard slows down password guessing on /auth/login.
+//
+// Failures are counted per account and per client IP within a window. The
+// account is the user ID, so logging in by username or by email draws on the
+// same budget; logins that match no account are tracked under their name, so
+// the responses do not tell them apart. From the second failure on, the next
+// attempt for the account has to wait an exponentially growing delay, and
+// after LOGIN_MAX_FAILURES the account is locked for LOGIN_LOCKOUT. An IP that
+// fails LOGIN_IP_MAX_FAILURES times, across any accounts, is locked the same
+// way.
+//
+// Wrong second factor codes are counted per user on their own: after
+// LOGIN_MFA_MAX_FAILURES the user's codes are refused for LOGIN_LOCKOUT.
+// A correct password does not reset that count, so knowing the password does
+// not buy more guesses at the code.
+package loginguard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/audit"
+	"github.com/lumbrjx/codek7/gateway/internal/infra"
+)
+
+const (
+	defaultMaxFailures    = 5
+	defaultIPMaxFailures  = 50
+	defaultMFAMaxFailures = 10
+	defaultWindow         = 15 * time.Minute
+	defaultLockout        = 15 * time.Minute
+	baseDelay             = time.Second
+	maxDelay              = time.Minute
+)
+
+// Config holds the limits, see FromEnv
+type Config struct {
+	MaxFailures    int64
+	IPMaxFailures  int64
+	MFAMaxFailures int64
+	Window         time.Duration
+	Lockout        time.Duration
+}
+
+// FromEnv reads LOGIN_MAX_FAILURES, LOGIN_IP_MAX_FAILURES,
+// LOGIN_MFA_MAX_FAILURES, LOGIN_FAILURE_WINDOW and LOGIN_LOCKOUT
+func FromEnv() Config {
+	cfg := Config{
+		MaxFailures:    defaultMaxFailures,
+		IPMaxFailures:  defaultIPMaxFailures,
+		MFAMaxFailures: defaultMFAMaxFailures,
+		Window:         defaultWindow,
+		Lockout:        defaultLockout,
+	}
+	if n, err := strconv.ParseInt(os.Getenv("LOGIN_MAX_FAILURES"), 10, 64); err == nil && n > 0 {
+		cfg.MaxFailures = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("LOGIN_IP_MAX_FAILURES"), 10, 64); err == nil && n > 0 {
+		cfg.IPMaxFailures = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("LOGIN_MFA_MAX_FAILURES"), 10, 64); err == nil && n > 0 {
+		cfg.MFAMaxFailures = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_FAILURE_WINDOW")); err == nil && d > 0 {
+		cfg.Window = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT")); err == nil && d > 0 {
+		cfg.Lockout = d
+	}
+	return cfg
+}
+
+// Guard tracks login attempts in Redis
+type Guard struct {
+	cfg Config
+}
+
+func New(cfg Config) *Guard {
+	return &Guard{cfg: cfg}
+}
+
+// subject hashes an account so keys have a bounded size and case does not
+// give extra attempts
+func subject(account string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(account))))
+	return hex.EncodeToString(sum[:16])
+}
+
+func failKey(kind, id string) string  { return "auth:login:fail:" + kind + ":" + id }
+func lockKey(kind, id string) string  { return "auth:login:lock:" + kind + ":" + id }
+func delayKey(kind, id string) string { return "auth:login:delay:" + kind + ":" + id }
+
+// backoff is the wait after the nth consecutive failure: none after the
+// first, then 1s, 2s, 4s... up to a minute
+func backoff(failures int64) time.Duration {
+	if failures < 2 {
+		return 0
+	}
+	d := baseDelay << min(failures-2, 16)
+	return min(d, maxDelay)
+}
+
+// Check returns how long the caller has to wait before account may be
+// tried from ip, zero when the attempt may go ahead
+func (g *Guard) Check(ctx context.Context, account, ip string) (time.Duration, error) {
+	rdb := infra.GetRDB()
+	user := subject(account)
+
+	var wait time.Duration
+	for _, key := range []string{lockKey("user", user), lockKey("ip", ip), delayKey("user", user)} {
+		ttl, err := rdb.PTTL(ctx, key).Result()
+		if err != nil {
+			return 0, fmt.Errorf("check login throttle: %w", err)
+		}
+		wait = max(wait, ttl)
+	}
+	return wait, nil
+}
+
+// Failure records a failed attempt and returns how long the next attempt
+// has to wait. Crossing a limit locks the account or IP and is audited.
+func (g *Guard) Failure(ctx context.Context, account, ip string) (time.Duration, error) {
+	rdb := infra.GetRDB()
+	user := subject(account)
+
+	userFailures, err := g.count(ctx, failKey("user", user))
+	if err != nil {
+		return 0, err
+	}
+	ipFailures, err := g.count(ctx, failKey("ip", ip))
+	if err != nil {
+		return 0, err
+	}
+
+	wait := backoff(userFailures)
+	if wait > 0 {
+		if err := rdb.Set(ctx, delayKey("user", user), 1, wait).Err(); err != nil {
+			return 0, fmt.Errorf("set login delay: %w", err)
+		}
+	}
+
+	if userFailures >= g.cfg.MaxFailures {
+		if err := rdb.Set(ctx, lockKey("user", user), 1, g.cfg.Lockout).Err(); err != nil {
+			return 0, fmt.Errorf("lock account: %w", err)
+		}
+		audit.Record(ctx, "login.lockout", map[string]string{
+			"scope":    "account",
+			"account":  account,
+			"ip":       ip,
+			"failures": strconv.FormatInt(userFailures, 10),
+			"duration": g.cfg.Lockout.String(),
+		})
+		wait = max(wait, g.cfg.Lockout)
+	}
+	if ipFailures >= g.cfg.IPMaxFailures {
+		if err := rdb.Set(ctx, lockKey("ip", ip), 1, g.cfg.Lockout).Err(); err != nil {
+			return 0, fmt.Errorf("lock ip: %w", err)
+		}
+		audit.Record(ctx, "login.lockout", map[string]string{
+			"scope":    "ip",
+			"ip":       ip,
+			"failures": strconv.FormatInt(ipFailures, 10),
+			"duration": g.cfg.Lockout.String(),
+		})
+		wait = max(wait, g.cfg.Lockout)
+	}
+	return wait, nil
+}
+
+// count increments a failure counter, starting its window on the first failure
+func (g *Guard) count(ctx context.Context, key string) (int64, error) {
+	rdb := infra.GetRDB()
+	n, err := rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("count login failure: %w", err)
+	}
+	if n == 1 {
+		if err := rdb.Expire(ctx, key, g.cfg.Window).Err(); err != nil {
+			return 0, fmt.Errorf("count login failure: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// CheckMFA returns how long userID has to wait before a second factor code
+// is accepted again, zero when it may be tried
+func (g *Guard) CheckMFA(ctx context.Context, userID string) (time.Duration, error) {
+	ttl, err := infra.GetRDB().PTTL(ctx, lockKey("mfa", subject(userID))).Result()
+	if err != nil {
+		return 0, fmt.Errorf("check mfa throttle: %w", err)
+	}
+	return max(ttl, 0), nil
+}
+
+// MFAFailure records a wrong second factor code of userID. Crossing
+// MFAMaxFailures locks the user's second factor and is audited.
+func (g *Guard) MFAFailure(ctx context.Context, userID, ip string) (time.Duration, error) {
+	user := subject(userID)
+	failures, err := g.count(ctx, failKey("mfa", user))
+	if err != nil {
+		return 0, err
+	}
+	if failures < g.cfg.MFAMaxFailures {
+		return 0, nil
+	}
+
+	if err := infra.GetRDB().Set(ctx, lockKey("mfa", user), 1, g.cfg.Lockout).Err(); err != nil {
+		return 0, fmt.Errorf("lock second factor: %w", err)
+	}
+	audit.Record(ctx, "login.lockout", map[string]string{
+		"scope":    "mfa",
+		"account":  userID,
+		"ip":       ip,
+		"failures": strconv.FormatInt(failures, 10),
+		"duration": g.cfg.Lockout.String(),
+	})
+	return g.cfg.Lockout, nil
+}
+
+// MFASuccess clears userID's second factor failures once a code was accepted
+func (g *Guard) MFASuccess(ctx context.Context, userID string) error {
+	user := subject(userID)
+	if err := infra.GetRDB().Del(ctx, failKey("mfa", user), lockKey("mfa", user)).Err(); err != nil {
+		return fmt.Errorf("reset mfa failures: %w", err)
+	}
+	return nil
+}
+
+// Success clears the account's password failures. The IP counter is kept,
+// so one valid login cannot wipe an IP's record of guessing other accounts,
+// and so are the second factor failures.
+func (g *Guard) Success(ctx context.Context, account string) error {
+	user := subject(account)
+	err := infra.GetRDB().Del(ctx, failKey("user", user), delayKey("user", user), lockKey("user", user)).Err()
+	if err != nil {
+		return fmt.Errorf("reset login failures: %w", err)
+	}
+	return nil
+}
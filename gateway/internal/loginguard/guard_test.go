@@ -0,0 +1,57 @@
+package loginguard
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, time.Second},
+		{3, 2 * time.Second},
+		{4, 4 * time.Second},
+		{8, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestSubject(t *testing.T) {
+	if subject("Alice") != subject("  alice ") {
+		t.Error("subject differs by case or surrounding space")
+	}
+	if subject("alice") == subject("bob") {
+		t.Error("subject is the same for different accounts")
+	}
+	if got := len(subject("alice")); got != 32 {
+		t.Errorf("len(subject) = %d, want 32", got)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("LOGIN_MAX_FAILURES", "3")
+	t.Setenv("LOGIN_IP_MAX_FAILURES", "-1")
+	t.Setenv("LOGIN_MFA_MAX_FAILURES", "20")
+	t.Setenv("LOGIN_FAILURE_WINDOW", "1h")
+	t.Setenv("LOGIN_LOCKOUT", "bogus")
+
+	want := Config{
+		MaxFailures:    3,
+		IPMaxFailures:  defaultIPMaxFailures,
+		MFAMaxFailures: 20,
+		Window:         time.Hour,
+		Lockout:        defaultLockout,
+	}
+	if got := FromEnv(); got != want {
+		t.Errorf("FromEnv() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+// Package mailer sends account emails. MAILER selects the implementation:
+// "smtp" delivers through an SMTP server, anything else uses the local
+// mailer, which writes messages to MAIL_DIR or to the log.
+package mailer
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv builds the mailer configured by the environment
+func FromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "codek7 <no-reply@localhost>"
+	}
+
+	if os.Getenv("MAILER") == "smtp" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		log.Printf("Mailer: SMTP via %s:%s", os.Getenv("SMTP_HOST"), port)
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	}
+
+	dir := os.Getenv("MAIL_DIR")
+	if dir != "" {
+		log.Printf("Mailer: writing messages to %s", dir)
+	} else {
+		log.Println("Mailer: logging messages, set MAILER=smtp to deliver them")
+	}
+	return &LocalMailer{Dir: dir, From: from}
+}
+
+// headerValue drops line breaks so values cannot inject headers
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
@@ -0,0 +1,230 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxBytes     = 4 << 30
+	defaultProbeTimeout = 30 * time.Second
+)
+
+// Info describes an uploaded video. Everything but Container is only known
+// when the file was probed.
+type Info struct {
+	Container string `json:"container"`
+	// Duration is in seconds
+	Duration   float64 `json:"duration,omitempty"`
+	VideoCodec string  `json:"video_codec,omitempty"`
+	AudioCodec string  `json:"audio_codec,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	FrameRate  float64 `json:"frame_rate,omitempty"`
+}
+
+// Config holds the upload limits, see FromEnv
+type Config struct {
+	// MaxBytes is the largest accepted file, 0 for no limit
+	MaxBytes int64
+	// MaxDuration is the longest accepted video, 0 for no limit. It is only
+	// enforced when probing.
+	MaxDuration time.Duration
+	// Probe runs FFprobePath on every upload
+	Probe        bool
+	FFprobePath  string
+	ProbeTimeout time.Duration
+}
+
+// FromEnv reads UPLOAD_MAX_BYTES, UPLOAD_MAX_DURATION, UPLOAD_PROBE and
+// FFPROBE_PATH
+func FromEnv() Config {
+	cfg := Config{
+		MaxBytes:     defaultMaxBytes,
+		FFprobePath:  "ffprobe",
+		ProbeTimeout: defaultProbeTimeout,
+	}
+	if n, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_BYTES"), 10, 64); err == nil && n >= 0 {
+		cfg.MaxBytes = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("UPLOAD_MAX_DURATION")); err == nil && d >= 0 {
+		cfg.MaxDuration = d
+	}
+	if probe, err := strconv.ParseBool(os.Getenv("UPLOAD_PROBE")); err == nil {
+		cfg.Probe = probe
+	}
+	if path := os.Getenv("FFPROBE_PATH"); path != "" {
+		cfg.FFprobePath = path
+	}
+	return cfg
+}
+
+// Validator rejects uploads that are not supported videos or are over the
+// limits
+type Validator struct {
+	cfg     Config
+	ffprobe string
+}
+
+// New returns a Validator for cfg. Probing is turned off, with a warning,
+// when ffprobe cannot be found.
+func New(cfg Config) *Validator {
+	v := &Validator{cfg: cfg}
+	if cfg.Probe {
+		path, err := exec.LookPath(cfg.FFprobePath)
+		if err != nil {
+			log.Printf("⚠️ Upload probing disabled, %s not found: %v", cfg.FFprobePath, err)
+		} else {
+			v.ffprobe = path
+		}
+	}
+	return v
+}
+
+// MaxBytes returns the largest accepted file size, 0 for no limit
+func (v *Validator) MaxBytes() int64 {
+	return v.cfg.MaxBytes
+}
+
+// CheckSize rejects files of size bytes over the limit
+func (v *Validator) CheckSize(size int64) error {
+	if v.cfg.MaxBytes > 0 && size > v.cfg.MaxBytes {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrTooLarge, size, v.cfg.MaxBytes)
+	}
+	return nil
+}
+
+// Check validates the upload at path and describes it
+func (v *Validator) Check(ctx context.Context, path string) (Info, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	if err := v.CheckSize(stat.Size()); err != nil {
+		return Info{}, err
+	}
+
+	container, err := SniffFile(path)
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{Container: container}
+	if v.ffprobe == "" {
+		return info, nil
+	}
+
+	if err := v.probe(ctx, path, &info); err != nil {
+		return Info{}, err
+	}
+	limit := v.cfg.MaxDuration.Seconds()
+	if limit > 0 && info.Duration > limit {
+		return Info{}, fmt.Errorf("%w: %.0fs, the limit is %.0fs", ErrTooLong, info.Duration, limit)
+	}
+	return info, nil
+}
+
+type probeOutput struct {
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+		RFrameRate   string `json:"r_frame_rate"`
+	} `json:"streams"`
+}
+
+// probe fills in info from ffprobe's view of the file
+func (v *Validator) probe(ctx context.Context, path string, info *Info) error {
+	timeout := v.cfg.ProbeTimeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, v.ffprobe,
+		"-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && ctx.Err() == nil {
+			// ffprobe names the file, which is of no use to the uploader
+			return fmt.Errorf("%w: %s", ErrCorrupt, strings.TrimPrefix(firstLine(stderr.String()), path+": "))
+		}
+		return fmt.Errorf("run ffprobe: %w", err)
+	}
+
+	var out probeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return fmt.Errorf("decode ffprobe output: %w", err)
+	}
+
+	info.Duration, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	for _, s := range out.Streams {
+		switch s.CodecType {
+		case "video":
+			if info.VideoCodec != "" {
+				continue
+			}
+			info.VideoCodec = s.CodecName
+			info.Width = s.Width
+			info.Height = s.Height
+			info.FrameRate = frameRate(s.AvgFrameRate)
+			if info.FrameRate == 0 {
+				info.FrameRate = frameRate(s.RFrameRate)
+			}
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = s.CodecName
+			}
+		}
+	}
+	if info.VideoCodec == "" {
+		return fmt.Errorf("%w: no video stream", ErrCorrupt)
+	}
+	if info.Duration <= 0 {
+		return fmt.Errorf("%w: unknown duration", ErrCorrupt)
+	}
+	return nil
+}
+
+// frameRate parses ffprobe's "30000/1001" rates
+func frameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if line, _, ok := strings.Cut(s, "\n"); ok {
+		s = line
+	}
+	if s == "" {
+		return "ffprobe could not read it"
+	}
+	return s
+}
@@ -0,0 +1,64 @@
+package media
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestFrameRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"30/1", 30},
+		{"30000/1001", 30000.0 / 1001},
+		{"25", 25},
+		{"23.976", 23.976},
+		{"0/0", 0},
+		{"30/0", 0},
+		{"a/1", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := frameRate(tt.in); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("frameRate(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	tests := []struct {
+		max, size int64
+		wantErr   bool
+	}{
+		{100, 100, false},
+		{100, 101, true},
+		{0, 1 << 40, false},
+	}
+	for _, tt := range tests {
+		err := New(Config{MaxBytes: tt.max}).CheckSize(tt.size)
+		if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrTooLarge)) {
+			t.Errorf("CheckSize(%d) with limit %d = %v, wantErr %v", tt.size, tt.max, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRejected(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{ErrUnsupported, true},
+		{ErrCorrupt, true},
+		{ErrTooLarge, true},
+		{ErrTooLong, true},
+		{errors.New("ffprobe timed out"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := Rejected(tt.err); got != tt.want {
+			t.Errorf("Rejected(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package media checks uploaded files before they enter the pipeline: the
+// container is recognized from the file's leading bytes, and ffprobe, when
+// enabled, reads the duration and streams.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Supported containers
+const (
+	ContainerMP4  = "mp4"
+	ContainerMOV  = "mov"
+	ContainerMKV  = "mkv"
+	ContainerWebM = "webm"
+)
+
+// SniffLen is how many leading bytes Sniff looks at
+const SniffLen = 512
+
+var (
+	// ErrUnsupported is returned for files that are not in a supported
+	// container
+	ErrUnsupported = errors.New("unsupported file type")
+	// ErrCorrupt is returned for files in a supported container that
+	// cannot be read
+	ErrCorrupt = errors.New("file is corrupt or not a video")
+	// ErrTooLarge is returned for files over Config.MaxBytes
+	ErrTooLarge = errors.New("file is too large")
+	// ErrTooLong is returned for videos over Config.MaxDuration
+	ErrTooLong = errors.New("video is too long")
+)
+
+// Rejected reports whether err rejects the file, rather than telling it
+// could not be checked
+func Rejected(err error) bool {
+	return errors.Is(err, ErrUnsupported) || errors.Is(err, ErrCorrupt) ||
+		errors.Is(err, ErrTooLarge) || errors.Is(err, ErrTooLong)
+}
+
+var ebmlMagic = []byte{0x1a, 0x45, 0xdf, 0xa3}
+
+// ISO base media brands of files that are not videos
+var nonVideoBrands = map[string]bool{
+	"M4A ": true, "M4B ": true, "M4P ": true,
+	"heic": true, "heix": true, "mif1": true, "msf1": true, "avif": true,
+	"crx ": true, "jp2 ": true,
+}
+
+// QuickTime atoms a file without an ftyp atom may start with
+var quickTimeAtoms = map[string]bool{
+	"moov": true, "mdat": true, "wide": true, "free": true, "skip": true, "pnot": true,
+}
+
+// Sniff returns the container of a file starting with head
+func Sniff(head []byte) (string, error) {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		brand := string(head[8:12])
+		if brand == "qt  " {
+			return ContainerMOV, nil
+		}
+		if nonVideoBrands[brand] {
+			return "", fmt.Errorf("%w: %q files are not videos", ErrUnsupported, brand)
+		}
+		return ContainerMP4, nil
+	}
+	if len(head) >= 8 && quickTimeAtoms[string(head[4:8])] {
+		return ContainerMOV, nil
+	}
+	if bytes.HasPrefix(head, ebmlMagic) {
+		switch docType(head) {
+		case "webm":
+			return ContainerWebM, nil
+		case "matroska":
+			return ContainerMKV, nil
+		}
+		return "", fmt.Errorf("%w: unknown EBML document type", ErrUnsupported)
+	}
+	return "", fmt.Errorf("%w: expected MP4, MOV, MKV or WebM", ErrUnsupported)
+}
+
+// SniffFile sniffs the file at path
+func SniffFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return Sniff(head[:n])
+}
+
+// Extension returns the file extension, dot included, of container
+func Extension(container string) string {
+	if container == "" {
+		return ""
+	}
+	return "." + container
+}
+
+// docType finds the DocType element of an EBML header
+func docType(head []byte) string {
+	i := bytes.Index(head, []byte{0x42, 0x82})
+	if i < 0 || i+2 >= len(head) {
+		return ""
+	}
+	size, n := vint(head[i+2:])
+	start := i + 2 + n
+	if n == 0 || size > 32 || start+int(size) > len(head) {
+		return ""
+	}
+	return string(bytes.TrimRight(head[start:start+int(size)], "\x00"))
+}
+
+// vint decodes an EBML variable length integer, returning its value and
+// length or 0 for an invalid one
+func vint(b []byte) (uint64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+	n := 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > len(b) {
+		return 0, 0
+	}
+	value := uint64(b[0] & (0xff >> n))
+	for _, c := range b[1:n] {
+		value = value<<8 | uint64(c)
+	}
+	return value, n
+}
@@ -0,0 +1,112 @@
+package media
+
+import (
+	"errors"
+	"testing"
+)
+
+// ebml builds an EBML header carrying docType
+func ebml(docType string) []byte {
+	head := append([]byte{}, ebmlMagic...)
+	head = append(head, 0x80|byte(len(docType)+3), 0x42, 0x82, 0x80|byte(len(docType)))
+	return append(head, docType...)
+}
+
+// atom builds a file starting with a box of type name followed by brand
+func atom(name, brand string) []byte {
+	return append([]byte{0, 0, 0, 0x20}, name+brand+"\x00\x00\x02\x00"...)
+}
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name    string
+		head    []byte
+		want    string
+		wantErr error
+	}{
+		{"mp4 isom", atom("ftyp", "isom"), ContainerMP4, nil},
+		{"mp4 mp42", atom("ftyp", "mp42"), ContainerMP4, nil},
+		{"mov ftyp", atom("ftyp", "qt  "), ContainerMOV, nil},
+		{"mov without ftyp", atom("moov", ""), ContainerMOV, nil},
+		{"mov starting with mdat", atom("mdat", ""), ContainerMOV, nil},
+		{"webm", ebml("webm"), ContainerWebM, nil},
+		{"mkv", ebml("matroska"), ContainerMKV, nil},
+		{"padded doc type", ebml("webm\x00\x00"), ContainerWebM, nil},
+		{"audio only mp4", atom("ftyp", "M4A "), "", ErrUnsupported},
+		{"heic image", atom("ftyp", "heic"), "", ErrUnsupported},
+		{"unknown EBML", ebml("other"), "", ErrUnsupported},
+		{"EBML without doc type", ebmlMagic, "", ErrUnsupported},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "", ErrUnsupported},
+		{"text", []byte("hello, world"), "", ErrUnsupported},
+		{"short ftyp", []byte("\x00\x00\x00\x20ftyp"), "", ErrUnsupported},
+		{"empty", nil, "", ErrUnsupported},
+	}
+	for _, tt := range tests {
+		got, err := Sniff(tt.head)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Sniff = %q, %v, want %q, %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestVint(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    []byte
+		want  uint64
+		wantN int
+	}{
+		{"one byte", []byte{0x84}, 4, 1},
+		{"one byte max", []byte{0xff}, 0x7f, 1},
+		{"two bytes", []byte{0x40, 0x02}, 2, 2},
+		{"two bytes with trailing data", []byte{0x41, 0x00, 0xff}, 0x100, 2},
+		{"four bytes", []byte{0x1a, 0x45, 0xdf, 0xa3}, 0x0a45dfa3, 4},
+		{"eight bytes", []byte{0x01, 0, 0, 0, 0, 0, 0, 0x05}, 5, 8},
+		{"truncated", []byte{0x40}, 0, 0},
+		{"zero first byte", []byte{0x00, 0x81}, 0, 0},
+		{"empty", nil, 0, 0},
+	}
+	for _, tt := range tests {
+		got, n := vint(tt.in)
+		if got != tt.want || n != tt.wantN {
+			t.Errorf("%s: vint(% x) = %d, %d, want %d, %d", tt.name, tt.in, got, n, tt.want, tt.wantN)
+		}
+	}
+}
+
+func TestDocType(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"webm", ebml("webm"), "webm"},
+		{"matroska", ebml("matroska"), "matroska"},
+		{"no element", ebmlMagic, ""},
+		{"element at the end", append(append([]byte{}, ebmlMagic...), 0x42, 0x82), ""},
+		{"size past the end", append(append([]byte{}, ebmlMagic...), 0x42, 0x82, 0x88, 'w'), ""},
+		{"size too large", append(append([]byte{}, ebmlMagic...), 0x42, 0x82, 0xc0), ""},
+	}
+	for _, tt := range tests {
+		if got := docType(tt.head); got != tt.want {
+			t.Errorf("%s: docType = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtension(t *testing.T) {
+	tests := []struct {
+		container, want string
+	}{
+		{ContainerMP4, ".mp4"},
+		{ContainerMOV, ".mov"},
+		{ContainerMKV, ".mkv"},
+		{ContainerWebM, ".webm"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Extension(tt.container); got != tt.want {
+			t.Errorf("Extension(%q) = %q, want %q", tt.container, got, tt.want)
+		}
+	}
+}
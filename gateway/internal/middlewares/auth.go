@@ -1,38 +1,96 @@
 package middlewares
 
 import (
+	"codek7/common/pb"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/lumbrjx/codek7/gateway/internal/session"
 	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the session_token cookie
-		fmt.Println("AuthMiddleware: Checking session_token cookie")
-		cookie, err := r.Cookie("session_token")
-		if err != nil || cookie.Value == "" {
-			http.Error(w, "Unauthorized: Missing session token", http.StatusUnauthorized)
-			fmt.Println("AuthMiddleware: Missing or invalid session_token cookie", err)
-			return
-
-		}
-		fmt.Println("AuthMiddleware: Found session_token cookie:", cookie.Value);
-
-		// Validate JWT token
-		userID, err := utils.ValidateToken(cookie.Value)
-		fmt.Println("AuthMiddleware: Validating userId:", userID)
-		if err != nil {
-			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
-			fmt.Println("AuthMiddleware: Invalid token:", err)
-			return
-		}
-
-		fmt.Println("AuthMiddleware: Validated user ID:", userID)
-
-		// Set user ID in context for use in handlers
-		ctx := utils.WithUserID(r.Context(), userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// apiKeyPrefix starts every API key issued by the repo service, other
+// bearer tokens are access tokens
+const apiKeyPrefix = "ck7_"
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// AuthMiddleware authenticates requests with an API key or an access token
+// sent as a bearer token, or with the session_token cookie. API keys are
+// resolved by the repo service. The scopes of the token or key are added to
+// the context for RequireScopes.
+func AuthMiddleware(repo pb.RepoServiceClient) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, isBearer := bearerToken(r)
+
+			if isBearer && strings.HasPrefix(token, apiKeyPrefix) {
+				key, err := repo.AuthenticateApiKey(r.Context(), &pb.AuthenticateApiKeyRequest{Secret: token})
+				if err != nil {
+					fmt.Println("AuthMiddleware: Rejected API key:", err)
+					if status.Code(err) == codes.Unauthenticated {
+						http.Error(w, "Unauthorized: Invalid API key", http.StatusUnauthorized)
+						return
+					}
+					http.Error(w, "Key store unavailable", http.StatusServiceUnavailable)
+					return
+				}
+
+				ctx := utils.WithUserID(r.Context(), key.UserId)
+				ctx = utils.WithAPIKey(ctx, key.Id)
+				ctx = utils.WithScopes(ctx, key.Scopes)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			if !isBearer {
+				// Get the session_token cookie
+				cookie, err := r.Cookie("session_token")
+				if err != nil || cookie.Value == "" {
+					http.Error(w, "Unauthorized: Missing session token", http.StatusUnauthorized)
+					fmt.Println("AuthMiddleware: Missing or invalid session_token cookie", err)
+					return
+				}
+				token = cookie.Value
+			}
+
+			// Validate JWT token
+			claims, err := utils.ValidateToken(token)
+			if err != nil {
+				http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+				fmt.Println("AuthMiddleware: Invalid token:", err)
+				return
+			}
+
+			// Refuse tokens revoked by logout or by refresh token reuse
+			if err := session.CheckAccessToken(r.Context(), claims); err != nil {
+				fmt.Println("AuthMiddleware: Rejected token:", err)
+				if errors.Is(err, session.ErrTokenRevoked) {
+					http.Error(w, "Unauthorized: Token revoked", http.StatusUnauthorized)
+					return
+				}
+				http.Error(w, "Session store unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			fmt.Println("AuthMiddleware: Validated user ID:", claims.UserID)
+
+			// Set user ID and scopes in context for use in handlers
+			ctx := utils.WithUserID(r.Context(), claims.UserID)
+			ctx = utils.WithScopes(ctx, claims.Scopes)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const defaultMaxBodyBytes = 1 << 20
+
+// MaxBodyBytesFromEnv reads MAX_BODY_BYTES, the body size LimitBody allows
+func MaxBodyBytesFromEnv() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("MAX_BODY_BYTES"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxBodyBytes
+}
+
+// LimitBody refuses request bodies over limit bytes with 413, up front when
+// they declare their length and otherwise once the handler reads past the
+// limit. Requests skip reports true for, uploads with limits of their own,
+// are left alone.
+func LimitBody(limit int64, skip func(*http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip != nil && skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > limit {
+				http.Error(w, fmt.Sprintf(`{"status":"error","message":"Request body is larger than %d bytes"}`, limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
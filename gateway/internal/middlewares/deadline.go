@@ -0,0 +1,53 @@
+package middlewares
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const defaultUploadTimeout = time.Hour
+
+// UploadTimeoutFromEnv reads UPLOAD_TIMEOUT, how long one upload request
+// may take to send its body and get an answer
+func UploadTimeoutFromEnv() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("UPLOAD_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	return defaultUploadTimeout
+}
+
+// Deadline gives a request d to be read and answered. The server only
+// bounds reading the headers, so routes pick their own deadline; a later
+// Deadline replaces an earlier one.
+func Deadline(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			deadline := time.Now().Add(d)
+			if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				log.Printf("Failed to set read deadline: %v", err)
+			}
+			if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				log.Printf("Failed to set write deadline: %v", err)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Except applies mw to the requests skip reports false for
+func Except(skip func(*http.Request) bool, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
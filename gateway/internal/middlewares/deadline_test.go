@@ -0,0 +1,73 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		ok       bool
+	}{
+		{"body sent in time", time.Second, true},
+		{"body too slow", 50 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		read := make(chan error, 1)
+		srv := httptest.NewServer(Deadline(tt.deadline)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			read <- err
+		})))
+
+		// The second half of the body comes after 200ms
+		pr, pw := io.Pipe()
+		go func() {
+			pw.Write([]byte("first half"))
+			time.Sleep(200 * time.Millisecond)
+			pw.Write([]byte("second half"))
+			pw.Close()
+		}()
+		req, err := http.NewRequest(http.MethodPatch, srv.URL, pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp, err := srv.Client().Do(req); err == nil {
+			resp.Body.Close()
+		}
+
+		if err := <-read; (err == nil) != tt.ok {
+			t.Errorf("%s: reading the body = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		srv.Close()
+	}
+}
+
+func TestExcept(t *testing.T) {
+	mark := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Wrapped", "true")
+			next.ServeHTTP(w, r)
+		})
+	}
+	h := Except(func(r *http.Request) bool { return r.Method == http.MethodPatch }, mark)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	tests := []struct {
+		method  string
+		wrapped bool
+	}{
+		{http.MethodGet, true},
+		{http.MethodPatch, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, "/", nil))
+		if got := w.Header().Get("X-Wrapped") == "true"; got != tt.wrapped {
+			t.Errorf("%s: wrapped = %v, want %v", tt.method, got, tt.wrapped)
+		}
+	}
+}
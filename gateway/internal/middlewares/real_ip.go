@@ -0,0 +1,107 @@
+package middlewares
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// TrustedProxiesFromEnv reads TRUSTED_PROXIES, a comma separated list of the
+// addresses or CIDR ranges of the proxies in front of the gateway
+func TrustedProxiesFromEnv() []netip.Prefix {
+	var trusted []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				log.Printf("⚠️ Ignoring invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+				continue
+			}
+			trusted = append(trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			log.Printf("⚠️ Ignoring invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+			continue
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+	return trusted
+}
+
+// RealIP replaces RemoteAddr with the client address a trusted proxy
+// forwarded in X-Forwarded-For or X-Real-IP. Other peers cannot set these
+// headers to pick the address rate limits and lockouts count against.
+func RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedClient(r.RemoteAddr, r.Header, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClient returns the client address forwarded to the peer at
+// remoteAddr, or "" when the peer is not trusted or forwarded none. Proxies
+// append to X-Forwarded-For, so it is read from the right and the first
+// address that is not a trusted proxy is the client.
+func forwardedClient(remoteAddr string, header http.Header, trusted []netip.Prefix) string {
+	if !isTrusted(hostAddr(remoteAddr), trusted) {
+		return ""
+	}
+
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			return ""
+		}
+		if i == 0 || !isTrusted(addr, trusted) {
+			return addr.Unmap().String()
+		}
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSpace(header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+	return ""
+}
+
+func hostAddr(remoteAddr string) netip.Addr {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func TestForwardedClient(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		xRealIP    string
+		want       string
+	}{
+		{"untrusted peer is ignored", "203.0.113.7:5000", []string{"198.51.100.1"}, "", ""},
+		{"untrusted peer cannot set X-Real-IP", "203.0.113.7:5000", nil, "198.51.100.1", ""},
+		{"trusted peer without headers", "10.1.2.3:5000", nil, "", ""},
+		{"single hop", "10.1.2.3:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"spoofed leftmost hop is skipped", "10.1.2.3:5000", []string{"1.2.3.4, 198.51.100.1"}, "", "198.51.100.1"},
+		{"trusted hops are skipped", "192.0.2.1:5000", []string{"198.51.100.1, 10.9.9.9"}, "", "198.51.100.1"},
+		{"headers are joined", "10.1.2.3:5000", []string{"1.2.3.4", "198.51.100.1"}, "", "198.51.100.1"},
+		{"all hops trusted", "10.1.2.3:5000", []string{"10.0.0.5, 10.0.0.6"}, "", "10.0.0.5"},
+		{"invalid hop", "10.1.2.3:5000", []string{"not-an-ip"}, "", ""},
+		{"X-Real-IP from trusted peer", "10.1.2.3:5000", nil, "198.51.100.1", "198.51.100.1"},
+		{"mapped IPv4 peer", "[::ffff:10.1.2.3]:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"IPv6 client", "10.1.2.3:5000", []string{"2001:db8::1"}, "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, v := range tt.xff {
+				header.Add("X-Forwarded-For", v)
+			}
+			if tt.xRealIP != "" {
+				header.Set("X-Real-IP", tt.xRealIP)
+			}
+			if got := forwardedClient(tt.remoteAddr, header, trusted); got != tt.want {
+				t.Errorf("forwardedClient(%q) = %q, want %q", tt.remoteAddr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesFromEnv(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1,bogus, ,2001:db8::/32")
+
+	got := TrustedProxiesFromEnv()
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("TrustedProxiesFromEnv() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("TrustedProxiesFromEnv()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+)
+
+// RequireScopes refuses requests that were not granted every one of scopes.
+// It must run after AuthMiddleware.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, scope := range scopes {
+				if !utils.HasScope(r.Context(), scope) {
+					userID, _ := utils.GetUserID(r.Context())
+					log.Printf("User %s lacks scope %s for %s %s", userID, scope, r.Method, r.URL.Path)
+					http.Error(w, fmt.Sprintf(`{"status":"error","message":"Forbidden: requires %s"}`, strings.Join(scopes, ", ")), http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/infra"
+	"github.com/redis/go-redis/v9"
+)
+
+// StateTTL is how long a user has to complete a login at the provider
+const StateTTL = 10 * time.Minute
+
+var ErrInvalidState = errors.New("invalid or expired login state")
+
+// LoginState is kept between the redirect to the provider and the callback
+type LoginState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	ReturnTo string `json:"return_to"`
+}
+
+func stateKey(state string) string {
+	return "auth:oidc:state:" + state
+}
+
+// SaveState stores a login under its state parameter
+func SaveState(ctx context.Context, state string, ls LoginState) error {
+	data, err := json.Marshal(ls)
+	if err != nil {
+		return err
+	}
+	if err := infra.GetRDB().Set(ctx, stateKey(state), data, StateTTL).Err(); err != nil {
+		return fmt.Errorf("save login state: %w", err)
+	}
+	return nil
+}
+
+// TakeState returns the login for state and deletes it, so a callback can
+// only be completed once
+func TakeState(ctx context.Context, state string) (*LoginState, error) {
+	data, err := infra.GetRDB().GetDel(ctx, stateKey(state)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load login state: %w", err)
+	}
+	var ls LoginState
+	if err := json.Unmarshal(data, &ls); err != nil {
+		return nil, ErrInvalidState
+	}
+	return &ls, nil
+}
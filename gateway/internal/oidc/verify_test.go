@@ -0,0 +1,164 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	tests := []struct {
+		verifier string
+		want     string
+	}{
+		// The SHA-256 digest, base64url encoded without padding
+		{"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFLEjXk", "2mteMSB1oC0-IAiOmOfpXxFMM_QK01O9DTXhX8HgFnM"},
+		{"", "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU"},
+	}
+	for _, tt := range tests {
+		if got := codeChallenge(tt.verifier); got != tt.want {
+			t.Errorf("codeChallenge(%q) = %q, want %q", tt.verifier, got, tt.want)
+		}
+	}
+}
+
+func TestFlexBool(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{`true`, true},
+		{`false`, false},
+		{`"true"`, true},
+		{`"false"`, false},
+		{`"yes"`, false},
+		{`null`, false},
+	}
+	for _, tt := range tests {
+		var b flexBool
+		if err := json.Unmarshal([]byte(tt.in), &b); err != nil {
+			t.Errorf("Unmarshal(%s): %v", tt.in, err)
+			continue
+		}
+		if bool(b) != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, want %v", tt.in, b, tt.want)
+		}
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := base64.RawURLEncoding.EncodeToString(pub)
+
+	tests := []struct {
+		name    string
+		jwk     utils.JSONWebKey
+		wantErr bool
+	}{
+		{"ed25519", utils.JSONWebKey{Kty: "OKP", Crv: "Ed25519", X: x}, false},
+		{"rsa", utils.JSONWebKey{Kty: "RSA", N: "AQAB", E: "AQAB"}, false},
+		{"short ed25519", utils.JSONWebKey{Kty: "OKP", Crv: "Ed25519", X: "AQAB"}, true},
+		{"x25519", utils.JSONWebKey{Kty: "OKP", Crv: "X25519", X: x}, true},
+		{"unknown curve", utils.JSONWebKey{Kty: "EC", Crv: "P-192"}, true},
+		{"shared secret", utils.JSONWebKey{Kty: "oct"}, true},
+	}
+	for _, tt := range tests {
+		_, err := publicKey(tt.jwk)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(Config{Name: "test", ClientID: "client"})
+	// A fresh key set is used as is, nothing is fetched
+	p.keys = &keySet{keys: map[string]any{"k1": pub}, fetched: time.Now()}
+	meta := &discovery{Issuer: "https://issuer.example", JWKSURI: "https://issuer.example/jwks"}
+
+	now := time.Now()
+	valid := func() idTokenClaims {
+		return idTokenClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    meta.Issuer,
+				Subject:   "user-1",
+				Audience:  jwt.ClaimStrings{"client"},
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+				IssuedAt:  jwt.NewNumericDate(now),
+			},
+			Nonce:         "nonce",
+			Email:         "a@example.com",
+			EmailVerified: true,
+		}
+	}
+	sign := func(method jwt.SigningMethod, key any, kid string, claims idTokenClaims) string {
+		tok := jwt.NewWithClaims(method, claims)
+		tok.Header["kid"] = kid
+		raw, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	with := func(edit func(*idTokenClaims)) string {
+		c := valid()
+		edit(&c)
+		return sign(jwt.SigningMethodEdDSA, priv, "k1", c)
+	}
+
+	tests := []struct {
+		name  string
+		raw   string
+		nonce string
+		ok    bool
+	}{
+		{"valid", with(func(*idTokenClaims) {}), "nonce", true},
+		{"within clock skew", with(func(c *idTokenClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-clockSkew / 2)) }), "nonce", true},
+		{"wrong nonce", with(func(*idTokenClaims) {}), "other", false},
+		{"no nonce", with(func(c *idTokenClaims) { c.Nonce = "" }), "", false},
+		{"wrong issuer", with(func(c *idTokenClaims) { c.Issuer = "https://evil.example" }), "nonce", false},
+		{"wrong audience", with(func(c *idTokenClaims) { c.Audience = jwt.ClaimStrings{"other"} }), "nonce", false},
+		{"multiple audiences without azp", with(func(c *idTokenClaims) { c.Audience = jwt.ClaimStrings{"client", "other"} }), "nonce", false},
+		{"multiple audiences with azp", with(func(c *idTokenClaims) {
+			c.Audience = jwt.ClaimStrings{"client", "other"}
+			c.AuthorizedParty = "client"
+		}), "nonce", true},
+		{"expired", with(func(c *idTokenClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-2 * clockSkew)) }), "nonce", false},
+		{"no expiry", with(func(c *idTokenClaims) { c.ExpiresAt = nil }), "nonce", false},
+		{"issued in the future", with(func(c *idTokenClaims) { c.IssuedAt = jwt.NewNumericDate(now.Add(2 * clockSkew)) }), "nonce", false},
+		{"no subject", with(func(c *idTokenClaims) { c.Subject = "" }), "nonce", false},
+		{"unknown kid", sign(jwt.SigningMethodEdDSA, priv, "k2", valid()), "nonce", false},
+		{"shared secret", sign(jwt.SigningMethodHS256, []byte("client-secret"), "k1", valid()), "nonce", false},
+		{"unsigned", sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "k1", valid()), "nonce", false},
+		{"garbage", "not.a.token", "nonce", false},
+	}
+	for _, tt := range tests {
+		id, err := p.verifyIDToken(context.Background(), meta, tt.raw, tt.nonce)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if id.Subject != "user-1" || id.Email != "a@example.com" || !id.EmailVerified {
+				t.Errorf("%s: identity = %+v", tt.name, id)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidIDToken", tt.name, err)
+		}
+	}
+}
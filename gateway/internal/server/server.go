@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,8 +15,14 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/lumbrjx/codek7/gateway/internal/api"
 	"github.com/lumbrjx/codek7/gateway/internal/infra"
+	"github.com/lumbrjx/codek7/gateway/internal/ingest"
+	"github.com/lumbrjx/codek7/gateway/internal/loginguard"
+	"github.com/lumbrjx/codek7/gateway/internal/mailer"
+	"github.com/lumbrjx/codek7/gateway/internal/media"
 	"github.com/lumbrjx/codek7/gateway/internal/middlewares"
+	"github.com/lumbrjx/codek7/gateway/internal/oidc"
 	"github.com/lumbrjx/codek7/gateway/internal/watcher"
+	"github.com/lumbrjx/codek7/gateway/pkg/utils"
 	// "github.com/lai0xn/codek-gateway/internal/middlewares"
 )
 
@@ -33,6 +40,10 @@ func NewServer(port string) *Server {
 		port = "8080"
 	}
 
+	if err := utils.CheckPlaybackSecret(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
 	kafkaProducer, err := infra.MakeKafkaProducer()
 	if err != nil {
 		log.Fatalf("Error: %v", err)
@@ -52,9 +63,17 @@ func NewServer(port string) *Server {
 	}
 
 	s := &Server{
-		router:  chi.NewRouter(),
-		port:    port,
-		api:     &api.API{Producer: kafkaProducer, RepoClient: grpcClient, Hub: hub},
+		router: chi.NewRouter(),
+		port:   port,
+		api: &api.API{
+			Ingest:     ingest.NewPublisher(kafkaProducer),
+			RepoClient: grpcClient,
+			Hub:        hub,
+			Mailer:     mailer.FromEnv(),
+			LoginGuard: loginguard.New(loginguard.FromEnv()),
+			OIDC:       oidc.FromEnv(),
+			Media:      media.New(media.FromEnv()),
+		},
 		watcher: watcherInstance,
 		hub:     hub,
 	}
@@ -65,6 +84,9 @@ func NewServer(port string) *Server {
 	// Start the hub in a goroutine
 	go hub.Run()
 
+	// Sweep partial uploads whose session has expired
+	api.StartUploadJanitor(context.Background(), time.Hour)
+
 	// Start the watcher in a goroutine
 	if err := watcherInstance.Start(); err != nil {
 		log.Fatalf("Failed to start watcher: %v", err)
@@ -78,16 +100,22 @@ func (s *Server) setupMiddleware() {
 	s.router.Use(middleware.Logger)
 	s.router.Use(middleware.Recoverer)
 	s.router.Use(middleware.RequestID)
-	s.router.Use(middleware.RealIP)
+	// Forwarded client addresses are only taken from TRUSTED_PROXIES
+	s.router.Use(middlewares.RealIP(middlewares.TrustedProxiesFromEnv()))
 	s.router.Use(middleware.SetHeader("Content-Type", "application/json"))
-	s.router.Use(middleware.Timeout(60 * time.Second))
+	// Uploads get UPLOAD_TIMEOUT from their routes instead, and are limited
+	// by UPLOAD_MAX_BYTES and the declared length of their session
+	s.router.Use(middlewares.Except(isUpload, middlewares.Deadline(15*time.Second)))
+	s.router.Use(middlewares.Except(isUpload, middleware.Timeout(60*time.Second)))
+	s.router.Use(middlewares.LimitBody(middlewares.MaxBodyBytesFromEnv(), isUpload))
 	// s.router.Use(middlewares.RateLimitMiddleware(infra.GetRDB(), 10, time.Minute*1))
 	// CORS middleware
 	s.router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token, Upload-Offset, Upload-Length, Range, If-Range, If-None-Match, If-Modified-Since")
+			w.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Accept-Ranges, Content-Range, Content-Length, ETag, Last-Modified")
 			w.Header().Set("Access-Control-Allow-Credentials", "true") // ✅ correct
 
 			if r.Method == "OPTIONS" {
@@ -100,52 +128,126 @@ func (s *Server) setupMiddleware() {
 	})
 }
 
+// isUpload reports whether r sends the content of an upload, which takes
+// longer than other requests and is larger than MAX_BODY_BYTES
+func isUpload(r *http.Request) bool {
+	return (r.Method == http.MethodPost && r.URL.Path == "/videos/upload") ||
+		(r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/videos/uploads/"))
+}
+
 // setupRoutes configures all routes
 func (s *Server) setupRoutes() {
+	uploadDeadline := middlewares.Deadline(middlewares.UploadTimeoutFromEnv())
+
 	// Health check endpoint
 	s.router.Get("/health", s.api.HealthCheck)
 	// Videos routes group
 	s.router.Route("/videos", func(r chi.Router) {
-		r.Use(middlewares.AuthMiddleware)
+		r.Use(middlewares.AuthMiddleware(s.api.RepoClient))
 
-		r.Post("/upload", s.api.UploadFile)
-		r.Get("/{video_id}/download", s.api.DownloadVideo)
-		r.Get("/{video_id}", s.api.GetVideoByID)
-		r.Get("/user", s.api.GetUserVideos)
-		r.Get("/recent", s.api.GetRecentUserVideos)
+		read := r.With(middlewares.RequireScopes(utils.ScopeVideosRead))
+		upload := r.With(middlewares.RequireScopes(utils.ScopeVideosUpload))
+		update := r.With(middlewares.RequireScopes(utils.ScopeVideosUpdate))
+		remove := r.With(middlewares.RequireScopes(utils.ScopeVideosDelete))
+
+		read.Get("/", s.api.ListVideos)
+		upload.With(uploadDeadline).Post("/upload", s.api.UploadFile)
+		r.Route("/uploads", func(r chi.Router) {
+			r.Use(middlewares.RequireScopes(utils.ScopeVideosUpload))
+			r.Post("/", s.api.CreateUpload)
+			r.Head("/{upload_id}", s.api.GetUploadOffset)
+			r.With(uploadDeadline).Patch("/{upload_id}", s.api.PatchUpload)
+			r.Delete("/{upload_id}", s.api.DeleteUpload)
+			r.Post("/{upload_id}/finalize", s.api.FinalizeUpload)
+		})
+		read.Get("/{video_id}/download", s.api.DownloadVideo)
+		read.Head("/{video_id}/download", s.api.DownloadVideo)
+		read.Post("/{video_id}/playback", s.api.CreatePlaybackToken)
+		update.Delete("/{video_id}/playback", s.api.RevokePlaybackTokens)
+		update.Put("/{video_id}/visibility", s.api.UpdateVideoVisibility)
+		read.Get("/{video_id}", s.api.GetVideoByID)
+		read.Get("/{video_id}/status-history", s.api.GetVideoStatusHistory)
+		remove.Delete("/{video_id}", s.api.DeleteVideo)
+		remove.Post("/{video_id}/restore", s.api.RestoreVideo)
+		read.Get("/user", s.api.GetUserVideos)
+		read.Get("/recent", s.api.GetRecentUserVideos)
 	})
 	// Static and streaming routes with auth
 	s.router.Route("/static", func(r chi.Router) {
-		r.Use(middlewares.AuthMiddleware)
+		r.Use(middlewares.AuthMiddleware(s.api.RepoClient))
+		r.Use(middlewares.RequireScopes(utils.ScopeVideosRead))
 		r.Handle("/*", http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
 	})
 
+	// HLS objects are authorized by the signed playback token in the query
 	s.router.Route("/hls", func(r chi.Router) {
 		r.Get("/*", s.api.StreamFromMinIO)
+		r.Head("/*", s.api.StreamFromMinIO)
 	})
 
 	// WebSocket endpoint for notifications with auth
 	s.router.Route("/ws", func(r chi.Router) {
-		r.Use(middlewares.AuthMiddleware)
+		r.Use(middlewares.AuthMiddleware(s.api.RepoClient))
 		r.Get("/notifications", s.api.WebSocketHandler)
 	})
 
 	s.router.Get("/er/{user_id}", s.api.ErHandler)
 
+	// Profile of the logged in user
+	s.router.Route("/me", func(r chi.Router) {
+		r.Use(middlewares.AuthMiddleware(s.api.RepoClient))
+		r.Get("/", s.api.GetMe)
+		r.Patch("/", s.api.UpdateProfile)
+		r.Delete("/", s.api.DeleteAccount)
+		r.Put("/password", s.api.ChangePassword)
+		r.Get("/quota", s.api.GetQuota)
+		r.Get("/2fa", s.api.GetTwoFactor)
+		r.Delete("/2fa", s.api.DisableTwoFactor)
+		r.Post("/2fa/enroll", s.api.EnrollTwoFactor)
+		r.Post("/2fa/confirm", s.api.ConfirmTwoFactor)
+		r.Post("/2fa/recovery-codes", s.api.RegenerateRecoveryCodes)
+	})
+
+	// Admin routes
+	s.router.Route("/admin", func(r chi.Router) {
+		r.Use(middlewares.AuthMiddleware(s.api.RepoClient))
+		r.With(middlewares.RequireScopes(utils.ScopeAdminUsers)).Get("/users", s.api.ListUsers)
+		r.With(middlewares.RequireScopes(utils.ScopeAdminUsers)).Put("/users/{user_id}/role", s.api.SetUserRole)
+		r.With(middlewares.RequireScopes(utils.ScopeAdminUsers)).Get("/users/{user_id}/quota", s.api.GetUserQuota)
+		r.With(middlewares.RequireScopes(utils.ScopeAdminUsers)).Put("/users/{user_id}/quota", s.api.SetUserQuota)
+		r.With(middlewares.RequireScopes(utils.ScopeAdminStorage)).Post("/storage/orphans", s.api.CollectOrphans)
+	})
+
 	// Auth routes
 	s.router.Post("/auth/login", s.api.Login)
+	s.router.Post("/auth/login/2fa", s.api.LoginSecondFactor)
 	s.router.Post("/auth/logout", s.api.Logout)
+	s.router.Post("/auth/refresh", s.api.Refresh)
+	s.router.Get("/.well-known/jwks.json", s.api.JWKS)
 	s.router.Post("/auth/register", s.api.Register)
+	s.router.With(middlewares.AuthMiddleware(s.api.RepoClient)).Post("/auth/verify-email/request", s.api.RequestEmailVerification)
+	s.router.Post("/auth/verify-email", s.api.ConfirmEmail)
+	s.router.Post("/auth/password-reset/request", s.api.RequestPasswordReset)
+	s.router.Post("/auth/password-reset", s.api.ResetPassword)
+	s.router.Get("/auth/oidc/providers", s.api.OIDCProviders)
+	s.router.Get("/auth/oidc/{provider}/login", s.api.OIDCLogin)
+	s.router.Get("/auth/oidc/{provider}/callback", s.api.OIDCCallback)
+	s.router.Route("/auth/api-keys", func(r chi.Router) {
+		r.Use(middlewares.AuthMiddleware(s.api.RepoClient))
+		r.Post("/", s.api.CreateAPIKey)
+		r.Get("/", s.api.ListAPIKeys)
+		r.Delete("/{key_id}", s.api.RevokeAPIKey)
+	})
 }
 
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	srv := &http.Server{
-		Addr:         ":" + s.port,
-		Handler:      s.router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:    ":" + s.port,
+		Handler: s.router,
+		// Bodies and answers are bounded per route, see middlewares.Deadline
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
 	}
 
 	go func() {
@@ -0,0 +1,84 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/infra"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// MFATokenTTL is how long a user has to enter their second factor
+	MFATokenTTL = 5 * time.Minute
+	// maxMFAAttempts wrong codes end the login, the password is asked again
+	maxMFAAttempts = 5
+)
+
+var ErrInvalidMFAToken = errors.New("invalid or expired mfa token")
+
+// An MFA token is the interim credential between a correct password and a
+// verified second factor. It grants nothing but the right to submit a code
+// for its user.
+func mfaKey(hash string) string {
+	return "auth:mfa:" + hash
+}
+
+// StartMFA issues an interim token for userID
+func StartMFA(ctx context.Context, userID string) (string, error) {
+	token := newRefreshToken()
+	key := mfaKey(hashRefreshToken(token))
+	_, err := infra.GetRDB().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user_id", userID, "attempts", 0)
+		pipe.Expire(ctx, key, MFATokenTTL)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("store mfa token: %w", err)
+	}
+	return token, nil
+}
+
+// mfaAttemptScript spends one attempt of an interim token and returns its
+// user, or nil once the token is gone or out of attempts. Counting and
+// checking in one step means concurrent guesses cannot exceed the limit.
+var mfaAttemptScript = redis.NewScript(`
+local user = redis.call("HGET", KEYS[1], "user_id")
+if not user then
+	return false
+end
+if redis.call("HINCRBY", KEYS[1], "attempts", 1) > tonumber(ARGV[1]) then
+	redis.call("DEL", KEYS[1])
+	return false
+end
+return user
+`)
+
+// MFAAttempt spends one of the interim token's attempts, before the code is
+// checked, and returns the user it was issued to. The token stops working
+// after maxMFAAttempts.
+func MFAAttempt(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", ErrInvalidMFAToken
+	}
+	userID, err := mfaAttemptScript.Run(ctx, infra.GetRDB(), []string{mfaKey(hashRefreshToken(token))}, maxMFAAttempts).Text()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrInvalidMFAToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("count mfa attempt: %w", err)
+	}
+	return userID, nil
+}
+
+// FinishMFA spends an interim token. Only the first of concurrent callers
+// gets true.
+func FinishMFA(ctx context.Context, token string) (bool, error) {
+	n, err := infra.GetRDB().Del(ctx, mfaKey(hashRefreshToken(token))).Result()
+	if err != nil {
+		return false, fmt.Errorf("delete mfa token: %w", err)
+	}
+	return n == 1, nil
+}
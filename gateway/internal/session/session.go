@@ -0,0 +1,265 @@
+// Package session keeps login sessions, rotating refresh tokens and the
+// access token revocation list in Redis.
+//
+// A session is one refresh token family. Every refresh hands out a new
+// refresh token and marks the old one used; presenting a used token again
+// means it was copied, and the whole session is revoked.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lumbrjx/codek7/gateway/internal/infra"
+	"github.com/lumbrjx/codek7/gateway/pkg/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrTokenRevoked        = errors.New("token revoked")
+)
+
+// RefreshTokenTTL reads REFRESH_TOKEN_TTL, 30 days by default. Sessions end
+// when their refresh token is not used within this period.
+func RefreshTokenTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return defaultRefreshTokenTTL
+}
+
+func sessionKey(sid string) string {
+	return "auth:session:" + sid
+}
+
+func refreshKey(hash string) string {
+	return "auth:refresh:" + hash
+}
+
+// userSessionsKey indexes the sessions of a user so they can all be revoked
+func userSessionsKey(userID string) string {
+	return "auth:user:sessions:" + userID
+}
+
+func revokedJTIKey(jti string) string {
+	return "auth:revoked:jti:" + jti
+}
+
+func revokedSessionKey(sid string) string {
+	return "auth:revoked:sid:" + sid
+}
+
+// hashRefreshToken keeps raw refresh tokens out of Redis
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Tokens is what a login or refresh hands to the client
+type Tokens struct {
+	AccessToken  string
+	AccessClaims *utils.Claims
+	RefreshToken string
+}
+
+// User is who a session is for. Role and scopes are fixed for the life of
+// the session; changing them revokes the user's sessions.
+type User struct {
+	ID     string
+	Role   string
+	Scopes []string
+}
+
+// Create starts a session for user and issues its first token pair
+func Create(ctx context.Context, user User) (*Tokens, error) {
+	sid := utils.NewTokenID()
+	rdb := infra.GetRDB()
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, sessionKey(sid),
+			"user_id", user.ID,
+			"role", user.Role,
+			"scopes", strings.Join(user.Scopes, " "),
+			"created_at", time.Now().Unix(),
+		)
+		pipe.SAdd(ctx, userSessionsKey(user.ID), sid)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	return issue(ctx, user, sid)
+}
+
+// issue signs an access token and stores a fresh refresh token for the session
+func issue(ctx context.Context, user User, sid string) (*Tokens, error) {
+	access, claims, err := utils.GenToken(user.ID, sid, user.Role, user.Scopes)
+	if err != nil {
+		return nil, fmt.Errorf("sign access token: %w", err)
+	}
+
+	refresh := newRefreshToken()
+	hash := hashRefreshToken(refresh)
+	ttl := RefreshTokenTTL()
+
+	rdb := infra.GetRDB()
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, refreshKey(hash), "user_id", user.ID, "session_id", sid, "used", 0)
+		pipe.Expire(ctx, refreshKey(hash), ttl)
+		pipe.HSet(ctx, sessionKey(sid), "refresh", hash)
+		pipe.Expire(ctx, sessionKey(sid), ttl)
+		pipe.Expire(ctx, userSessionsKey(user.ID), ttl)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("store refresh token: %w", err)
+	}
+
+	return &Tokens{AccessToken: access, AccessClaims: claims, RefreshToken: refresh}, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair. The presented
+// token can only be used once; reusing it revokes the session.
+func Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	rdb := infra.GetRDB()
+	key := refreshKey(hashRefreshToken(refreshToken))
+
+	fields, err := rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("load refresh token: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+	userID, sid := fields["user_id"], fields["session_id"]
+
+	// HINCRBY is atomic, only the first of concurrent refreshes sees 1
+	used, err := rdb.HIncrBy(ctx, key, "used", 1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("mark refresh token used: %w", err)
+	}
+	if used > 1 {
+		if err := Revoke(ctx, sid); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	sess, err := rdb.HGetAll(ctx, sessionKey(sid)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("load session: %w", err)
+	}
+	// Sessions started before roles existed have no role and must log in again
+	if len(sess) == 0 || sess["role"] == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return issue(ctx, User{ID: userID, Role: sess["role"], Scopes: strings.Fields(sess["scopes"])}, sid)
+}
+
+// Revoke ends a session. Its refresh token stops working immediately and
+// access tokens issued within it are refused until they would have expired.
+func Revoke(ctx context.Context, sid string) error {
+	rdb := infra.GetRDB()
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionKey(sid))
+		pipe.Set(ctx, revokedSessionKey(sid), 1, utils.AccessTokenTTL())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeUser ends every session of a user, used when their role or scopes
+// change so new tokens carry the new grants
+func RevokeUser(ctx context.Context, userID string) error {
+	rdb := infra.GetRDB()
+	sids, err := rdb.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return fmt.Errorf("list user sessions: %w", err)
+	}
+	for _, sid := range sids {
+		if err := Revoke(ctx, sid); err != nil {
+			return err
+		}
+	}
+	if err := rdb.Del(ctx, userSessionsKey(userID)).Err(); err != nil {
+		return fmt.Errorf("clear user sessions: %w", err)
+	}
+	return nil
+}
+
+// UpdateGrants changes the role and scopes of a user's live sessions. It is
+// for grants that widen access, such as verifying an email; the next refresh
+// issues tokens carrying them. Narrowing grants must use RevokeUser.
+func UpdateGrants(ctx context.Context, user User) error {
+	rdb := infra.GetRDB()
+	sids, err := rdb.SMembers(ctx, userSessionsKey(user.ID)).Result()
+	if err != nil {
+		return fmt.Errorf("list user sessions: %w", err)
+	}
+	for _, sid := range sids {
+		// HSET would recreate an expired session without a TTL
+		n, err := rdb.Exists(ctx, sessionKey(sid)).Result()
+		if err != nil {
+			return fmt.Errorf("load session: %w", err)
+		}
+		if n == 0 {
+			continue
+		}
+		if err := rdb.HSet(ctx, sessionKey(sid), "role", user.Role, "scopes", strings.Join(user.Scopes, " ")).Err(); err != nil {
+			return fmt.Errorf("update session: %w", err)
+		}
+	}
+	return nil
+}
+
+// RevokeAccessToken puts a single access token on the revocation list until
+// it expires
+func RevokeAccessToken(ctx context.Context, claims *utils.Claims) error {
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := infra.GetRDB().Set(ctx, revokedJTIKey(claims.ID), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("revoke access token: %w", err)
+	}
+	return nil
+}
+
+// CheckAccessToken returns ErrTokenRevoked when the token or its session
+// was revoked
+func CheckAccessToken(ctx context.Context, claims *utils.Claims) error {
+	n, err := infra.GetRDB().Exists(ctx, revokedJTIKey(claims.ID), revokedSessionKey(claims.SessionID)).Result()
+	if err != nil {
+		return fmt.Errorf("check revocation list: %w", err)
+	}
+	if n > 0 {
+		return ErrTokenRevoked
+	}
+	return nil
+}
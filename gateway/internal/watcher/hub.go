@@ -12,6 +12,44 @@ type Client struct {
 	UserID string
 	Conn   *websocket.Conn
 	Send   chan Notification
+
+	// subscriptions narrows the client down to some videos and uploads,
+	// without any it gets all notifications of its user
+	subscriptions map[string]bool
+	subMutex      sync.Mutex
+}
+
+// Subscribe limits the client to notifications about id, in addition to
+// the ids it is already subscribed to
+func (c *Client) Subscribe(id string) {
+	c.subMutex.Lock()
+	defer c.subMutex.Unlock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]bool)
+	}
+	c.subscriptions[id] = true
+}
+
+// Unsubscribe drops id, a client without subscriptions left gets all
+// notifications again
+func (c *Client) Unsubscribe(id string) {
+	c.subMutex.Lock()
+	defer c.subMutex.Unlock()
+	delete(c.subscriptions, id)
+}
+
+func (c *Client) wants(notification Notification) bool {
+	c.subMutex.Lock()
+	defer c.subMutex.Unlock()
+	if len(c.subscriptions) == 0 {
+		return true
+	}
+	for id := range c.subscriptions {
+		if notification.Concerns(id) {
+			return true
+		}
+	}
+	return false
 }
 
 // Hub maintains active clients and broadcasts notifications
@@ -89,15 +127,19 @@ func (h *Hub) unregisterClient(client *Client) {
 
 func (h *Hub) broadcastToUser(notification Notification) {
 	h.mutex.RLock()
-	clients := h.clients[notification.UserID]
+	clients := append([]*Client(nil), h.clients[notification.UserID]...)
 	h.mutex.RUnlock()
 
 	for _, client := range clients {
+		if !client.wants(notification) {
+			continue
+		}
 		select {
 		case client.Send <- notification:
 		default:
-			// Client's send channel is full, disconnect them
-			h.unregister <- client
+			// Client's send channel is full, disconnect them. This runs on
+			// the hub loop, which is the reader of h.unregister.
+			h.unregisterClient(client)
 		}
 	}
 }
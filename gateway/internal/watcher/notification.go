@@ -2,11 +2,68 @@ package watcher
 
 import "time"
 
+// Event types
+const (
+	EventSuccess  = "success"
+	EventError    = "error"
+	EventProgress = "progress"
+	// EventProcessing is what vcodec sends while transcoding
+	EventProcessing = "processing"
+)
+
+// Stages of an upload, reported by progress events
+const (
+	StageReceiving   = "receiving"
+	StagePublishing  = "publishing"
+	StageAssembled   = "assembled"
+	StageTranscoding = "transcoding"
+)
+
 type Notification struct {
-	UserID      string    `json:"user_id"`
-	EventType   string    `json:"event_type"` // "error", "success", etc.
-	VideoID     string    `json:"video_id,omitempty"`
-	ServiceName string    `json:"service_name"` // e.g., "transcoder", "storage"
-	Description string    `json:"description"`
-	Timestamp   time.Time `json:"timestamp"`
+	UserID    string `json:"user_id"`
+	EventType string `json:"event_type"` // "error", "success", etc.
+	VideoID   string `json:"video_id,omitempty"`
+	// UploadID is the resumable upload the video came from
+	UploadID    string `json:"upload_id,omitempty"`
+	ServiceName string `json:"service_name"` // e.g., "transcoder", "storage"
+	Description string `json:"description"`
+	// Stage and Progress, a percentage of the stage, are set on progress
+	// events. BytesDone and BytesTotal are set when the stage moves bytes.
+	Stage      string    `json:"stage,omitempty"`
+	Progress   int       `json:"progress"`
+	BytesDone  int64     `json:"bytes_done,omitempty"`
+	BytesTotal int64     `json:"bytes_total,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+var stageDescriptions = map[string]string{
+	StageReceiving:  "Receiving upload",
+	StagePublishing: "Queueing upload for processing",
+	StageAssembled:  "Upload stored, waiting for transcoding",
+}
+
+// NewProgress returns a progress event for a stage that has moved done of
+// total bytes
+func NewProgress(userID, videoID, stage, serviceName string, done, total int64) Notification {
+	percent := 100
+	if total > 0 {
+		percent = int(done * 100 / total)
+	}
+	return Notification{
+		UserID:      userID,
+		EventType:   EventProgress,
+		VideoID:     videoID,
+		ServiceName: serviceName,
+		Description: stageDescriptions[stage],
+		Stage:       stage,
+		Progress:    percent,
+		BytesDone:   done,
+		BytesTotal:  total,
+		Timestamp:   time.Now(),
+	}
+}
+
+// Concerns reports whether the notification is about the video or upload id
+func (n Notification) Concerns(id string) bool {
+	return id != "" && (n.VideoID == id || n.UploadID == id)
 }
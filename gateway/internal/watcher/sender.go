@@ -93,7 +93,7 @@ func (ns *NotificationSender) Close() error {
 func (ns *NotificationSender) SendSuccessNotification(userID, videoID, serviceName, description string) error {
 	return ns.SendNotification(Notification{
 		UserID:      userID,
-		EventType:   "success",
+		EventType:   EventSuccess,
 		VideoID:     videoID,
 		ServiceName: serviceName,
 		Description: description,
@@ -104,7 +104,7 @@ func (ns *NotificationSender) SendSuccessNotification(userID, videoID, serviceNa
 func (ns *NotificationSender) SendErrorNotification(userID, videoID, serviceName, description string) error {
 	return ns.SendNotification(Notification{
 		UserID:      userID,
-		EventType:   "error",
+		EventType:   EventError,
 		VideoID:     videoID,
 		ServiceName: serviceName,
 		Description: description,
@@ -115,7 +115,7 @@ func (ns *NotificationSender) SendErrorNotification(userID, videoID, serviceName
 func (ns *NotificationSender) SendProgressNotification(userID, videoID, serviceName, description string) error {
 	return ns.SendNotification(Notification{
 		UserID:      userID,
-		EventType:   "progress",
+		EventType:   EventProgress,
 		VideoID:     videoID,
 		ServiceName: serviceName,
 		Description: description,
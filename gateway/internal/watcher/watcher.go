@@ -104,6 +104,11 @@ func (w *Watcher) processMessage(msg amqp.Delivery) {
 		notification.Timestamp = time.Now()
 	}
 
+	// vcodec reports transcoding progress without a stage
+	if notification.EventType == EventProcessing && notification.Stage == "" {
+		notification.Stage = StageTranscoding
+	}
+
 	log.Printf("Received notification for user %s: %s from %s",
 		notification.UserID, notification.EventType, notification.ServiceName)
 
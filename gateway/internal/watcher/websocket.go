@@ -1,11 +1,13 @@
 package watcher
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
+	"github.com/lumbrjx/codek7/gateway/pkg/utils"
 )
 
 var upgrader = websocket.Upgrader{
@@ -16,10 +18,22 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// HandleWebSocket handles WebSocket connections
+// subscriptionRequest is what clients send to follow a single video or
+// upload: {"action": "subscribe", "id": "..."}
+type subscriptionRequest struct {
+	Action string `json:"action"`
+	ID     string `json:"id"`
+}
+
+// HandleWebSocket handles WebSocket connections. The video_id and
+// upload_id query parameters, which may repeat, subscribe the connection
+// from the start.
 func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from path
-	userID := chi.URLParam(r, "user_id")
+	// The authenticated user, or the user from the path
+	userID, _ := utils.GetUserID(r.Context())
+	if userID == "" {
+		userID = chi.URLParam(r, "user_id")
+	}
 	if userID == "" {
 		http.Error(w, "user_id query parameter is required", http.StatusBadRequest)
 		return
@@ -38,25 +52,41 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		Conn:   conn,
 		Send:   make(chan Notification, 256),
 	}
+	query := r.URL.Query()
+	for _, id := range append(query["video_id"], query["upload_id"]...) {
+		if id != "" {
+			client.Subscribe(id)
+		}
+	}
 
 	// Register client with hub
 	h.register <- client
 
-	// Handle ping/pong to keep connection alive
+	// Handle subscriptions and ping/pong to keep connection alive
 	go func() {
 		defer func() {
 			h.unregister <- client
 		}()
 
 		for {
-			// Read message (ping/pong or close)
-			_, _, err := conn.ReadMessage()
+			_, data, err := conn.ReadMessage()
 			if err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 					log.Printf("WebSocket error: %v", err)
 				}
 				break
 			}
+
+			var req subscriptionRequest
+			if err := json.Unmarshal(data, &req); err != nil || req.ID == "" {
+				continue
+			}
+			switch req.Action {
+			case "subscribe":
+				client.Subscribe(req.ID)
+			case "unsubscribe":
+				client.Unsubscribe(req.ID)
+			}
 		}
 	}()
 }
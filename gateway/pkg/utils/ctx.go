@@ -8,6 +8,8 @@ type contextKey string
 
 const (
 	userIDKey contextKey = "userID"
+	scopesKey contextKey = "scopes"
+	apiKeyKey contextKey = "apiKeyID"
 )
 
 // WithUserID adds a user ID to a context
@@ -20,3 +22,38 @@ func GetUserID(ctx context.Context) (string, bool) {
 	userID, ok := ctx.Value(userIDKey).(string)
 	return userID, ok
 }
+
+// WithAPIKey marks a request as authenticated by an API key
+func WithAPIKey(ctx context.Context, keyID string) context.Context {
+	return context.WithValue(ctx, apiKeyKey, keyID)
+}
+
+// GetAPIKeyID retrieves the ID of the API key a request was authenticated
+// with, ok is false for session requests
+func GetAPIKeyID(ctx context.Context) (string, bool) {
+	keyID, ok := ctx.Value(apiKeyKey).(string)
+	return keyID, ok
+}
+
+// WithScopes adds the scopes granted to a request to a context
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey, scopes)
+}
+
+// GetScopes retrieves the scopes granted to a request, those of the access
+// token or of the API key it was authenticated with
+func GetScopes(ctx context.Context) ([]string, bool) {
+	scopes, ok := ctx.Value(scopesKey).([]string)
+	return scopes, ok
+}
+
+// HasScope reports whether the request was granted scope
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, _ := GetScopes(ctx)
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
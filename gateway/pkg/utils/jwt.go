@@ -1,37 +1,229 @@
 package utils
 
-import "github.com/golang-jwt/jwt/v4"
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
-func GenToken(uid string) (string, error) {
-	tkn := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"uid": uid,
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	defaultAccessTokenTTL = 15 * time.Minute
+	tokenIssuer           = "codek7-gateway"
+)
+
+// Claims are the claims of an access token. SessionID ties the token to
+// the refresh token family it was issued from, Role and Scopes are those of
+// the user when the session started.
+type Claims struct {
+	UserID    string   `json:"uid"`
+	SessionID string   `json:"sid"`
+	Role      string   `json:"role"`
+	Scopes    []string `json:"scp"`
+	jwt.RegisteredClaims
+}
+
+// signingKey is one entry of the key ring, selected by the kid header.
+// Keys loaded from a public key PEM have no sign key and only verify
+// tokens signed before a rotation.
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+type keyRing struct {
+	active *signingKey
+	keys   map[string]*signingKey
+	order  []string // kids in configuration order, for the JWKS
+}
+
+var (
+	keyRingOnce sync.Once
+	jwtKeys     *keyRing
+)
+
+func (ring *keyRing) add(k *signingKey) {
+	if _, dup := ring.keys[k.id]; dup {
+		log.Fatalf("JWT key id %q is configured twice", k.id)
+	}
+	ring.keys[k.id] = k
+	ring.order = append(ring.order, k.id)
+}
+
+// parseKeyFile loads an RSA or Ed25519 key from a PEM file. Private keys
+// sign and verify, public keys only verify.
+func parseKeyFile(kid, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if priv, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return &signingKey{id: kid, method: jwt.SigningMethodRS256, signKey: priv, verifyKey: &priv.PublicKey}, nil
+	}
+	if priv, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+		edPriv, ok := priv.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", priv)
+		}
+		return &signingKey{id: kid, method: jwt.SigningMethodEdDSA, signKey: edPriv, verifyKey: edPriv.Public()}, nil
+	}
+	if pub, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return &signingKey{id: kid, method: jwt.SigningMethodRS256, verifyKey: pub}, nil
+	}
+	if pub, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return &signingKey{id: kid, method: jwt.SigningMethodEdDSA, verifyKey: pub}, nil
+	}
+	return nil, fmt.Errorf("no RSA or Ed25519 key found")
+}
+
+// loadKeyRing builds the key ring from
+//   - JWT_KEY_FILES, a comma separated list of kid:path pairs naming RSA
+//     (RS256) or Ed25519 (EdDSA) PEM files, private or public only
+//   - JWT_SIGNING_KEYS, a comma separated list of kid:secret HMAC keys,
+//     which other services cannot verify without the secret
+//
+// JWT_SIGNING_KID names the key new tokens are signed with, the first key
+// by default. Retired keys stay in the lists until the tokens they signed
+// have expired. Without keys a random HMAC key is used, so tokens do not
+// survive a restart.
+func loadKeyRing() *keyRing {
+	keyRingOnce.Do(func() {
+		ring := &keyRing{keys: map[string]*signingKey{}}
+
+		for _, entry := range strings.Split(os.Getenv("JWT_KEY_FILES"), ",") {
+			kid, path, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if !ok || kid == "" || path == "" {
+				continue
+			}
+			k, err := parseKeyFile(kid, path)
+			if err != nil {
+				log.Fatalf("Failed to load JWT key %q from %s: %v", kid, path, err)
+			}
+			ring.add(k)
+		}
+
+		for _, entry := range strings.Split(os.Getenv("JWT_SIGNING_KEYS"), ",") {
+			kid, secret, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if !ok || kid == "" || secret == "" {
+				continue
+			}
+			ring.add(&signingKey{id: kid, method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)})
+		}
+
+		if len(ring.order) == 0 {
+			log.Println("No JWT keys configured, using a random signing key")
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				panic(err)
+			}
+			ring.add(&signingKey{id: "ephemeral", method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret})
+		}
+
+		ring.active = ring.keys[ring.order[0]]
+		if kid := os.Getenv("JWT_SIGNING_KID"); kid != "" {
+			k, ok := ring.keys[kid]
+			if !ok {
+				log.Fatalf("JWT_SIGNING_KID %q is not a configured key", kid)
+			}
+			ring.active = k
+		}
+		if ring.active.signKey == nil {
+			log.Fatalf("JWT signing key %q has no private key", ring.active.id)
+		}
+		jwtKeys = ring
 	})
+	return jwtKeys
+}
+
+// AccessTokenTTL reads ACCESS_TOKEN_TTL, 15 minutes by default
+func AccessTokenTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return defaultAccessTokenTTL
+}
+
+// NewTokenID returns a random identifier for jti and session IDs
+func NewTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// GenToken signs a short-lived access token for uid within a session
+func GenToken(uid, sessionID, role string, scopes []string) (string, *Claims, error) {
+	ring := loadKeyRing()
+	now := time.Now()
+
+	claims := &Claims{
+		UserID:    uid,
+		SessionID: sessionID,
+		Role:      role,
+		Scopes:    scopes,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        NewTokenID(),
+			Issuer:    tokenIssuer,
+			Subject:   uid,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL())),
+		},
+	}
+
+	tkn := jwt.NewWithClaims(ring.active.method, claims)
+	tkn.Header["kid"] = ring.active.id
 
-	token, err := tkn.SignedString([]byte("secret"))
+	token, err := tkn.SignedString(ring.active.signKey)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return token, nil
+	return token, claims, nil
 }
 
+// ValidateToken verifies the signature with the key named by the kid header,
+// using the algorithm of that key, and checks expiry, issuer and the required claims
+func ValidateToken(tokenString string) (*Claims, error) {
+	ring := loadKeyRing()
 
-func ValidateToken(tokenString string) (string, error) {
-	tkn, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+	var claims Claims
+	tkn, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ring.keys[kid]
+		if !ok {
+			return nil, jwt.NewValidationError(fmt.Sprintf("unknown key id %q", kid), jwt.ValidationErrorUnverifiable)
+		}
+		// The algorithm is fixed by the key, never by the token header
+		if token.Method.Alg() != key.method.Alg() {
 			return nil, jwt.NewValidationError("unexpected signing method", jwt.ValidationErrorSignatureInvalid)
 		}
-		return []byte("secret"), nil
+		return key.verifyKey, nil
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	
-	if claims, ok := tkn.Claims.(jwt.MapClaims); ok && tkn.Valid {
-		if uid, ok := claims["uid"].(string); ok {
-			return uid, nil
-		}
-		return "", jwt.NewValidationError("invalid token claims", jwt.ValidationErrorClaimsInvalid)
+	if !tkn.Valid {
+		return nil, jwt.NewValidationError("invalid token", jwt.ValidationErrorMalformed)
+	}
+
+	if claims.UserID == "" || claims.ID == "" || claims.SessionID == "" || claims.ExpiresAt == nil {
+		return nil, jwt.NewValidationError("invalid token claims", jwt.ValidationErrorClaimsInvalid)
 	}
-	return "", jwt.NewValidationError("invalid token", jwt.ValidationErrorMalformed)
-}
\ No newline at end of file
+	if !claims.VerifyIssuer(tokenIssuer, true) {
+		return nil, jwt.NewValidationError("invalid token issuer", jwt.ValidationErrorIssuer)
+	}
+
+	return &claims, nil
+}
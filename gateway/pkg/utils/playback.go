@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidPlaybackToken = errors.New("invalid playback token")
+
+// PlaybackClaims scope a playback token to one video
+type PlaybackClaims struct {
+	VideoID   string `json:"vid"`
+	IssuedAt  int64  `json:"iat"` // unix nanoseconds, compared against revocations
+	ExpiresAt int64  `json:"exp"` // unix seconds
+}
+
+// placeholderPlaybackSecret is the value the example env files used to ship
+const placeholderPlaybackSecret = "change-me"
+
+var (
+	playbackSecretOnce sync.Once
+	playbackSecretKey  []byte
+)
+
+// CheckPlaybackSecret fails when PLAYBACK_SECRET is unset or the
+// placeholder, which would let anyone sign playback tokens
+func CheckPlaybackSecret() error {
+	switch os.Getenv("PLAYBACK_SECRET") {
+	case "":
+		return errors.New("PLAYBACK_SECRET is not set")
+	case placeholderPlaybackSecret:
+		return errors.New("PLAYBACK_SECRET is still the placeholder, set a random secret")
+	}
+	return nil
+}
+
+// playbackSecret reads PLAYBACK_SECRET, see CheckPlaybackSecret
+func playbackSecret() []byte {
+	playbackSecretOnce.Do(func() {
+		playbackSecretKey = []byte(os.Getenv("PLAYBACK_SECRET"))
+	})
+	return playbackSecretKey
+}
+
+func signPlayback(payload string) string {
+	mac := hmac.New(sha256.New, playbackSecret())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// GenPlaybackToken issues an HMAC-signed token for videoID valid for ttl
+func GenPlaybackToken(videoID string, ttl time.Duration) (string, *PlaybackClaims, error) {
+	now := time.Now()
+	claims := &PlaybackClaims{
+		VideoID:   videoID,
+		IssuedAt:  now.UnixNano(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + signPlayback(payload), claims, nil
+}
+
+// ValidatePlaybackToken checks the signature and expiry of a playback token.
+// Revocation is checked by the caller.
+func ValidatePlaybackToken(token string) (*PlaybackClaims, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signPlayback(payload))) {
+		return nil, ErrInvalidPlaybackToken
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidPlaybackToken
+	}
+	var claims PlaybackClaims
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.VideoID == "" {
+		return nil, ErrInvalidPlaybackToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidPlaybackToken
+	}
+	return &claims, nil
+}
@@ -0,0 +1,12 @@
+package utils
+
+// Scopes checked by the gateway, granted by the repo service through user
+// roles and API keys
+const (
+	ScopeVideosRead   = "videos:read"
+	ScopeVideosUpload = "videos:upload"
+	ScopeVideosUpdate = "videos:update"
+	ScopeVideosDelete = "videos:delete"
+	ScopeAdminUsers   = "admin:users"
+	ScopeAdminStorage = "admin:storage"
+)
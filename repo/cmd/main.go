@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"codek7/common/pb"
 
 	"github.com/joho/godotenv"
 	"github.com/lumbrjx/codek7/repo/internal/handler"
+	"github.com/lumbrjx/codek7/repo/internal/model"
 	"github.com/lumbrjx/codek7/repo/internal/repository"
 	"github.com/lumbrjx/codek7/repo/internal/service"
 	"github.com/lumbrjx/codek7/repo/internal/storage"
 	"github.com/lumbrjx/codek7/repo/pkg/logger"
 
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 )
@@ -25,6 +32,26 @@ var (
 	minioUseSSL bool
 
 	postgresDSN string
+
+	orphanGCInterval time.Duration
+	orphanGCMinAge   = 24 * time.Hour
+	orphanGCDryRun   bool
+
+	trashRetention = 7 * 24 * time.Hour
+	purgeInterval  = time.Hour
+
+	adminUsernames []string
+
+	bcryptCost = bcrypt.DefaultCost
+
+	totpEncryptionKey []byte
+	totpIssuer        = "codek7"
+
+	quotaDefaults = model.QuotaLimits{
+		MaxBytes:     10 << 30,
+		MaxVideos:    100,
+		MaxFileBytes: 4 << 30,
+	}
 )
 
 func init() {
@@ -54,11 +81,116 @@ func init() {
 		os.Exit(1)
 	}
 
+	// Orphan collection runs only when an interval is configured and stays
+	// in report-only mode unless ORPHAN_GC_DRY_RUN is explicitly "false"
+	if v := os.Getenv("ORPHAN_GC_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			logger.Logger.Error("Invalid ORPHAN_GC_INTERVAL", "value", v, "error", err.Error())
+			os.Exit(1)
+		}
+		orphanGCInterval = d
+	}
+	if v := os.Getenv("ORPHAN_GC_MIN_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			logger.Logger.Error("Invalid ORPHAN_GC_MIN_AGE", "value", v, "error", err.Error())
+			os.Exit(1)
+		}
+		orphanGCMinAge = d
+	}
+	orphanGCDryRun = os.Getenv("ORPHAN_GC_DRY_RUN") != "false"
+
+	// Removed videos stay restorable for VIDEO_TRASH_RETENTION, the purger
+	// checks for expired ones every VIDEO_PURGE_INTERVAL
+	if v := os.Getenv("VIDEO_TRASH_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			logger.Logger.Error("Invalid VIDEO_TRASH_RETENTION", "value", v)
+			os.Exit(1)
+		}
+		trashRetention = d
+	}
+	if v := os.Getenv("VIDEO_PURGE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			logger.Logger.Error("Invalid VIDEO_PURGE_INTERVAL", "value", v)
+			os.Exit(1)
+		}
+		purgeInterval = d
+	}
+
+	// Users named in ADMIN_USERNAMES are promoted to admin on startup
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERNAMES"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			adminUsernames = append(adminUsernames, name)
+		}
+	}
+
+	// Password hashes stored at another cost are rehashed at the next login
+	if v := os.Getenv("BCRYPT_COST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+			logger.Logger.Error("Invalid BCRYPT_COST", "value", v)
+			os.Exit(1)
+		}
+		bcryptCost = n
+	}
+
+	// TOTP secrets are encrypted with TOTP_ENCRYPTION_KEY, 32 bytes in
+	// base64. Without it two-factor enrollment is unavailable.
+	if v := os.Getenv("TOTP_ENCRYPTION_KEY"); v != "" {
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(key) != 32 {
+			logger.Logger.Error("Invalid TOTP_ENCRYPTION_KEY, expected 32 bytes in base64")
+			os.Exit(1)
+		}
+		totpEncryptionKey = key
+	} else {
+		logger.Logger.Warn("TOTP_ENCRYPTION_KEY is not set, two-factor authentication is disabled")
+	}
+	if v := os.Getenv("TOTP_ISSUER"); v != "" {
+		totpIssuer = v
+	}
+
+	// Default storage limits of users without their own, 0 is unlimited
+	for name, limit := range map[string]*int64{
+		"QUOTA_MAX_BYTES":      &quotaDefaults.MaxBytes,
+		"QUOTA_MAX_FILE_BYTES": &quotaDefaults.MaxFileBytes,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				logger.Logger.Error("Invalid "+name, "value", v)
+				os.Exit(1)
+			}
+			*limit = n
+		}
+	}
+	if v := os.Getenv("QUOTA_MAX_VIDEOS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			logger.Logger.Error("Invalid QUOTA_MAX_VIDEOS", "value", v)
+			os.Exit(1)
+		}
+		quotaDefaults.MaxVideos = n
+	}
+
 	logger.Logger.Info("Environment configuration loaded successfully",
 		"minio_endpoint", minioEndpoint,
 		"minio_bucket", minioBucket,
 		"minio_use_ssl", minioUseSSL,
 		"postgres_dsn_set", postgresDSN != "",
+		"orphan_gc_interval", orphanGCInterval.String(),
+		"orphan_gc_dry_run", orphanGCDryRun,
+		"trash_retention", trashRetention.String(),
+		"purge_interval", purgeInterval.String(),
+		"admin_usernames", adminUsernames,
+		"bcrypt_cost", bcryptCost,
+		"totp_enabled", totpEncryptionKey != nil,
+		"quota_max_bytes", quotaDefaults.MaxBytes,
+		"quota_max_videos", quotaDefaults.MaxVideos,
+		"quota_max_file_bytes", quotaDefaults.MaxFileBytes,
 	)
 }
 
@@ -93,15 +225,54 @@ func main() {
 	logger.Logger.Info("Initializing repositories")
 	vr := repository.NewVideoRepository(conn)
 	ur := repository.NewUserRepository(conn)
+	ar := repository.NewAssetRepository(conn)
+	kr := repository.NewAPIKeyRepository(conn)
+	tr := repository.NewUserTokenRepository(conn)
+	ir := repository.NewIdentityRepository(conn)
+	otr := repository.NewTotpRepository(conn)
+	qr := repository.NewQuotaRepository(conn)
 
 	// === Services ===
 	logger.Logger.Info("Initializing services")
-	videoService := service.NewVideoService(vr, minioClient)
-	userService := service.NewUserService(ur)
+	quotaService := service.NewQuotaService(qr, quotaDefaults)
+	videoService := service.NewVideoService(vr, ar, minioClient, quotaService)
+	userService := service.NewUserService(ur, tr, bcryptCost)
+	apiKeyService := service.NewAPIKeyService(kr, ur)
+	identityService := service.NewIdentityService(ir, ur)
+	totpService, err := service.NewTotpService(otr, ur, totpEncryptionKey, totpIssuer)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize TOTP service",
+			"error", err.Error(),
+		)
+		os.Exit(1)
+	}
+
+	if err := userService.PromoteAdmins(context.Background(), adminUsernames); err != nil {
+		logger.Logger.Error("Failed to promote admin users",
+			"error", err.Error(),
+		)
+		os.Exit(1)
+	}
+	orphanCollector := service.NewOrphanCollector(vr, minioClient, orphanGCMinAge)
+
+	if orphanGCInterval > 0 {
+		logger.Logger.Info("Starting orphan object collector",
+			"interval", orphanGCInterval.String(),
+			"dry_run", orphanGCDryRun,
+		)
+		go orphanCollector.Run(context.Background(), orphanGCInterval, orphanGCDryRun)
+	}
+
+	videoPurger := service.NewVideoPurger(vr, ar, minioClient, trashRetention)
+	logger.Logger.Info("Starting trash purger",
+		"interval", purgeInterval.String(),
+		"retention", trashRetention.String(),
+	)
+	go videoPurger.Run(context.Background(), purgeInterval)
 
 	// === Handler ===
 	logger.Logger.Info("Initializing gRPC handler")
-	repoHandler := handler.NewRepoHandler(userService, videoService)
+	repoHandler := handler.NewRepoHandler(userService, videoService, apiKeyService, identityService, totpService, quotaService, orphanCollector, videoPurger)
 
 	// === gRPC Server ===
 	logger.Logger.Info("Initializing gRPC server")
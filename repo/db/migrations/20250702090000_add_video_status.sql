@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE videos
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'uploading'
+        CHECK (status IN ('uploading', 'transcoding', 'ready', 'failed', 'rejected')),
+    ADD COLUMN status_reason TEXT NOT NULL DEFAULT '',
+    ADD COLUMN status_updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Videos uploaded before the status lifecycle existed are already served
+UPDATE videos SET status = 'ready';
+
+CREATE TABLE video_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    video_id UUID NOT NULL,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    FOREIGN KEY (video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_video_status_history_video_id ON video_status_history (video_id, changed_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE video_status_history;
+
+ALTER TABLE videos
+    DROP COLUMN status_updated_at,
+    DROP COLUMN status_reason,
+    DROP COLUMN status;
+-- +goose StatementEnd
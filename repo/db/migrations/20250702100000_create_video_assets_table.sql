@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE video_assets (
+    id UUID PRIMARY KEY,
+    video_id UUID NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('original', 'rendition', 'master', 'playlist', 'segment')),
+    rendition TEXT NOT NULL DEFAULT '',
+    codec TEXT NOT NULL DEFAULT '',
+    bitrate BIGINT NOT NULL DEFAULT 0,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    byte_size BIGINT NOT NULL DEFAULT 0,
+    object_key TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    FOREIGN KEY (video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_video_assets_video_id ON video_assets (video_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE video_assets;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE videos
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'B')
+    ) STORED;
+
+CREATE INDEX idx_videos_search_vector ON videos USING GIN (search_vector);
+CREATE INDEX idx_videos_user_created ON videos (user_id, created_at DESC, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_videos_user_created;
+DROP INDEX IF EXISTS idx_videos_search_vector;
+ALTER TABLE videos DROP COLUMN search_vector;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE videos
+    ADD COLUMN visibility TEXT NOT NULL DEFAULT 'owner'
+        CHECK (visibility IN ('owner', 'shared', 'public'));
+
+CREATE TABLE video_shares (
+    video_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (video_id, user_id),
+    FOREIGN KEY (video_id) REFERENCES videos(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_video_shares_user_id ON video_shares (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE video_shares;
+ALTER TABLE videos DROP COLUMN visibility;
+-- +goose StatementEnd
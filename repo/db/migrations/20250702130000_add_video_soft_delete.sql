@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE videos
+    ADD COLUMN deleted_at TIMESTAMPTZ,
+    ADD COLUMN purge_attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN purge_error TEXT NOT NULL DEFAULT '',
+    ADD COLUMN purge_after TIMESTAMPTZ;
+
+CREATE INDEX idx_videos_deleted_at ON videos (deleted_at) WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_videos_deleted_at;
+ALTER TABLE videos
+    DROP COLUMN purge_after,
+    DROP COLUMN purge_error,
+    DROP COLUMN purge_attempts,
+    DROP COLUMN deleted_at;
+-- +goose StatementEnd
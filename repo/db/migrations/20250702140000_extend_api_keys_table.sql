@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Keys are stored as SHA-256 hashes. No code ever wrote this table, any
+-- existing row holds a raw token that can no longer authenticate.
+ALTER TABLE api_keys RENAME COLUMN token TO token_hash;
+
+ALTER TABLE api_keys
+    ADD COLUMN name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN prefix TEXT NOT NULL DEFAULT '',
+    ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN expires_at TIMESTAMPTZ,
+    ADD COLUMN last_used_at TIMESTAMPTZ,
+    ADD COLUMN revoked_at TIMESTAMPTZ,
+    ADD CONSTRAINT api_keys_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX idx_api_keys_token_hash ON api_keys (token_hash);
+CREATE INDEX idx_api_keys_user_id ON api_keys (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_api_keys_user_id;
+DROP INDEX idx_api_keys_token_hash;
+
+ALTER TABLE api_keys
+    DROP CONSTRAINT api_keys_user_id_fkey,
+    DROP COLUMN revoked_at,
+    DROP COLUMN last_used_at,
+    DROP COLUMN expires_at,
+    DROP COLUMN scopes,
+    DROP COLUMN prefix,
+    DROP COLUMN name;
+
+ALTER TABLE api_keys RENAME COLUMN token_hash TO token;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Existing accounts keep uploading, admins are promoted through ADMIN_USERNAMES.
+-- scopes holds grants on top of the ones the role implies.
+ALTER TABLE users
+    ADD COLUMN role TEXT NOT NULL DEFAULT 'uploader',
+    ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{}',
+    ADD CONSTRAINT users_role_check CHECK (role IN ('admin', 'uploader', 'viewer'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+    DROP CONSTRAINT users_role_check,
+    DROP COLUMN scopes,
+    DROP COLUMN role;
+-- +goose StatementEnd
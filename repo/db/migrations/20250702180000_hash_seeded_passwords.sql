@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The seed users were inserted with plaintext passwords, which can never
+-- match a bcrypt comparison. Hash anything that is not already a bcrypt hash.
+UPDATE users
+SET password = crypt(password, gen_salt('bf', 10))
+WHERE password NOT LIKE '$2_$%';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Hashing is one way, the plaintext passwords are not restored
+SELECT 1;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- What the gateway found out about the original file. container is set for
+-- every validated upload, the rest only when the upload was probed.
+-- duration is in seconds.
+ALTER TABLE videos
+    ADD COLUMN container TEXT NOT NULL DEFAULT '',
+    ADD COLUMN duration DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN video_codec TEXT NOT NULL DEFAULT '',
+    ADD COLUMN audio_codec TEXT NOT NULL DEFAULT '',
+    ADD COLUMN width INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN height INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN frame_rate DOUBLE PRECISION NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE videos
+    DROP COLUMN container,
+    DROP COLUMN duration,
+    DROP COLUMN video_codec,
+    DROP COLUMN audio_codec,
+    DROP COLUMN width,
+    DROP COLUMN height,
+    DROP COLUMN frame_rate;
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+-- file_size is the size of the stored original, 0 until it is stored
+ALTER TABLE videos ADD COLUMN file_size BIGINT NOT NULL DEFAULT 0;
+
+UPDATE videos v SET file_size = a.byte_size
+FROM video_assets a
+WHERE a.video_id = v.id AND a.kind = 'original';
+
+-- Storage used by each user and the limits set for them. A NULL limit is
+-- the service default, 0 is unlimited. The usage counts the originals of
+-- videos outside the trash and is kept up to date by the statements that
+-- store, trash, restore and delete videos.
+CREATE TABLE user_quotas (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    max_bytes BIGINT,
+    max_videos INTEGER,
+    max_file_bytes BIGINT,
+    bytes_used BIGINT NOT NULL DEFAULT 0,
+    video_count INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO user_quotas (user_id, bytes_used, video_count)
+SELECT user_id, SUM(file_size), COUNT(*)
+FROM videos
+WHERE deleted_at IS NULL AND file_size > 0
+GROUP BY user_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE user_quotas;
+ALTER TABLE videos DROP COLUMN file_size;
+-- +goose StatementEnd
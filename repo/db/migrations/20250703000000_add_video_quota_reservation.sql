@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- reserved_bytes is set while an upload holds a reservation in its owner's
+-- usage: the video and the size the upload declared. Storing the original
+-- replaces the reservation with file_size, a failed upload releases it.
+ALTER TABLE videos ADD COLUMN reserved_bytes BIGINT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE videos DROP COLUMN reserved_bytes;
+-- +goose StatementEnd
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"codek7/common/pb"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/service"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	uuid "github.com/satori/go.uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userTokenResponse converts an issued token to its gRPC representation
+func userTokenResponse(t *service.IssuedToken) *pb.UserTokenResponse {
+	return &pb.UserTokenResponse{
+		Token:     t.Token,
+		UserId:    t.User.ID,
+		Username:  t.User.Username,
+		Email:     t.User.Email,
+		ExpiresAt: t.ExpiresAt.Format(time.RFC3339),
+	}
+}
+
+// accountError maps verification and reset failures to gRPC status errors
+func accountError(err error, op string) error {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, model.ErrUserTokenInvalid):
+		return status.Error(codes.InvalidArgument, "token invalid, used or expired")
+	case errors.Is(err, service.ErrEmailAlreadyVerified):
+		return status.Error(codes.FailedPrecondition, "email already verified")
+	case errors.Is(err, service.ErrEmptyPassword), errors.Is(err, service.ErrPasswordTooLong):
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return status.Errorf(codes.Internal, "%s failed: %v", op, err)
+}
+
+func (h *RepoHandler) RequestEmailVerification(ctx context.Context, req *pb.EmailVerificationRequest) (*pb.UserTokenResponse, error) {
+	start := time.Now()
+
+	if _, err := uuid.FromString(req.UserId); err != nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	issued, err := h.userService.RequestEmailVerification(ctx, req.UserId)
+
+	logger.LogGRPCRequest(ctx, "RequestEmailVerification", time.Since(start), err)
+
+	if err != nil {
+		return nil, accountError(err, "request email verification")
+	}
+	return userTokenResponse(issued), nil
+}
+
+func (h *RepoHandler) ConfirmEmail(ctx context.Context, req *pb.ConfirmUserTokenRequest) (*pb.UserResponse, error) {
+	start := time.Now()
+
+	user, err := h.userService.ConfirmEmail(ctx, req.Token)
+
+	logger.LogGRPCRequest(ctx, "ConfirmEmail", time.Since(start), err)
+
+	if err != nil {
+		return nil, accountError(err, "confirm email")
+	}
+
+	logger.Logger.Info("Email verified",
+		"user_id", user.ID,
+	)
+
+	return userResponse(user), nil
+}
+
+func (h *RepoHandler) RequestPasswordReset(ctx context.Context, req *pb.PasswordResetRequest) (*pb.UserTokenResponse, error) {
+	start := time.Now()
+
+	issued, err := h.userService.RequestPasswordReset(ctx, req.Email)
+
+	logger.LogGRPCRequest(ctx, "RequestPasswordReset", time.Since(start), err)
+
+	if err != nil {
+		return nil, accountError(err, "request password reset")
+	}
+	return userTokenResponse(issued), nil
+}
+
+func (h *RepoHandler) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.UserResponse, error) {
+	start := time.Now()
+
+	user, err := h.userService.ResetPassword(ctx, req.Token, req.Password)
+
+	logger.LogGRPCRequest(ctx, "ResetPassword", time.Since(start), err)
+
+	if err != nil {
+		return nil, accountError(err, "reset password")
+	}
+
+	logger.Logger.Info("Password reset",
+		"user_id", user.ID,
+	)
+
+	return userResponse(user), nil
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"codek7/common/pb"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/service"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	uuid "github.com/satori/go.uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+func (h *RepoHandler) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.UserListResponse, error) {
+	start := time.Now()
+
+	if _, err := h.requireScope(ctx, model.ScopeAdminUsers); err != nil {
+		return nil, err
+	}
+
+	limit := int(req.Limit)
+	if limit <= 0 {
+		limit = defaultUserPageSize
+	}
+	limit = min(limit, maxUserPageSize)
+	if req.Offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset must not be negative")
+	}
+
+	users, err := h.userService.ListUsers(ctx, limit, int(req.Offset))
+
+	logger.LogGRPCRequest(ctx, "ListUsers", time.Since(start), err)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "list users failed: %v", err)
+	}
+
+	resp := &pb.UserListResponse{Users: make([]*pb.UserResponse, 0, len(users))}
+	for _, u := range users {
+		resp.Users = append(resp.Users, userResponse(u))
+	}
+	return resp, nil
+}
+
+func (h *RepoHandler) SetUserRole(ctx context.Context, req *pb.SetUserRoleRequest) (*pb.UserResponse, error) {
+	start := time.Now()
+
+	caller, err := h.requireScope(ctx, model.ScopeAdminUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Info("Setting user role",
+		"user_id", req.UserId,
+		"role", req.Role,
+		"caller_id", caller,
+	)
+
+	// An admin demoting themselves could leave nobody able to undo it
+	if req.UserId == caller {
+		return nil, status.Error(codes.FailedPrecondition, "admins cannot change their own role")
+	}
+
+	if _, err := uuid.FromString(req.UserId); err != nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	user, err := h.userService.SetUserRole(ctx, req.UserId, model.UserRole(req.Role), req.Scopes)
+
+	logger.LogGRPCRequest(ctx, "SetUserRole", time.Since(start), err)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRole), errors.Is(err, service.ErrInvalidScopes):
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Errorf(codes.Internal, "set user role failed: %v", err)
+	}
+
+	return userResponse(user), nil
+}
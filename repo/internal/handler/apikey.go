@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"codek7/common/pb"
+
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/service"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// formatOptionalTime renders a nullable timestamp, empty when unset
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// apiKeyResponse converts an API key model to its gRPC representation. The
+// hash never leaves the repo service.
+func apiKeyResponse(k *model.APIKey) *pb.ApiKey {
+	return &pb.ApiKey{
+		Id:         k.ID,
+		UserId:     k.UserID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		ExpiresAt:  formatOptionalTime(k.ExpiresAt),
+		LastUsedAt: formatOptionalTime(k.LastUsedAt),
+		RevokedAt:  formatOptionalTime(k.RevokedAt),
+		CreatedAt:  k.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func (h *RepoHandler) CreateApiKey(ctx context.Context, req *pb.CreateApiKeyRequest) (*pb.CreateApiKeyResponse, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Info("Creating API key",
+		"user_id", caller,
+		"name", req.Name,
+	)
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid expires_at: %v", err)
+		}
+		expiresAt = &t
+	}
+
+	key, secret, err := h.apiKeyService.CreateAPIKey(ctx, caller, req.Name, req.Scopes, expiresAt)
+
+	logger.LogGRPCRequest(ctx, "CreateApiKey", time.Since(start), err)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidAPIKeyName),
+			errors.Is(err, service.ErrInvalidAPIKeyScopes),
+			errors.Is(err, service.ErrInvalidAPIKeyExpiry):
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "create api key failed: %v", err)
+	}
+
+	return &pb.CreateApiKeyResponse{Key: apiKeyResponse(key), Secret: secret}, nil
+}
+
+func (h *RepoHandler) ListApiKeys(ctx context.Context, _ *emptypb.Empty) (*pb.ApiKeyListResponse, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := h.apiKeyService.ListAPIKeys(ctx, caller)
+
+	logger.LogGRPCRequest(ctx, "ListApiKeys", time.Since(start), err)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "list api keys failed: %v", err)
+	}
+
+	resp := &pb.ApiKeyListResponse{Keys: make([]*pb.ApiKey, 0, len(keys))}
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, apiKeyResponse(k))
+	}
+	return resp, nil
+}
+
+func (h *RepoHandler) RevokeApiKey(ctx context.Context, req *pb.RevokeApiKeyRequest) (*emptypb.Empty, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Info("Revoking API key",
+		"user_id", caller,
+		"key_id", req.Id,
+	)
+
+	err = h.apiKeyService.RevokeAPIKey(ctx, caller, req.Id)
+
+	logger.LogGRPCRequest(ctx, "RevokeApiKey", time.Since(start), err)
+
+	if err != nil {
+		if errors.Is(err, model.ErrAPIKeyNotFound) {
+			return nil, status.Error(codes.NotFound, "api key not found")
+		}
+		return nil, status.Errorf(codes.Internal, "revoke api key failed: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (h *RepoHandler) AuthenticateApiKey(ctx context.Context, req *pb.AuthenticateApiKeyRequest) (*pb.ApiKey, error) {
+	start := time.Now()
+
+	key, err := h.apiKeyService.AuthenticateAPIKey(ctx, req.Secret)
+
+	logger.LogGRPCRequest(ctx, "AuthenticateApiKey", time.Since(start), err)
+
+	if err != nil {
+		if errors.Is(err, model.ErrAPIKeyInvalid) {
+			return nil, status.Error(codes.Unauthenticated, "invalid api key")
+		}
+		return nil, status.Errorf(codes.Internal, "authenticate api key failed: %v", err)
+	}
+
+	return apiKeyResponse(key), nil
+}
@@ -1,15 +1,18 @@
 package handler
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"io"
 	"strings"
 	"time"
 
 	"codek7/common/pb"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
 	"github.com/lumbrjx/codek7/repo/internal/service"
+	"github.com/lumbrjx/codek7/repo/internal/storage"
 	"github.com/lumbrjx/codek7/repo/pkg/logger"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -18,14 +21,119 @@ import (
 
 type RepoHandler struct {
 	pb.UnimplementedRepoServiceServer
-	userService  service.UserService
-	videoService service.VideoService
+	userService     service.UserService
+	videoService    service.VideoService
+	apiKeyService   service.APIKeyService
+	identityService service.IdentityService
+	totpService     service.TotpService
+	quotaService    service.QuotaService
+	orphanCollector *service.OrphanCollector
+	videoPurger     *service.VideoPurger
 }
 
-func NewRepoHandler(userSvc service.UserService, videoSvc service.VideoService) *RepoHandler {
+func NewRepoHandler(userSvc service.UserService, videoSvc service.VideoService, apiKeySvc service.APIKeyService, identitySvc service.IdentityService, totpSvc service.TotpService, quotaSvc service.QuotaService, collector *service.OrphanCollector, purger *service.VideoPurger) *RepoHandler {
 	return &RepoHandler{
-		userService:  userSvc,
-		videoService: videoSvc,
+		userService:     userSvc,
+		videoService:    videoSvc,
+		apiKeyService:   apiKeySvc,
+		identityService: identitySvc,
+		totpService:     totpSvc,
+		quotaService:    quotaSvc,
+		orphanCollector: collector,
+		videoPurger:     purger,
+	}
+}
+
+// userResponse converts a user model to its gRPC representation, without
+// the password hash
+func userResponse(u *model.User) *pb.UserResponse {
+	return &pb.UserResponse{
+		Id:        u.ID,
+		Username:  u.Username,
+		CreatedAt: u.CreatedAt.Format(time.RFC3339),
+		Role:      string(u.Role),
+		Scopes:    u.EffectiveScopes(),
+
+		EmailVerified: u.EmailVerified(),
+		Email:         u.Email,
+		DisplayName:   u.DisplayName,
+		AvatarUrl:     u.AvatarURL,
+		TotpEnabled:   u.TotpEnabled,
+	}
+}
+
+// videoAssetResponse converts an asset model to its gRPC representation
+func videoAssetResponse(a *model.VideoAsset) *pb.VideoAsset {
+	return &pb.VideoAsset{
+		Id:        a.ID,
+		VideoId:   a.VideoID,
+		Kind:      string(a.Kind),
+		Rendition: a.Rendition,
+		Codec:     a.Codec,
+		Bitrate:   a.Bitrate,
+		Width:     a.Width,
+		Height:    a.Height,
+		ByteSize:  a.ByteSize,
+		ObjectKey: a.ObjectKey,
+		CreatedAt: a.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func videoStatusChangeResponse(c *model.VideoStatusChange) *pb.VideoStatusChange {
+	return &pb.VideoStatusChange{
+		FromStatus: string(c.FromStatus),
+		ToStatus:   string(c.ToStatus),
+		Reason:     c.Reason,
+		ChangedAt:  c.ChangedAt.Format(time.RFC3339),
+	}
+}
+
+// videoMetadataResponse converts a video model to its gRPC representation
+func videoMetadataResponse(v *model.Video) *pb.VideoMetadataResponse {
+	resp := &pb.VideoMetadataResponse{
+		Id:              v.ID,
+		UserId:          v.UserID,
+		Title:           v.Title,
+		Description:     v.Description,
+		FileName:        v.FileName,
+		CreatedAt:       v.CreatedAt.Format(time.RFC3339),
+		Status:          string(v.Status),
+		StatusReason:    v.StatusReason,
+		StatusUpdatedAt: v.StatusUpdatedAt.Format(time.RFC3339),
+		Visibility:      string(v.Visibility),
+		FileSize:        v.FileSize,
+	}
+	if v.DeletedAt != nil {
+		resp.DeletedAt = v.DeletedAt.Format(time.RFC3339)
+	}
+	if v.Media != (model.MediaInfo{}) {
+		resp.Media = &pb.MediaInfo{
+			Container:  v.Media.Container,
+			Duration:   v.Media.Duration,
+			VideoCodec: v.Media.VideoCodec,
+			AudioCodec: v.Media.AudioCodec,
+			Width:      int32(v.Media.Width),
+			Height:     int32(v.Media.Height),
+			FrameRate:  v.Media.FrameRate,
+		}
+	}
+	return resp
+}
+
+// mediaInfo converts the media info of an upload to the model, missing
+// info is the zero value
+func mediaInfo(m *pb.MediaInfo) model.MediaInfo {
+	if m == nil {
+		return model.MediaInfo{}
+	}
+	return model.MediaInfo{
+		Container:  m.Container,
+		Duration:   m.Duration,
+		VideoCodec: m.VideoCodec,
+		AudioCodec: m.AudioCodec,
+		Width:      int(m.Width),
+		Height:     int(m.Height),
+		FrameRate:  m.FrameRate,
 	}
 }
 
@@ -47,6 +155,9 @@ func (h *RepoHandler) CreateUser(ctx context.Context, req *pb.CreateUserRequest)
 			"email", req.Email,
 			"error", err.Error(),
 		)
+		if errors.Is(err, service.ErrEmptyPassword) || errors.Is(err, service.ErrPasswordTooLong) {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
 		return nil, status.Errorf(codes.Internal, "failed to create user: %v", err)
 	}
 
@@ -55,11 +166,7 @@ func (h *RepoHandler) CreateUser(ctx context.Context, req *pb.CreateUserRequest)
 		"username", user.Username,
 	)
 
-	return &pb.UserResponse{
-		Id:        user.ID,
-		Username:  user.Username,
-		CreatedAt: user.CreatedAt.Format(time.RFC3339),
-	}, nil
+	return userResponse(user), nil
 }
 
 func (h *RepoHandler) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.UserResponse, error) {
@@ -86,63 +193,90 @@ func (h *RepoHandler) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.
 		"username", user.Username,
 	)
 
-	return &pb.UserResponse{
-		Id:        user.ID,
-		Username:  user.Username,
-		Password:  user.Password,
-		CreatedAt: user.CreatedAt.Format(time.RFC3339),
-	}, nil
+	return userResponse(user), nil
 }
 
-// UploadVideo handles both original videos and generated files
-func (h *RepoHandler) UploadVideo(stream pb.RepoService_UploadVideoServer) error {
-	start := time.Now()
-	var metadata *pb.VideoMetadata
-	buf := new(bytes.Buffer)
-
-	logger.Logger.Info("Starting video upload stream")
+// videoChunkReader exposes the chunk messages of an upload stream as an io.Reader,
+// so at most one chunk is held in memory at a time
+type videoChunkReader struct {
+	stream pb.RepoService_UploadVideoServer
+	buf    []byte
+	n      int64
+}
 
-	// Collect all chunks
-	for {
-		req, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
+func (r *videoChunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		req, err := r.stream.Recv()
 		if err != nil {
-			logger.Logger.Error("Failed to receive chunk",
-				"error", err.Error(),
-			)
-			return status.Errorf(codes.Unknown, "failed to receive chunk: %v", err)
+			return 0, err
 		}
-
-		switch data := req.Data.(type) {
-		case *pb.UploadVideoRequest_Metadata:
-			metadata = data.Metadata
-			logger.Logger.Info("Received video metadata",
-				"user_id", metadata.UserId,
-				"title", metadata.Title,
-				"filename", metadata.FileName,
-			)
-		case *pb.UploadVideoRequest_Chunk:
-			buf.Write(data.Chunk.Data)
+		chunk := req.GetChunk()
+		if chunk == nil {
+			return 0, status.Error(codes.InvalidArgument, "metadata must only be sent as the first message")
 		}
+		r.buf = chunk.Data
 	}
 
-	if metadata == nil {
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.n += int64(n)
+	return n, nil
+}
+
+// UploadVideo handles both original videos and generated files.
+// The first message must carry the metadata; the chunks that follow are
+// streamed straight to storage without being buffered.
+func (h *RepoHandler) UploadVideo(stream pb.RepoService_UploadVideoServer) error {
+	start := time.Now()
+
+	logger.Logger.Info("Starting video upload stream")
+
+	first, err := stream.Recv()
+	if err == io.EOF {
 		logger.Logger.Error("Missing video metadata")
 		return status.Error(codes.InvalidArgument, "missing video metadata")
 	}
+	if err != nil {
+		logger.Logger.Error("Failed to receive metadata",
+			"error", err.Error(),
+		)
+		return status.Errorf(codes.Unknown, "failed to receive metadata: %v", err)
+	}
 
-	fileSize := int64(buf.Len())
-	logger.Logger.Info("Video upload chunks collected",
+	metadata := first.GetMetadata()
+	if metadata == nil {
+		logger.Logger.Error("First upload message is not metadata")
+		return status.Error(codes.InvalidArgument, "first message must be video metadata")
+	}
+
+	logger.Logger.Info("Received video metadata",
+		"user_id", metadata.UserId,
+		"title", metadata.Title,
 		"filename", metadata.FileName,
-		"file_size_bytes", fileSize,
 	)
 
-	// Determine if this is an original video or generated file
-	isOriginalVideo := h.isOriginalVideo(metadata.FileName)
+	// Uploads are made on behalf of the owner, who must be the caller
+	caller, err := callerID(stream.Context())
+	if err != nil {
+		return err
+	}
+	if metadata.UserId != caller {
+		return status.Error(codes.PermissionDenied, "user_id does not match the caller")
+	}
+
+	content := &videoChunkReader{stream: stream}
+
+	// Generated files are registered in the asset manifest before upload
+	isGenerated, err := h.videoService.IsGeneratedFile(stream.Context(), metadata.FileName)
+	if err != nil {
+		logger.Logger.Error("Failed to look up video asset",
+			"filename", metadata.FileName,
+			"error", err.Error(),
+		)
+		return status.Errorf(codes.Internal, "failed to look up asset: %v", err)
+	}
 
-	if isOriginalVideo {
+	if !isGenerated {
 		logger.Logger.Info("Processing original video upload",
 			"filename", metadata.FileName,
 			"user_id", metadata.UserId,
@@ -152,10 +286,13 @@ func (h *RepoHandler) UploadVideo(stream pb.RepoService_UploadVideoServer) error
 		video, err := h.videoService.UploadOriginalVideo(
 			stream.Context(),
 			metadata.UserId,
+			metadata.UploadId,
 			metadata.Title,
 			metadata.Description,
 			metadata.FileName,
-			buf.Bytes(),
+			metadata.FileSize,
+			mediaInfo(metadata.Media),
+			content,
 		)
 
 		logger.LogGRPCRequest(stream.Context(), "UploadVideo-Original", time.Since(start), err)
@@ -166,6 +303,18 @@ func (h *RepoHandler) UploadVideo(stream pb.RepoService_UploadVideoServer) error
 				"user_id", metadata.UserId,
 				"error", err.Error(),
 			)
+			if _, ok := status.FromError(err); ok {
+				return err
+			}
+			if errors.Is(err, model.ErrQuotaExceeded) || errors.Is(err, service.ErrInvalidQuota) {
+				return quotaError(err, "original video upload")
+			}
+			if errors.Is(err, service.ErrUploadInProgress) {
+				return status.Error(codes.Aborted, err.Error())
+			}
+			if errors.Is(err, service.ErrInvalidUploadID) {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
 			return status.Errorf(codes.Internal, "original video upload failed: %v", err)
 		}
 
@@ -173,16 +322,10 @@ func (h *RepoHandler) UploadVideo(stream pb.RepoService_UploadVideoServer) error
 			"video_id", video.ID,
 			"filename", metadata.FileName,
 			"user_id", metadata.UserId,
-			"file_size_bytes", fileSize,
+			"file_size_bytes", content.n,
 		)
 
-		return stream.SendAndClose(&pb.VideoMetadataResponse{
-			Id:          video.ID,
-			UserId:      video.UserID,
-			Title:       video.Title,
-			Description: video.Description,
-			CreatedAt:   video.CreatedAt.Format(time.RFC3339),
-		})
+		return stream.SendAndClose(videoMetadataResponse(video))
 	} else {
 		logger.Logger.Info("Processing generated file upload",
 			"filename", metadata.FileName,
@@ -193,7 +336,7 @@ func (h *RepoHandler) UploadVideo(stream pb.RepoService_UploadVideoServer) error
 		err := h.videoService.UploadGeneratedFile(
 			stream.Context(),
 			metadata.FileName,
-			buf.Bytes(),
+			content,
 		)
 
 		logger.LogGRPCRequest(stream.Context(), "UploadVideo-Generated", time.Since(start), err)
@@ -204,13 +347,16 @@ func (h *RepoHandler) UploadVideo(stream pb.RepoService_UploadVideoServer) error
 				"user_id", metadata.UserId,
 				"error", err.Error(),
 			)
+			if _, ok := status.FromError(err); ok {
+				return err
+			}
 			return status.Errorf(codes.Internal, "generated file upload failed: %v", err)
 		}
 
 		logger.Logger.Info("Generated file uploaded successfully",
 			"filename", metadata.FileName,
 			"user_id", metadata.UserId,
-			"file_size_bytes", fileSize,
+			"file_size_bytes", content.n,
 		)
 
 		// Return a simple response for generated files
@@ -224,35 +370,6 @@ func (h *RepoHandler) UploadVideo(stream pb.RepoService_UploadVideoServer) error
 	}
 }
 
-// isOriginalVideo determines if the uploaded file is an original video or generated content
-func (h *RepoHandler) isOriginalVideo(fileName string) bool {
-	// Original videos typically don't have resolution suffixes or are .mp4 without special naming
-	// Generated files have patterns like: videoID_360p.mp4, videoID/360/index.m3u8, etc.
-
-	// Check for resolution patterns
-	resolutionPatterns := []string{"_144p.", "_240p.", "_360p.", "_480p.", "_720p.", "_1080p."}
-	for _, pattern := range resolutionPatterns {
-		if strings.Contains(fileName, pattern) {
-			return false
-		}
-	}
-
-	// Check for HLS patterns
-	hlsPatterns := []string{"/index.m3u8", "seg_", "_master.m3u8"}
-	for _, pattern := range hlsPatterns {
-		if strings.Contains(fileName, pattern) {
-			return false
-		}
-	}
-
-	// Check for .ts segments
-	if strings.HasSuffix(fileName, ".ts") {
-		return false
-	}
-
-	// If none of the generated file patterns match, assume it's original
-	return true
-}
 func (h *RepoHandler) GetLast3UserVideos(ctx context.Context, req *pb.GetLast3UserVideosRequest) (*pb.Video3ListResponse, error) {
 	start := time.Now()
 
@@ -260,6 +377,14 @@ func (h *RepoHandler) GetLast3UserVideos(ctx context.Context, req *pb.GetLast3Us
 		"user_id", req.UserId,
 	)
 
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller != req.UserId {
+		return nil, status.Error(codes.PermissionDenied, "videos of other users are listed with ListVideos")
+	}
+
 	videos, err := h.videoService.GetLast3VideosByUser(ctx, req.UserId)
 
 	logger.LogGRPCRequest(ctx, "GetLast3UserVideos", time.Since(start), err)
@@ -279,14 +404,7 @@ func (h *RepoHandler) GetLast3UserVideos(ctx context.Context, req *pb.GetLast3Us
 
 	resp := &pb.Video3ListResponse{}
 	for _, v := range videos {
-		resp.Videos = append(resp.Videos, &pb.VideoMetadataResponse{
-			Id:          v.ID,
-			UserId:      v.UserID,
-			Title:       v.Title,
-			Description: v.Description,
-			CreatedAt:   v.CreatedAt.Format(time.RFC3339),
-			FileName:    v.FileName,
-		})
+		resp.Videos = append(resp.Videos, videoMetadataResponse(v))
 	}
 	return resp, nil
 }
@@ -297,6 +415,14 @@ func (h *RepoHandler) GetUserVideos(ctx context.Context, req *pb.GetUserVideosRe
 		"user_id", req.UserId,
 	)
 
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller != req.UserId {
+		return nil, status.Error(codes.PermissionDenied, "videos of other users are listed with ListVideos")
+	}
+
 	videos, err := h.videoService.GetVideosByUser(ctx, req.UserId)
 
 	logger.LogGRPCRequest(ctx, "GetUserVideos", time.Since(start), err)
@@ -316,14 +442,70 @@ func (h *RepoHandler) GetUserVideos(ctx context.Context, req *pb.GetUserVideosRe
 
 	resp := &pb.VideoListResponse{}
 	for _, v := range videos {
-		resp.Videos = append(resp.Videos, &pb.VideoMetadataResponse{
-			Id:          v.ID,
-			UserId:      v.UserID,
-			Title:       v.Title,
-			Description: v.Description,
-			CreatedAt:   v.CreatedAt.Format(time.RFC3339),
-			FileName:    v.FileName,
-		})
+		resp.Videos = append(resp.Videos, videoMetadataResponse(v))
+	}
+	return resp, nil
+}
+
+func (h *RepoHandler) ListVideos(ctx context.Context, req *pb.ListVideosRequest) (*pb.ListVideosResponse, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Listing videos",
+		"user_id", req.UserId,
+		"query", req.Query,
+		"status", req.Status,
+		"limit", req.Limit,
+	)
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := model.VideoListFilter{
+		UserID:     req.UserId,
+		ViewerID:   caller,
+		Query:      req.Query,
+		Status:     model.VideoStatus(req.Status),
+		SortBy:     model.VideoSortField(req.SortBy),
+		Descending: req.SortOrder != "asc",
+		Limit:      int(req.Limit),
+	}
+	if req.SortOrder != "" && req.SortOrder != "asc" && req.SortOrder != "desc" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid sort_order %q", req.SortOrder)
+	}
+	for _, bound := range []struct {
+		value string
+		dst   *time.Time
+	}{{req.CreatedAfter, &filter.CreatedAfter}, {req.CreatedBefore, &filter.CreatedBefore}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid date %q: %v", bound.value, err)
+		}
+		*bound.dst = t
+	}
+
+	videos, next, err := h.videoService.ListVideos(ctx, filter, req.Cursor)
+
+	logger.LogGRPCRequest(ctx, "ListVideos", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to list videos",
+			"user_id", req.UserId,
+			"error", err.Error(),
+		)
+		if errors.Is(err, service.ErrInvalidListFilter) {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to list videos: %v", err)
+	}
+
+	resp := &pb.ListVideosResponse{NextCursor: next}
+	for _, v := range videos {
+		resp.Videos = append(resp.Videos, videoMetadataResponse(v))
 	}
 	return resp, nil
 }
@@ -335,7 +517,12 @@ func (h *RepoHandler) GetVideoByID(ctx context.Context, req *pb.GetVideoRequest)
 		"video_id", req.VideoId,
 	)
 
-	v, err := h.videoService.GetVideoByID(ctx, req.VideoId)
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := h.videoService.AuthorizeVideo(ctx, caller, req.VideoId, service.ActionView)
 
 	logger.LogGRPCRequest(ctx, "GetVideoByID", time.Since(start), err)
 
@@ -344,7 +531,7 @@ func (h *RepoHandler) GetVideoByID(ctx context.Context, req *pb.GetVideoRequest)
 			"video_id", req.VideoId,
 			"error", err.Error(),
 		)
-		return nil, status.Errorf(codes.NotFound, "video not found: %v", err)
+		return nil, accessError(err)
 	}
 
 	logger.Logger.Info("Successfully fetched video",
@@ -353,14 +540,7 @@ func (h *RepoHandler) GetVideoByID(ctx context.Context, req *pb.GetVideoRequest)
 		"user_id", v.UserID,
 	)
 
-	return &pb.VideoMetadataResponse{
-		Id:          v.ID,
-		UserId:      v.UserID,
-		Title:       v.Title,
-		Description: v.Description,
-		FileName:    v.FileName,
-		CreatedAt:   v.CreatedAt.Format(time.RFC3339),
-	}, nil
+	return videoMetadataResponse(v), nil
 }
 
 func (h *RepoHandler) DownloadVideo(req *pb.DownloadVideoRequest, stream pb.RepoService_DownloadVideoServer) error {
@@ -368,22 +548,50 @@ func (h *RepoHandler) DownloadVideo(req *pb.DownloadVideoRequest, stream pb.Repo
 
 	logger.Logger.Info("Starting video download",
 		"filename", req.FileName,
+		"offset", req.Offset,
+		"length", req.Length,
 	)
 
-	content, filename, err := h.videoService.DownloadFile(stream.Context(), req.FileName)
+	caller, err := callerID(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	// A video ID downloads the original, any other file must be a
+	// registered asset of a video the caller can see
+	fileName := req.FileName
+	if req.VideoId != "" {
+		v, err := h.videoService.AuthorizeVideo(stream.Context(), caller, req.VideoId, service.ActionView)
+		if err != nil {
+			return accessError(err)
+		}
+		fileName = v.FileName
+	} else if _, err := h.videoService.AuthorizeFile(stream.Context(), caller, fileName); err != nil {
+		return accessError(err)
+	}
 
+	file, err := h.videoService.OpenFile(stream.Context(), fileName, req.Offset, req.Length)
 	if err != nil {
 		logger.Logger.Error("Video download failed",
 			"filename", req.FileName,
 			"error", err.Error(),
 		)
+		switch {
+		case errors.Is(err, storage.ErrObjectNotFound):
+			return status.Errorf(codes.NotFound, "file not found: %s", fileName)
+		case errors.Is(err, service.ErrRangeNotSatisfiable):
+			return status.Errorf(codes.OutOfRange, "%v", err)
+		}
 		return status.Errorf(codes.Internal, "download failed: %v", err)
 	}
+	defer file.Body.Close()
 
-	fileSize := int64(len(content))
-	logger.Logger.Info("Video content retrieved",
+	filename := fileName
+	logger.Logger.Info("Video content opened",
 		"filename", filename,
-		"file_size_bytes", fileSize,
+		"file_size_bytes", file.Object.Size,
+		"range_offset", file.Offset,
+		"range_length", file.Length,
 	)
 
 	// Determine content type based on file extension
@@ -393,9 +601,13 @@ func (h *RepoHandler) DownloadVideo(req *pb.DownloadVideoRequest, stream pb.Repo
 	err = stream.Send(&pb.VideoFileResponse{
 		Data: &pb.VideoFileResponse_Metadata{
 			Metadata: &pb.VideoFileMetadata{
-				FileName:    filename,
-				FileSize:    fileSize,
-				ContentType: contentType,
+				FileName:     filename,
+				FileSize:     file.Object.Size,
+				ContentType:  contentType,
+				Etag:         file.Object.ETag,
+				LastModified: file.Object.LastModified.UTC().Format(time.RFC3339),
+				Offset:       file.Offset,
+				Length:       file.Length,
 			},
 		},
 	})
@@ -407,32 +619,36 @@ func (h *RepoHandler) DownloadVideo(req *pb.DownloadVideoRequest, stream pb.Repo
 		return status.Errorf(codes.Internal, "failed to send metadata: %v", err)
 	}
 
-	// Send chunks
+	// Send chunks straight from the object stream
 	const chunkSize = 512 * 1024 // 512 KB
-	n := len(content)
+	buf := make([]byte, chunkSize)
 	chunkNumber := int32(0)
-	totalChunks := (n + chunkSize - 1) / chunkSize
+	var sent int64
 
 	logger.Logger.Info("Starting to send file chunks",
 		"filename", filename,
-		"total_chunks", totalChunks,
+		"total_chunks", (file.Length+chunkSize-1)/chunkSize,
 		"chunk_size_kb", chunkSize/1024,
 	)
 
-	for i := 0; i < n; i += chunkSize {
-		end := i + chunkSize
-		if end > n {
-			end = n
+	for sent < file.Length {
+		n, readErr := io.ReadFull(file.Body, buf[:min(int64(chunkSize), file.Length-sent)])
+		if readErr != nil {
+			logger.Logger.Error("Failed to read file content",
+				"filename", filename,
+				"chunk_number", chunkNumber,
+				"error", readErr.Error(),
+			)
+			return status.Errorf(codes.Internal, "failed to read chunk %d: %v", chunkNumber, readErr)
 		}
-		chunk := content[i:end]
-		isLast := end == n
+		sent += int64(n)
 
 		err = stream.Send(&pb.VideoFileResponse{
 			Data: &pb.VideoFileResponse_Chunk{
 				Chunk: &pb.VideoFileChunk{
-					Data:        chunk,
+					Data:        buf[:n],
 					ChunkNumber: chunkNumber,
-					IsLast:      isLast,
+					IsLast:      sent == file.Length,
 				},
 			},
 		})
@@ -450,7 +666,7 @@ func (h *RepoHandler) DownloadVideo(req *pb.DownloadVideoRequest, stream pb.Repo
 	logger.LogGRPCRequest(stream.Context(), "DownloadVideo", time.Since(start), nil)
 	logger.Logger.Info("Video download completed successfully",
 		"filename", filename,
-		"file_size_bytes", fileSize,
+		"bytes_sent", sent,
 		"chunks_sent", chunkNumber,
 	)
 
@@ -461,6 +677,12 @@ func (h *RepoHandler) DownloadVideo(req *pb.DownloadVideoRequest, stream pb.Repo
 func (h *RepoHandler) getContentType(filename string) string {
 	if strings.HasSuffix(filename, ".mp4") {
 		return "video/mp4"
+	} else if strings.HasSuffix(filename, ".mov") {
+		return "video/quicktime"
+	} else if strings.HasSuffix(filename, ".mkv") {
+		return "video/x-matroska"
+	} else if strings.HasSuffix(filename, ".webm") {
+		return "video/webm"
 	} else if strings.HasSuffix(filename, ".m3u8") {
 		return "application/x-mpegURL"
 	} else if strings.HasSuffix(filename, ".ts") {
@@ -476,11 +698,22 @@ func (h *RepoHandler) RemoveVideo(ctx context.Context, req *pb.GetVideoRequest)
 		"video_id", req.VideoId,
 	)
 
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.videoService.AuthorizeVideo(ctx, caller, req.VideoId, service.ActionManage); err != nil {
+		return nil, accessError(err)
+	}
+
 	if err := h.videoService.RemoveVideo(ctx, req.VideoId); err != nil {
 		logger.Logger.Error("Failed to remove video",
 			"video_id", req.VideoId,
 			"error", err.Error(),
 		)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "video not found: %v", err)
+		}
 		return nil, status.Errorf(codes.Internal, "remove video failed: %v", err)
 	}
 
@@ -491,3 +724,301 @@ func (h *RepoHandler) RemoveVideo(ctx context.Context, req *pb.GetVideoRequest)
 
 	return &emptypb.Empty{}, nil
 }
+
+func (h *RepoHandler) RestoreVideo(ctx context.Context, req *pb.GetVideoRequest) (*pb.VideoMetadataResponse, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Restoring video",
+		"video_id", req.VideoId,
+	)
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.videoService.AuthorizeVideo(ctx, caller, req.VideoId, service.ActionRestore); err != nil {
+		return nil, accessError(err)
+	}
+
+	v, err := h.videoService.RestoreVideo(ctx, req.VideoId)
+
+	logger.LogGRPCRequest(ctx, "RestoreVideo", time.Since(start), err)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "video not found: %v", err)
+		}
+		if errors.Is(err, model.ErrQuotaExceeded) {
+			return nil, quotaError(err, "restore video")
+		}
+		return nil, status.Errorf(codes.Internal, "restore video failed: %v", err)
+	}
+
+	logger.Logger.Info("Video restored successfully",
+		"video_id", v.ID,
+	)
+
+	return videoMetadataResponse(v), nil
+}
+
+func (h *RepoHandler) UpdateVideoVisibility(ctx context.Context, req *pb.UpdateVideoVisibilityRequest) (*pb.VideoMetadataResponse, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Updating video visibility",
+		"video_id", req.VideoId,
+		"visibility", req.Visibility,
+	)
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := h.videoService.SetVisibility(ctx, caller, req.VideoId, model.VideoVisibility(req.Visibility), req.SharedWith)
+
+	logger.LogGRPCRequest(ctx, "UpdateVideoVisibility", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to update video visibility",
+			"video_id", req.VideoId,
+			"visibility", req.Visibility,
+			"error", err.Error(),
+		)
+		if errors.Is(err, service.ErrInvalidVisibility) {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		return nil, accessError(err)
+	}
+
+	logger.Logger.Info("Video visibility updated successfully",
+		"video_id", v.ID,
+		"visibility", v.Visibility,
+	)
+
+	return videoMetadataResponse(v), nil
+}
+
+func (h *RepoHandler) UpdateVideoStatus(ctx context.Context, req *pb.UpdateVideoStatusRequest) (*pb.VideoMetadataResponse, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Updating video status",
+		"video_id", req.VideoId,
+		"status", req.Status,
+	)
+
+	newStatus := model.VideoStatus(req.Status)
+	if req.VideoId == "" || !newStatus.IsValid() {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid video status %q", req.Status)
+	}
+
+	// The pipeline reports on behalf of the owner, admins may correct the
+	// status of any video
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.videoService.AuthorizeVideo(ctx, caller, req.VideoId, service.ActionManage); err != nil {
+		if _, scopeErr := h.requireScope(ctx, model.ScopeAdminStorage); scopeErr != nil {
+			return nil, accessError(err)
+		}
+	}
+
+	v, err := h.videoService.UpdateVideoStatus(ctx, req.VideoId, newStatus, req.Reason)
+
+	logger.LogGRPCRequest(ctx, "UpdateVideoStatus", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to update video status",
+			"video_id", req.VideoId,
+			"status", req.Status,
+			"error", err.Error(),
+		)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, status.Errorf(codes.NotFound, "video not found: %v", err)
+		case errors.Is(err, model.ErrInvalidStatusTransition):
+			return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "update video status failed: %v", err)
+	}
+
+	logger.Logger.Info("Video status updated successfully",
+		"video_id", v.ID,
+		"status", v.Status,
+	)
+
+	return videoMetadataResponse(v), nil
+}
+
+func (h *RepoHandler) GetVideoStatusHistory(ctx context.Context, req *pb.GetVideoRequest) (*pb.VideoStatusHistoryResponse, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Fetching video status history",
+		"video_id", req.VideoId,
+	)
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.videoService.AuthorizeVideo(ctx, caller, req.VideoId, service.ActionView); err != nil {
+		return nil, accessError(err)
+	}
+
+	changes, err := h.videoService.GetStatusHistory(ctx, req.VideoId)
+
+	logger.LogGRPCRequest(ctx, "GetVideoStatusHistory", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to fetch video status history",
+			"video_id", req.VideoId,
+			"error", err.Error(),
+		)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "video not found: %v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "fetch status history failed: %v", err)
+	}
+
+	resp := &pb.VideoStatusHistoryResponse{}
+	for _, c := range changes {
+		resp.Changes = append(resp.Changes, videoStatusChangeResponse(c))
+	}
+	return resp, nil
+}
+
+func (h *RepoHandler) RegisterVideoAssets(ctx context.Context, req *pb.RegisterVideoAssetsRequest) (*pb.VideoAssetListResponse, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Registering video assets",
+		"video_id", req.VideoId,
+		"asset_count", len(req.Assets),
+	)
+
+	assets := make([]*model.VideoAsset, 0, len(req.Assets))
+	for _, a := range req.Assets {
+		kind := model.AssetKind(a.Kind)
+		if a.ObjectKey == "" || !kind.IsValid() {
+			return nil, status.Errorf(codes.InvalidArgument, "asset %q needs an object key and a known kind", a.ObjectKey)
+		}
+		assets = append(assets, &model.VideoAsset{
+			Kind:      kind,
+			Rendition: a.Rendition,
+			Codec:     a.Codec,
+			Bitrate:   a.Bitrate,
+			Width:     a.Width,
+			Height:    a.Height,
+			ByteSize:  a.ByteSize,
+			ObjectKey: a.ObjectKey,
+		})
+	}
+	if req.VideoId == "" || len(assets) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "video_id and at least one asset are required")
+	}
+
+	// Assets are registered by the pipeline on behalf of the owner, or by
+	// admins, as UploadVideo and file downloads trust their object keys
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.videoService.AuthorizeVideo(ctx, caller, req.VideoId, service.ActionManage); err != nil {
+		if _, scopeErr := h.requireScope(ctx, model.ScopeAdminStorage); scopeErr != nil {
+			return nil, accessError(err)
+		}
+	}
+
+	saved, err := h.videoService.RegisterAssets(ctx, req.VideoId, assets)
+
+	logger.LogGRPCRequest(ctx, "RegisterVideoAssets", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to register video assets",
+			"video_id", req.VideoId,
+			"error", err.Error(),
+		)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "video not found: %v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "register assets failed: %v", err)
+	}
+
+	resp := &pb.VideoAssetListResponse{}
+	for _, a := range saved {
+		resp.Assets = append(resp.Assets, videoAssetResponse(a))
+	}
+	return resp, nil
+}
+
+func (h *RepoHandler) ListVideoAssets(ctx context.Context, req *pb.GetVideoRequest) (*pb.VideoAssetListResponse, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Listing video assets",
+		"video_id", req.VideoId,
+	)
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.videoService.AuthorizeVideo(ctx, caller, req.VideoId, service.ActionView); err != nil {
+		return nil, accessError(err)
+	}
+
+	assets, err := h.videoService.GetAssetsByVideo(ctx, req.VideoId)
+
+	logger.LogGRPCRequest(ctx, "ListVideoAssets", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to list video assets",
+			"video_id", req.VideoId,
+			"error", err.Error(),
+		)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "video not found: %v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "list assets failed: %v", err)
+	}
+
+	resp := &pb.VideoAssetListResponse{}
+	for _, a := range assets {
+		resp.Assets = append(resp.Assets, videoAssetResponse(a))
+	}
+	return resp, nil
+}
+
+func (h *RepoHandler) CollectOrphans(ctx context.Context, req *pb.CollectOrphansRequest) (*pb.CollectOrphansResponse, error) {
+	start := time.Now()
+
+	caller, err := h.requireScope(ctx, model.ScopeAdminStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Info("Collecting orphan objects",
+		"delete", req.Delete,
+		"caller_id", caller,
+	)
+
+	report, err := h.orphanCollector.Collect(ctx, !req.Delete)
+
+	logger.LogGRPCRequest(ctx, "CollectOrphans", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Orphan collection failed",
+			"error", err.Error(),
+		)
+		return nil, status.Errorf(codes.Internal, "collect orphans failed: %v", err)
+	}
+
+	resp := &pb.CollectOrphansResponse{
+		DryRun:     report.DryRun,
+		Scanned:    int32(report.Scanned),
+		TotalBytes: report.OrphanSize,
+		Removed:    int32(report.Removed),
+	}
+	for _, obj := range report.Orphans {
+		resp.ObjectKeys = append(resp.ObjectKeys, obj.Key)
+	}
+	return resp, nil
+}
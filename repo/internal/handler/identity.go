@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// userIDMetadataKey carries the user the gateway authenticated for a call
+const userIDMetadataKey = "x-user-id"
+
+// callerID returns the user a call is made on behalf of
+func callerID(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get(userIDMetadataKey); len(ids) == 1 && ids[0] != "" {
+		return ids[0], nil
+	}
+	return "", status.Error(codes.Unauthenticated, "missing caller identity")
+}
+
+// requireScope returns the caller when they hold scope
+func (h *RepoHandler) requireScope(ctx context.Context, scope model.Scope) (string, error) {
+	caller, err := callerID(ctx)
+	if err != nil {
+		return "", err
+	}
+	user, err := h.userService.GetUserByID(ctx, caller)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", status.Error(codes.Unauthenticated, "unknown caller")
+	}
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "load caller failed: %v", err)
+	}
+	if !user.HasScope(scope) {
+		return "", status.Errorf(codes.PermissionDenied, "missing scope %s", scope)
+	}
+	return caller, nil
+}
+
+// accessError maps authorization failures to gRPC status errors
+func accessError(err error) error {
+	switch {
+	case errors.Is(err, model.ErrVideoNotFound):
+		return status.Error(codes.NotFound, "video not found")
+	case errors.Is(err, model.ErrVideoAccessDenied):
+		return status.Error(codes.PermissionDenied, "only the owner can change this video")
+	}
+	return status.Errorf(codes.Internal, "authorize video failed: %v", err)
+}
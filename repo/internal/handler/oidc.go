@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"codek7/common/pb"
+
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/service"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (h *RepoHandler) LoginWithIdentity(ctx context.Context, req *pb.IdentityLoginRequest) (*pb.IdentityLoginResponse, error) {
+	start := time.Now()
+
+	user, created, err := h.identityService.LoginWithIdentity(ctx, model.ExternalIdentity{
+		Provider:      req.Provider,
+		Subject:       req.Subject,
+		Email:         req.Email,
+		EmailVerified: req.EmailVerified,
+		DisplayName:   req.DisplayName,
+		AvatarURL:     req.AvatarUrl,
+	})
+
+	logger.LogGRPCRequest(ctx, "LoginWithIdentity", time.Since(start), err)
+
+	switch {
+	case errors.Is(err, service.ErrInvalidIdentity):
+		return nil, status.Error(codes.InvalidArgument, "provider and subject are required")
+	case errors.Is(err, model.ErrIdentityEmailRequired):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, model.ErrIdentityEmailConflict):
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "login with identity failed: %v", err)
+	}
+
+	logger.Logger.Info("User logged in with identity",
+		"user_id", user.ID,
+		"provider", req.Provider,
+		"created", created,
+	)
+
+	return &pb.IdentityLoginResponse{User: userResponse(user), Created: created}, nil
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"codek7/common/pb"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/service"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// profileError maps profile failures to gRPC status errors
+func profileError(err error, op string) error {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, service.ErrInvalidProfile), errors.Is(err, service.ErrEmptyPassword),
+		errors.Is(err, service.ErrPasswordTooLong):
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	case errors.Is(err, service.ErrEmailTaken):
+		return status.Error(codes.AlreadyExists, "email already in use")
+	}
+	return status.Errorf(codes.Internal, "%s failed: %v", op, err)
+}
+
+func (h *RepoHandler) VerifyCredentials(ctx context.Context, req *pb.VerifyCredentialsRequest) (*pb.UserResponse, error) {
+	start := time.Now()
+
+	user, err := h.userService.VerifyCredentials(ctx, req.UsernameOrEmail, req.Password)
+
+	logger.LogGRPCRequest(ctx, "VerifyCredentials", time.Since(start), err)
+
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		return nil, status.Error(codes.Unauthenticated, "invalid username or password")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "verify credentials failed: %v", err)
+	}
+
+	return userResponse(user), nil
+}
+
+func (h *RepoHandler) ResolveLogin(ctx context.Context, req *pb.ResolveLoginRequest) (*pb.ResolveLoginResponse, error) {
+	start := time.Now()
+
+	userID, err := h.userService.ResolveLogin(ctx, req.UsernameOrEmail)
+
+	logger.LogGRPCRequest(ctx, "ResolveLogin", time.Since(start), err)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "resolve login failed: %v", err)
+	}
+	return &pb.ResolveLoginResponse{UserId: userID}, nil
+}
+
+func (h *RepoHandler) GetMe(ctx context.Context, _ *emptypb.Empty) (*pb.UserResponse, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.userService.GetUserByID(ctx, caller)
+
+	logger.LogGRPCRequest(ctx, "GetMe", time.Since(start), err)
+
+	if err != nil {
+		return nil, profileError(err, "get profile")
+	}
+	return userResponse(user), nil
+}
+
+func (h *RepoHandler) UpdateProfile(ctx context.Context, req *pb.UpdateProfileRequest) (*pb.UserResponse, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.userService.UpdateProfile(ctx, caller, model.ProfileUpdate{
+		DisplayName: req.DisplayName,
+		Email:       req.Email,
+		AvatarURL:   req.AvatarUrl,
+	})
+
+	logger.LogGRPCRequest(ctx, "UpdateProfile", time.Since(start), err)
+
+	if err != nil {
+		return nil, profileError(err, "update profile")
+	}
+
+	logger.Logger.Info("Profile updated",
+		"user_id", user.ID,
+	)
+
+	return userResponse(user), nil
+}
+
+func (h *RepoHandler) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*emptypb.Empty, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.userService.ChangePassword(ctx, caller, req.CurrentPassword, req.Password)
+
+	logger.LogGRPCRequest(ctx, "ChangePassword", time.Since(start), err)
+
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		return nil, status.Error(codes.PermissionDenied, "current password is incorrect")
+	}
+	if err != nil {
+		return nil, profileError(err, "change password")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// DeleteAccount purges the caller's videos and objects, then removes the
+// account. A failed purge leaves the account in place so the call can be
+// retried.
+func (h *RepoHandler) DeleteAccount(ctx context.Context, _ *emptypb.Empty) (*pb.DeleteAccountResponse, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Info("Deleting account",
+		"user_id", caller,
+	)
+
+	removed, err := h.videoPurger.PurgeOwner(ctx, caller)
+	if err == nil {
+		err = h.userService.DeleteUser(ctx, caller)
+	}
+
+	logger.LogGRPCRequest(ctx, "DeleteAccount", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to delete account",
+			"user_id", caller,
+			"videos_removed", removed,
+			"error", err.Error(),
+		)
+		return nil, profileError(err, "delete account")
+	}
+
+	logger.Logger.Info("Account deleted",
+		"user_id", caller,
+		"videos_removed", removed,
+	)
+
+	return &pb.DeleteAccountResponse{VideosRemoved: int32(removed)}, nil
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"codek7/common/pb"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/service"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	uuid "github.com/satori/go.uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// quotaError maps quota failures to gRPC status errors. Only the byte
+// limits are exhausted resources; a user at their video limit has to
+// delete videos first, whatever they upload.
+func quotaError(err error, op string) error {
+	switch {
+	case errors.Is(err, model.ErrVideoLimitReached):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, model.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, service.ErrInvalidQuota):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, pgx.ErrNoRows):
+		return status.Error(codes.NotFound, "user not found")
+	}
+	return status.Errorf(codes.Internal, "%s failed: %v", op, err)
+}
+
+func quotaResponse(q *model.Quota) *pb.QuotaResponse {
+	resp := &pb.QuotaResponse{
+		UserId:             q.UserID,
+		BytesUsed:          q.BytesUsed,
+		VideoCount:         int32(q.VideoCount),
+		MaxBytes:           q.Limits.MaxBytes,
+		MaxVideos:          int32(q.Limits.MaxVideos),
+		MaxFileBytes:       q.Limits.MaxFileBytes,
+		CustomMaxBytes:     q.Overrides.MaxBytes != nil,
+		CustomMaxVideos:    q.Overrides.MaxVideos != nil,
+		CustomMaxFileBytes: q.Overrides.MaxFileBytes != nil,
+	}
+	if !q.UpdatedAt.IsZero() {
+		resp.UpdatedAt = q.UpdatedAt.Format(time.RFC3339)
+	}
+	return resp
+}
+
+func (h *RepoHandler) GetQuota(ctx context.Context, _ *emptypb.Empty) (*pb.QuotaResponse, error) {
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := h.quotaService.GetQuota(ctx, caller)
+	if err != nil {
+		return nil, quotaError(err, "get quota")
+	}
+	return quotaResponse(q), nil
+}
+
+func (h *RepoHandler) CheckUploadQuota(ctx context.Context, req *pb.CheckUploadQuotaRequest) (*pb.QuotaResponse, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := h.quotaService.CheckUpload(ctx, caller, req.FileSize)
+
+	logger.LogGRPCRequest(ctx, "CheckUploadQuota", time.Since(start), err)
+
+	if err != nil {
+		return nil, quotaError(err, "check upload quota")
+	}
+	return quotaResponse(q), nil
+}
+
+func (h *RepoHandler) GetUserQuota(ctx context.Context, req *pb.GetUserQuotaRequest) (*pb.QuotaResponse, error) {
+	if _, err := h.requireScope(ctx, model.ScopeAdminUsers); err != nil {
+		return nil, err
+	}
+	if err := h.requireUser(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+
+	q, err := h.quotaService.GetQuota(ctx, req.UserId)
+	if err != nil {
+		return nil, quotaError(err, "get user quota")
+	}
+	return quotaResponse(q), nil
+}
+
+func (h *RepoHandler) SetUserQuota(ctx context.Context, req *pb.SetUserQuotaRequest) (*pb.QuotaResponse, error) {
+	start := time.Now()
+
+	caller, err := h.requireScope(ctx, model.ScopeAdminUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Info("Setting user quota",
+		"user_id", req.UserId,
+		"caller_id", caller,
+	)
+
+	if err := h.requireUser(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+
+	update := model.QuotaUpdate{MaxBytes: req.MaxBytes, MaxFileBytes: req.MaxFileBytes}
+	if req.MaxVideos != nil {
+		n := int(*req.MaxVideos)
+		update.MaxVideos = &n
+	}
+
+	q, err := h.quotaService.SetLimits(ctx, req.UserId, update)
+
+	logger.LogGRPCRequest(ctx, "SetUserQuota", time.Since(start), err)
+
+	if err != nil {
+		return nil, quotaError(err, "set user quota")
+	}
+	return quotaResponse(q), nil
+}
+
+// requireUser answers NOT_FOUND for ids of users that do not exist
+func (h *RepoHandler) requireUser(ctx context.Context, userID string) error {
+	if _, err := uuid.FromString(userID); err != nil {
+		return status.Error(codes.NotFound, "user not found")
+	}
+	if _, err := h.userService.GetUserByID(ctx, userID); err != nil {
+		return quotaError(err, "get user")
+	}
+	return nil
+}
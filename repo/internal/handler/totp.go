@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"codek7/common/pb"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	uuid "github.com/satori/go.uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// totpError maps two-factor failures to gRPC status errors
+func totpError(err error, op string) error {
+	switch {
+	case errors.Is(err, model.ErrTotpInvalidCode):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, model.ErrTotpNotEnrolled), errors.Is(err, model.ErrTotpAlreadyEnabled),
+		errors.Is(err, model.ErrTotpEnrollmentStale):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, model.ErrTotpNotConfigured):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, pgx.ErrNoRows):
+		return status.Error(codes.NotFound, "user not found")
+	}
+	return status.Errorf(codes.Internal, "%s failed: %v", op, err)
+}
+
+func (h *RepoHandler) GetTotpStatus(ctx context.Context, _ *emptypb.Empty) (*pb.TotpStatusResponse, error) {
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := h.totpService.Status(ctx, caller)
+	if err != nil {
+		return nil, totpError(err, "get totp status")
+	}
+	return &pb.TotpStatusResponse{Enabled: st.Enabled, RecoveryCodesRemaining: int32(st.RecoveryCodesRemaining)}, nil
+}
+
+func (h *RepoHandler) BeginTotpEnrollment(ctx context.Context, _ *emptypb.Empty) (*pb.TotpEnrollmentResponse, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment, err := h.totpService.BeginEnrollment(ctx, caller)
+
+	logger.LogGRPCRequest(ctx, "BeginTotpEnrollment", time.Since(start), err)
+
+	if err != nil {
+		return nil, totpError(err, "begin totp enrollment")
+	}
+	return &pb.TotpEnrollmentResponse{Secret: enrollment.Secret, ProvisioningUri: enrollment.URI}, nil
+}
+
+func (h *RepoHandler) ConfirmTotpEnrollment(ctx context.Context, req *pb.TotpCodeRequest) (*pb.TotpRecoveryCodesResponse, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recovery, err := h.totpService.ConfirmEnrollment(ctx, caller, req.Code)
+
+	logger.LogGRPCRequest(ctx, "ConfirmTotpEnrollment", time.Since(start), err)
+
+	if err != nil {
+		return nil, totpError(err, "confirm totp enrollment")
+	}
+	return &pb.TotpRecoveryCodesResponse{Codes: recovery}, nil
+}
+
+func (h *RepoHandler) RegenerateTotpRecoveryCodes(ctx context.Context, req *pb.TotpCodeRequest) (*pb.TotpRecoveryCodesResponse, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recovery, err := h.totpService.RegenerateRecoveryCodes(ctx, caller, req.Code)
+
+	logger.LogGRPCRequest(ctx, "RegenerateTotpRecoveryCodes", time.Since(start), err)
+
+	if err != nil {
+		return nil, totpError(err, "regenerate recovery codes")
+	}
+	return &pb.TotpRecoveryCodesResponse{Codes: recovery}, nil
+}
+
+func (h *RepoHandler) DisableTotp(ctx context.Context, req *pb.TotpCodeRequest) (*emptypb.Empty, error) {
+	start := time.Now()
+
+	caller, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.totpService.Disable(ctx, caller, req.Code)
+
+	logger.LogGRPCRequest(ctx, "DisableTotp", time.Since(start), err)
+
+	if err != nil {
+		return nil, totpError(err, "disable totp")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (h *RepoHandler) VerifyTotp(ctx context.Context, req *pb.VerifyTotpRequest) (*pb.UserResponse, error) {
+	start := time.Now()
+
+	if _, err := uuid.FromString(req.UserId); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user_id")
+	}
+
+	user, err := h.totpService.Verify(ctx, req.UserId, req.Code)
+
+	logger.LogGRPCRequest(ctx, "VerifyTotp", time.Since(start), err)
+
+	if err != nil {
+		return nil, totpError(err, "verify totp")
+	}
+	return userResponse(user), nil
+}
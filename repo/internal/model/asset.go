@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// AssetKind classifies an object stored for a video
+type AssetKind string
+
+const (
+	AssetKindOriginal  AssetKind = "original"  // the uploaded source file
+	AssetKindRendition AssetKind = "rendition" // a progressive file at one resolution
+	AssetKindMaster    AssetKind = "master"    // the HLS master playlist
+	AssetKindPlaylist  AssetKind = "playlist"  // an HLS variant playlist
+	AssetKindSegment   AssetKind = "segment"   // an HLS media segment
+)
+
+// IsValid reports whether k is a known asset kind
+func (k AssetKind) IsValid() bool {
+	switch k {
+	case AssetKindOriginal, AssetKindRendition, AssetKindMaster, AssetKindPlaylist, AssetKindSegment:
+		return true
+	}
+	return false
+}
+
+// VideoAsset is one object in MinIO that belongs to a video
+type VideoAsset struct {
+	ID        string    `json:"id" db:"id"`
+	VideoID   string    `json:"video_id" db:"video_id"`
+	Kind      AssetKind `json:"kind" db:"kind"`
+	Rendition string    `json:"rendition" db:"rendition"` // e.g. "720p", empty for the original and master
+	Codec     string    `json:"codec" db:"codec"`
+	Bitrate   int64     `json:"bitrate" db:"bitrate"` // bits per second
+	Width     int32     `json:"width" db:"width"`
+	Height    int32     `json:"height" db:"height"`
+	ByteSize  int64     `json:"byte_size" db:"byte_size"`
+	ObjectKey string    `json:"object_key" db:"object_key"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrIdentityEmailRequired is returned when a provider asserts no email
+	// for a subject that is not linked yet, accounts need one
+	ErrIdentityEmailRequired = errors.New("identity provider returned no email address")
+	// ErrIdentityEmailConflict is returned when the email of a new identity
+	// belongs to an account it cannot safely be linked to
+	ErrIdentityEmailConflict = errors.New("an account with this email address already exists")
+)
+
+// UserIdentity links a user to their account at an OpenID Connect provider
+type UserIdentity struct {
+	ID          string     `json:"id" db:"id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	Provider    string     `json:"provider" db:"provider"`
+	Subject     string     `json:"subject" db:"subject"`
+	Email       string     `json:"email" db:"email"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+}
+
+// ExternalIdentity is what the gateway learned from a verified ID token
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	DisplayName   string
+	AvatarURL     string
+}
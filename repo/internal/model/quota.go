@@ -0,0 +1,103 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrQuotaExceeded is returned for uploads and restores that would take a
+// user over one of their limits
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ErrVideoLimitReached is the ErrQuotaExceeded of a user who has as many
+// videos as they may have. Unlike the byte limits it does not depend on
+// the size of the upload.
+var ErrVideoLimitReached = fmt.Errorf("%w: video limit reached", ErrQuotaExceeded)
+
+// QuotaLimits bound what a user may store, 0 is unlimited
+type QuotaLimits struct {
+	MaxBytes     int64 `json:"max_bytes"`
+	MaxVideos    int   `json:"max_videos"`
+	MaxFileBytes int64 `json:"max_file_bytes"` // largest single original
+}
+
+// QuotaOverrides are the limits set for one user, a nil limit is the default
+type QuotaOverrides struct {
+	MaxBytes     *int64 `json:"max_bytes,omitempty" db:"max_bytes"`
+	MaxVideos    *int   `json:"max_videos,omitempty" db:"max_videos"`
+	MaxFileBytes *int64 `json:"max_file_bytes,omitempty" db:"max_file_bytes"`
+}
+
+// Quota is the storage a user uses. Originals count from the moment they
+// are stored until their video is moved to the trash; while they upload,
+// their video and declared size are reserved.
+type Quota struct {
+	UserID     string         `json:"user_id" db:"user_id"`
+	BytesUsed  int64          `json:"bytes_used" db:"bytes_used"`
+	VideoCount int            `json:"video_count" db:"video_count"`
+	Overrides  QuotaOverrides `json:"overrides"`
+	// Limits are the limits in effect, the overrides applied to the defaults
+	Limits    QuotaLimits `json:"limits"`
+	UpdatedAt time.Time   `json:"updated_at" db:"updated_at"`
+}
+
+// Resolve sets Limits from the defaults and the user's overrides
+func (q *Quota) Resolve(defaults QuotaLimits) {
+	q.Limits = defaults
+	if q.Overrides.MaxBytes != nil {
+		q.Limits.MaxBytes = *q.Overrides.MaxBytes
+	}
+	if q.Overrides.MaxVideos != nil {
+		q.Limits.MaxVideos = *q.Overrides.MaxVideos
+	}
+	if q.Overrides.MaxFileBytes != nil {
+		q.Limits.MaxFileBytes = *q.Overrides.MaxFileBytes
+	}
+}
+
+// Fits checks whether one more video of size bytes fits within the total
+// limits. A size of 0 only checks that some room is left.
+func (q *Quota) Fits(size int64) error {
+	if q.Limits.MaxVideos > 0 && q.VideoCount+1 > q.Limits.MaxVideos {
+		return fmt.Errorf("%w: at most %d videos", ErrVideoLimitReached, q.Limits.MaxVideos)
+	}
+	return q.FitsBytes(size)
+}
+
+// FitsBytes checks whether size more bytes fit within the byte limit
+func (q *Quota) FitsBytes(size int64) error {
+	if q.Limits.MaxBytes > 0 && (q.BytesUsed+size > q.Limits.MaxBytes || q.BytesUsed >= q.Limits.MaxBytes) {
+		return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, q.BytesUsed, q.Limits.MaxBytes)
+	}
+	return nil
+}
+
+// AllowsUpload checks whether a new original of size bytes, 0 if unknown,
+// may be uploaded
+func (q *Quota) AllowsUpload(size int64) error {
+	if q.Limits.MaxFileBytes > 0 && size > q.Limits.MaxFileBytes {
+		return fmt.Errorf("%w: files may be at most %d bytes", ErrQuotaExceeded, q.Limits.MaxFileBytes)
+	}
+	return q.Fits(size)
+}
+
+// UploadLimit returns how many bytes a new original may have, 0 for no limit
+func (q *Quota) UploadLimit() int64 {
+	limit := q.Limits.MaxFileBytes
+	if q.Limits.MaxBytes > 0 {
+		remaining := max(q.Limits.MaxBytes-q.BytesUsed, 0)
+		if limit == 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	return limit
+}
+
+// QuotaUpdate changes a user's limits. Nil fields are left as they are, a
+// negative value returns the limit to the default and 0 lifts it.
+type QuotaUpdate struct {
+	MaxBytes     *int64
+	MaxVideos    *int
+	MaxFileBytes *int64
+}
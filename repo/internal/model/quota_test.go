@@ -0,0 +1,100 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQuotaResolve(t *testing.T) {
+	defaults := QuotaLimits{MaxBytes: 1000, MaxVideos: 10, MaxFileBytes: 100}
+	bytes := func(n int64) *int64 { return &n }
+	videos := func(n int) *int { return &n }
+
+	tests := []struct {
+		name      string
+		overrides QuotaOverrides
+		want      QuotaLimits
+	}{
+		{"defaults", QuotaOverrides{}, defaults},
+		{"all set", QuotaOverrides{MaxBytes: bytes(5), MaxVideos: videos(2), MaxFileBytes: bytes(3)}, QuotaLimits{5, 2, 3}},
+		{"one set", QuotaOverrides{MaxVideos: videos(20)}, QuotaLimits{1000, 20, 100}},
+		{"lifted", QuotaOverrides{MaxBytes: bytes(0), MaxVideos: videos(0), MaxFileBytes: bytes(0)}, QuotaLimits{}},
+	}
+	for _, tt := range tests {
+		q := Quota{Overrides: tt.overrides, Limits: QuotaLimits{MaxBytes: -1}}
+		q.Resolve(defaults)
+		if q.Limits != tt.want {
+			t.Errorf("%s: Limits = %+v, want %+v", tt.name, q.Limits, tt.want)
+		}
+	}
+}
+
+func TestQuotaFits(t *testing.T) {
+	tests := []struct {
+		name   string
+		quota  Quota
+		size   int64
+		want   error
+		videos bool // want ErrVideoLimitReached
+	}{
+		{"unlimited", Quota{BytesUsed: 1 << 40, VideoCount: 1 << 20}, 1 << 40, nil, false},
+		{"room left", Quota{BytesUsed: 400, VideoCount: 1, Limits: QuotaLimits{MaxBytes: 1000, MaxVideos: 2}}, 600, nil, false},
+		{"too large", Quota{BytesUsed: 400, Limits: QuotaLimits{MaxBytes: 1000}}, 601, ErrQuotaExceeded, false},
+		{"unknown size with room", Quota{BytesUsed: 999, Limits: QuotaLimits{MaxBytes: 1000}}, 0, nil, false},
+		{"unknown size when full", Quota{BytesUsed: 1000, Limits: QuotaLimits{MaxBytes: 1000}}, 0, ErrQuotaExceeded, false},
+		{"over after a lowered limit", Quota{BytesUsed: 2000, Limits: QuotaLimits{MaxBytes: 1000}}, 0, ErrQuotaExceeded, false},
+		{"last video", Quota{VideoCount: 1, Limits: QuotaLimits{MaxVideos: 2}}, 10, nil, false},
+		{"video limit", Quota{VideoCount: 2, Limits: QuotaLimits{MaxVideos: 2}}, 10, ErrQuotaExceeded, true},
+		{"video limit before bytes", Quota{BytesUsed: 1000, VideoCount: 2, Limits: QuotaLimits{MaxBytes: 1000, MaxVideos: 2}}, 10, ErrQuotaExceeded, true},
+	}
+	for _, tt := range tests {
+		err := tt.quota.Fits(tt.size)
+		if !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+			t.Errorf("%s: Fits(%d) = %v, want %v", tt.name, tt.size, err, tt.want)
+		}
+		if errors.Is(err, ErrVideoLimitReached) != tt.videos {
+			t.Errorf("%s: Fits(%d) = %v, video limit %v", tt.name, tt.size, err, tt.videos)
+		}
+	}
+}
+
+func TestQuotaAllowsUpload(t *testing.T) {
+	tests := []struct {
+		name  string
+		quota Quota
+		size  int64
+		ok    bool
+	}{
+		{"unlimited", Quota{}, 1 << 40, true},
+		{"largest file", Quota{Limits: QuotaLimits{MaxFileBytes: 100}}, 100, true},
+		{"file too large", Quota{Limits: QuotaLimits{MaxFileBytes: 100}}, 101, false},
+		{"unknown size", Quota{Limits: QuotaLimits{MaxFileBytes: 100}}, 0, true},
+		{"file fits, total does not", Quota{BytesUsed: 950, Limits: QuotaLimits{MaxBytes: 1000, MaxFileBytes: 100}}, 100, false},
+		{"video limit", Quota{VideoCount: 1, Limits: QuotaLimits{MaxVideos: 1}}, 0, false},
+	}
+	for _, tt := range tests {
+		err := tt.quota.AllowsUpload(tt.size)
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrQuotaExceeded)) {
+			t.Errorf("%s: AllowsUpload(%d) = %v, want ok %v", tt.name, tt.size, err, tt.ok)
+		}
+	}
+}
+
+func TestQuotaUploadLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		quota Quota
+		want  int64
+	}{
+		{"unlimited", Quota{BytesUsed: 500}, 0},
+		{"file limit", Quota{Limits: QuotaLimits{MaxFileBytes: 100}}, 100},
+		{"room left", Quota{BytesUsed: 400, Limits: QuotaLimits{MaxBytes: 1000}}, 600},
+		{"room below file limit", Quota{BytesUsed: 950, Limits: QuotaLimits{MaxBytes: 1000, MaxFileBytes: 100}}, 50},
+		{"file limit below room", Quota{BytesUsed: 400, Limits: QuotaLimits{MaxBytes: 1000, MaxFileBytes: 100}}, 100},
+	}
+	for _, tt := range tests {
+		if got := tt.quota.UploadLimit(); got != tt.want {
+			t.Errorf("%s: UploadLimit = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
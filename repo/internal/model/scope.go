@@ -0,0 +1,50 @@
+package model
+
+// Scope is a permission checked by the gateway before it routes a request
+type Scope string
+
+const (
+	ScopeVideosRead   Scope = "videos:read"   // list, fetch, download and play videos
+	ScopeVideosUpload Scope = "videos:upload" // upload videos
+	ScopeVideosUpdate Scope = "videos:update" // change the visibility of videos and revoke playback
+	ScopeVideosDelete Scope = "videos:delete" // trash and restore videos
+	ScopeAdminUsers   Scope = "admin:users"   // list users and change their roles
+	ScopeAdminStorage Scope = "admin:storage" // run storage maintenance
+)
+
+// IsValid reports whether s is a known scope
+func (s Scope) IsValid() bool {
+	switch s {
+	case ScopeVideosRead, ScopeVideosUpload, ScopeVideosUpdate, ScopeVideosDelete,
+		ScopeAdminUsers, ScopeAdminStorage:
+		return true
+	}
+	return false
+}
+
+// UserRole is a named set of scopes
+type UserRole string
+
+const (
+	RoleAdmin    UserRole = "admin"    // every scope
+	RoleUploader UserRole = "uploader" // manages their own videos
+	RoleViewer   UserRole = "viewer"   // watches videos shared with them
+)
+
+var roleScopes = map[UserRole][]Scope{
+	RoleViewer:   {ScopeVideosRead},
+	RoleUploader: {ScopeVideosRead, ScopeVideosUpload, ScopeVideosUpdate, ScopeVideosDelete},
+	RoleAdmin: {ScopeVideosRead, ScopeVideosUpload, ScopeVideosUpdate, ScopeVideosDelete,
+		ScopeAdminUsers, ScopeAdminStorage},
+}
+
+// IsValid reports whether r is a known role
+func (r UserRole) IsValid() bool {
+	_, ok := roleScopes[r]
+	return ok
+}
+
+// Scopes returns the scopes the role grants
+func (r UserRole) Scopes() []Scope {
+	return roleScopes[r]
+}
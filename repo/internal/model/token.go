@@ -0,0 +1,29 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUserTokenInvalid is returned for unknown, used and expired tokens
+var ErrUserTokenInvalid = errors.New("token invalid, used or expired")
+
+// TokenPurpose is what a mailed token proves
+type TokenPurpose string
+
+const (
+	PurposeVerifyEmail   TokenPurpose = "verify_email"   // the user owns the email address
+	PurposeResetPassword TokenPurpose = "reset_password" // the user may choose a new password
+)
+
+// UserToken is a single-use token sent to a user by email. Only the
+// SHA-256 hash of the token is stored.
+type UserToken struct {
+	ID        string       `json:"id" db:"id"`
+	UserID    string       `json:"user_id" db:"user_id"`
+	Purpose   TokenPurpose `json:"purpose" db:"purpose"`
+	TokenHash string       `json:"-" db:"token_hash"`
+	ExpiresAt time.Time    `json:"expires_at" db:"expires_at"`
+	UsedAt    *time.Time   `json:"used_at,omitempty" db:"used_at"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrTotpNotEnrolled     = errors.New("two-factor authentication is not enabled")
+	ErrTotpAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrTotpInvalidCode     = errors.New("invalid two-factor code")
+	ErrTotpNotConfigured   = errors.New("two-factor authentication is not configured on this server")
+	ErrTotpEnrollmentStale = errors.New("no pending two-factor enrollment")
+)
+
+// UserTotp is a user's TOTP secret, encrypted at rest. EnabledAt is nil
+// while enrollment waits for its first code.
+type UserTotp struct {
+	UserID          string     `json:"user_id" db:"user_id"`
+	SecretEncrypted string     `json:"-" db:"secret_encrypted"`
+	EnabledAt       *time.Time `json:"enabled_at,omitempty" db:"enabled_at"`
+	LastUsedStep    int64      `json:"-" db:"last_used_step"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Enabled reports whether the second factor is required at login
+func (t *UserTotp) Enabled() bool {
+	return t.EnabledAt != nil
+}
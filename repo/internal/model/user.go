@@ -7,5 +7,57 @@ type User struct {
 	Email     string    `sql:"email"`
 	Username  string    `sql:"username"`
 	Password  string    `sql:"password"`
+	Role      UserRole  `sql:"role"`
+	Scopes    []string  `sql:"scopes"` // granted on top of the role
 	CreatedAt time.Time `sql:"created_at"`
+
+	EmailVerifiedAt *time.Time `sql:"email_verified_at"`
+	DisplayName     string     `sql:"display_name"`
+	AvatarURL       string     `sql:"avatar_url"`
+	TotpEnabled     bool       `sql:"totp_enabled"` // derived from user_totp
+}
+
+// ProfileUpdate lists the profile fields to change, nil fields are kept
+type ProfileUpdate struct {
+	DisplayName *string
+	Email       *string
+	AvatarURL   *string
+}
+
+// EmailVerified reports whether the user confirmed their email address
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// EffectiveScopes returns the scopes of the user's role and extra grants,
+// without duplicates. Users who have not verified their email cannot upload.
+func (u *User) EffectiveScopes() []string {
+	seen := map[string]bool{}
+	if !u.EmailVerified() {
+		seen[string(ScopeVideosUpload)] = true
+	}
+	var scopes []string
+	for _, s := range u.Role.Scopes() {
+		if !seen[string(s)] {
+			seen[string(s)] = true
+			scopes = append(scopes, string(s))
+		}
+	}
+	for _, s := range u.Scopes {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// HasScope reports whether the user holds scope
+func (u *User) HasScope(scope Scope) bool {
+	for _, s := range u.EffectiveScopes() {
+		if s == string(scope) {
+			return true
+		}
+	}
+	return false
 }
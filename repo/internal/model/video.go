@@ -1,17 +1,158 @@
 package model
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
 
+// VideoStatus is the processing state of a video
+type VideoStatus string
+
+const (
+	VideoStatusUploading   VideoStatus = "uploading"
+	VideoStatusTranscoding VideoStatus = "transcoding"
+	VideoStatusReady       VideoStatus = "ready"
+	VideoStatusFailed      VideoStatus = "failed"
+	VideoStatusRejected    VideoStatus = "rejected" // flagged by NSFW screening
+)
+
+var ErrInvalidStatusTransition = errors.New("invalid video status transition")
+
+// videoStatusTransitions lists the statuses each status may move to
+var videoStatusTransitions = map[VideoStatus][]VideoStatus{
+	VideoStatusUploading:   {VideoStatusTranscoding, VideoStatusFailed},
+	VideoStatusTranscoding: {VideoStatusReady, VideoStatusFailed, VideoStatusRejected},
+	VideoStatusReady:       {VideoStatusRejected},
+	VideoStatusFailed:      {VideoStatusTranscoding},
+	VideoStatusRejected:    {},
+}
+
+// IsValid reports whether s is a known status
+func (s VideoStatus) IsValid() bool {
+	_, ok := videoStatusTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether moving from s to next is allowed
+func (s VideoStatus) CanTransitionTo(next VideoStatus) bool {
+	for _, allowed := range videoStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// VideoVisibility controls who besides the owner may see a video
+type VideoVisibility string
+
+const (
+	VisibilityOwner  VideoVisibility = "owner"  // only the owner
+	VisibilityShared VideoVisibility = "shared" // the owner and users it is shared with
+	VisibilityPublic VideoVisibility = "public" // every authenticated user
+)
+
+var (
+	// ErrVideoNotFound is returned for videos that do not exist or that the
+	// caller may not see, so hidden videos are indistinguishable from missing ones
+	ErrVideoNotFound = errors.New("video not found")
+	// ErrVideoAccessDenied is returned when the caller can see a video but
+	// may not change it
+	ErrVideoAccessDenied = errors.New("video access denied")
+)
+
+// IsValid reports whether v is a known visibility
+func (v VideoVisibility) IsValid() bool {
+	switch v {
+	case VisibilityOwner, VisibilityShared, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
 type Video struct {
-	ID          string    `json:"id" db:"id"`
-	UserID      string    `json:"user_id" db:"user_id"`
-	Title       string    `json:"title" db:"title"`
-	Description string    `json:"description" db:"description"`
-	FileName    string    `json:"file_name" db:"file_name"` // Original file name in MinIO
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ID              string          `json:"id" db:"id"`
+	UserID          string          `json:"user_id" db:"user_id"`
+	Title           string          `json:"title" db:"title"`
+	Description     string          `json:"description" db:"description"`
+	FileName        string          `json:"file_name" db:"file_name"` // Original file name in MinIO
+	Status          VideoStatus     `json:"status" db:"status"`
+	StatusReason    string          `json:"status_reason" db:"status_reason"`
+	StatusUpdatedAt time.Time       `json:"status_updated_at" db:"status_updated_at"`
+	Visibility      VideoVisibility `json:"visibility" db:"visibility"`
+	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
+	DeletedAt       *time.Time      `json:"deleted_at,omitempty" db:"deleted_at"` // set while the video is in the trash
+	PurgeAttempts   int             `json:"-" db:"purge_attempts"`
+	Media           MediaInfo       `json:"media"`
+	FileSize        int64           `json:"file_size" db:"file_size"` // size of the stored original
+}
+
+// MediaInfo describes the original file of a video. Only Container is set
+// for uploads the gateway did not probe, and none for older videos.
+type MediaInfo struct {
+	Container  string  `json:"container" db:"container"`
+	Duration   float64 `json:"duration" db:"duration"` // seconds
+	VideoCodec string  `json:"video_codec" db:"video_codec"`
+	AudioCodec string  `json:"audio_codec" db:"audio_codec"`
+	Width      int     `json:"width" db:"width"`
+	Height     int     `json:"height" db:"height"`
+	FrameRate  float64 `json:"frame_rate" db:"frame_rate"`
+}
+
+// VideoSortField is a column videos can be listed by
+type VideoSortField string
+
+const (
+	VideoSortCreatedAt VideoSortField = "created_at"
+	VideoSortUpdatedAt VideoSortField = "updated_at" // last status change
+	VideoSortTitle     VideoSortField = "title"
+)
+
+// IsValid reports whether f is a supported sort field
+func (f VideoSortField) IsValid() bool {
+	switch f {
+	case VideoSortCreatedAt, VideoSortUpdatedAt, VideoSortTitle:
+		return true
+	}
+	return false
+}
+
+// VideoListFilter selects one page of videos. After and AfterID hold the
+// sort value and ID of the last video of the previous page.
+type VideoListFilter struct {
+	UserID        string
+	ViewerID      string // when set, only videos this user may see are listed
+	Query         string // full-text search over title and description
+	Status        VideoStatus
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	SortBy        VideoSortField
+	Descending    bool
+	Limit         int
+	After         string
+	AfterID       string
+}
+
+// SortValue returns the value of field for v, as stored in a cursor
+func (v *Video) SortValue(field VideoSortField) string {
+	switch field {
+	case VideoSortUpdatedAt:
+		return v.StatusUpdatedAt.Format(time.RFC3339Nano)
+	case VideoSortTitle:
+		return v.Title
+	}
+	return v.CreatedAt.Format(time.RFC3339Nano)
+}
+
+// VideoStatusChange is one entry of a video's status history
+type VideoStatusChange struct {
+	ID         int64       `json:"id" db:"id"`
+	VideoID    string      `json:"video_id" db:"video_id"`
+	FromStatus VideoStatus `json:"from_status" db:"from_status"`
+	ToStatus   VideoStatus `json:"to_status" db:"to_status"`
+	Reason     string      `json:"reason" db:"reason"`
+	ChangedAt  time.Time   `json:"changed_at" db:"changed_at"`
 }
 
 // GetMasterPlaylistPath returns the path to the master HLS playlist
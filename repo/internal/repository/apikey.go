@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *model.APIKey) error
+	ListAPIKeys(ctx context.Context, userID string) ([]*model.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, tokenHash string) (*model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, keyID string) error
+	TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error
+}
+
+type apiKeyRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(pool *pgxpool.Pool) APIKeyRepository {
+	return &apiKeyRepo{db: pool}
+}
+
+// apiKeyColumns is the column list matched by scanAPIKey
+const apiKeyColumns = `id, user_id, name, prefix, token_hash, scopes, expires_at, last_used_at, revoked_at, created_at`
+
+func scanAPIKey(row pgx.Row) (*model.APIKey, error) {
+	var k model.APIKey
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.TokenHash, &k.Scopes,
+		&k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *apiKeyRepo) CreateAPIKey(ctx context.Context, key *model.APIKey) error {
+	start := time.Now()
+
+	logger.Logger.Info("Creating API key in database",
+		"key_id", key.ID,
+		"user_id", key.UserID,
+	)
+
+	query := `
+INSERT INTO api_keys (id, user_id, name, prefix, token_hash, scopes, expires_at, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := r.db.Exec(ctx, query,
+		key.ID, key.UserID, key.Name, key.Prefix, key.TokenHash, key.Scopes, key.ExpiresAt, key.CreatedAt,
+	)
+
+	logger.LogDatabaseOperation(ctx, "insert", "api_keys", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to insert API key",
+			"key_id", key.ID,
+			"user_id", key.UserID,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("insert api key failed: %w", err)
+	}
+	return nil
+}
+
+// ListAPIKeys returns every key of a user, revoked and expired ones included,
+// newest first
+func (r *apiKeyRepo) ListAPIKeys(ctx context.Context, userID string) ([]*model.APIKey, error) {
+	start := time.Now()
+
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id=$1 ORDER BY created_at DESC, id`
+	rows, err := r.db.Query(ctx, query, userID)
+
+	logger.LogDatabaseOperation(ctx, "select", "api_keys", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to query API keys",
+			"user_id", userID,
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("query api keys failed: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*model.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api key failed: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate api keys failed: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *apiKeyRepo) GetAPIKeyByHash(ctx context.Context, tokenHash string) (*model.APIKey, error) {
+	start := time.Now()
+
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE token_hash=$1`
+	k, err := scanAPIKey(r.db.QueryRow(ctx, query, tokenHash))
+
+	logger.LogDatabaseOperation(ctx, "select", "api_keys", time.Since(start), err)
+
+	if err != nil {
+		return nil, fmt.Errorf("get api key failed: %w", err)
+	}
+	return k, nil
+}
+
+// RevokeAPIKey revokes a key owned by userID. Revoking a key twice, or a
+// key of another user, returns pgx.ErrNoRows.
+func (r *apiKeyRepo) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	start := time.Now()
+
+	logger.Logger.Info("Revoking API key in database",
+		"key_id", keyID,
+		"user_id", userID,
+	)
+
+	tag, err := r.db.Exec(ctx,
+		`UPDATE api_keys SET revoked_at=now() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`,
+		keyID, userID,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+
+	logger.LogDatabaseOperation(ctx, "update", "api_keys", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Warn("Failed to revoke API key",
+			"key_id", keyID,
+			"user_id", userID,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("revoke api key failed: %w", err)
+	}
+	return nil
+}
+
+// TouchAPIKey records when a key was last used. Updates are throttled to
+// one a minute so busy keys do not write on every request.
+func (r *apiKeyRepo) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	start := time.Now()
+
+	_, err := r.db.Exec(ctx,
+		`UPDATE api_keys SET last_used_at=$2
+		 WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < $2 - interval '1 minute')`,
+		keyID, usedAt,
+	)
+
+	logger.LogDatabaseOperation(ctx, "update", "api_keys", time.Since(start), err)
+
+	if err != nil {
+		return fmt.Errorf("touch api key failed: %w", err)
+	}
+	return nil
+}
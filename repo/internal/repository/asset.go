@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	uuid "github.com/satori/go.uuid"
+)
+
+type AssetRepository interface {
+	UpsertAssets(ctx context.Context, assets []*model.VideoAsset) ([]*model.VideoAsset, error)
+	GetAssetByObjectKey(ctx context.Context, objectKey string) (*model.VideoAsset, error)
+	GetAssetsByVideo(ctx context.Context, videoID string) ([]*model.VideoAsset, error)
+	UpdateAssetSize(ctx context.Context, objectKey string, byteSize int64) error
+}
+
+type assetRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewAssetRepository(pool *pgxpool.Pool) AssetRepository {
+	return &assetRepo{db: pool}
+}
+
+// assetColumns is the column list matched by scanAsset
+const assetColumns = `id, video_id, kind, rendition, codec, bitrate, width, height, byte_size, object_key, created_at`
+
+func scanAsset(row pgx.Row) (*model.VideoAsset, error) {
+	var a model.VideoAsset
+	err := row.Scan(&a.ID, &a.VideoID, &a.Kind, &a.Rendition, &a.Codec, &a.Bitrate,
+		&a.Width, &a.Height, &a.ByteSize, &a.ObjectKey, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// UpsertAssets records assets in one transaction. Registering an object key
+// again updates its description, so callers can safely retry.
+func (r *assetRepo) UpsertAssets(ctx context.Context, assets []*model.VideoAsset) ([]*model.VideoAsset, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Upserting video assets in database",
+		"asset_count", len(assets),
+	)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin asset upsert failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+INSERT INTO video_assets (id, video_id, kind, rendition, codec, bitrate, width, height, byte_size, object_key, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT (object_key) DO UPDATE SET
+	kind = EXCLUDED.kind,
+	rendition = EXCLUDED.rendition,
+	codec = EXCLUDED.codec,
+	bitrate = EXCLUDED.bitrate,
+	width = EXCLUDED.width,
+	height = EXCLUDED.height,
+	byte_size = GREATEST(video_assets.byte_size, EXCLUDED.byte_size)
+WHERE video_assets.video_id = EXCLUDED.video_id
+RETURNING ` + assetColumns
+
+	saved := make([]*model.VideoAsset, 0, len(assets))
+	for _, asset := range assets {
+		a, err := scanAsset(tx.QueryRow(ctx, query,
+			uuid.NewV4().String(), asset.VideoID, asset.Kind, asset.Rendition, asset.Codec, asset.Bitrate,
+			asset.Width, asset.Height, asset.ByteSize, asset.ObjectKey, time.Now(),
+		))
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = fmt.Errorf("object key is registered to another video")
+		}
+		if err != nil {
+			logger.LogDatabaseOperation(ctx, "upsert", "video_assets", time.Since(start), err)
+			return nil, fmt.Errorf("upsert asset failed: %w", err)
+		}
+		saved = append(saved, a)
+	}
+
+	err = tx.Commit(ctx)
+
+	logger.LogDatabaseOperation(ctx, "upsert", "video_assets", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to upsert video assets",
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("upsert assets failed: %w", err)
+	}
+
+	return saved, nil
+}
+
+func (r *assetRepo) GetAssetByObjectKey(ctx context.Context, objectKey string) (*model.VideoAsset, error) {
+	start := time.Now()
+
+	query := `SELECT ` + assetColumns + ` FROM video_assets WHERE object_key=$1`
+	a, err := scanAsset(r.db.QueryRow(ctx, query, objectKey))
+
+	logger.LogDatabaseOperation(ctx, "select", "video_assets", time.Since(start), err)
+
+	if err != nil {
+		return nil, fmt.Errorf("get asset failed: %w", err)
+	}
+	return a, nil
+}
+
+func (r *assetRepo) GetAssetsByVideo(ctx context.Context, videoID string) ([]*model.VideoAsset, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Fetching video assets from database",
+		"video_id", videoID,
+	)
+
+	query := `SELECT ` + assetColumns + ` FROM video_assets WHERE video_id=$1 ORDER BY kind, rendition, object_key`
+	rows, err := r.db.Query(ctx, query, videoID)
+
+	logger.LogDatabaseOperation(ctx, "select", "video_assets", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to query video assets",
+			"video_id", videoID,
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("query assets failed: %w", err)
+	}
+	defer rows.Close()
+
+	var assets []*model.VideoAsset
+	for rows.Next() {
+		a, err := scanAsset(rows)
+		if err != nil {
+			logger.Logger.Error("Failed to scan asset row",
+				"video_id", videoID,
+				"error", err.Error(),
+			)
+			return nil, err
+		}
+		assets = append(assets, a)
+	}
+
+	return assets, rows.Err()
+}
+
+func (r *assetRepo) UpdateAssetSize(ctx context.Context, objectKey string, byteSize int64) error {
+	start := time.Now()
+
+	_, err := r.db.Exec(ctx, `UPDATE video_assets SET byte_size=$2 WHERE object_key=$1`, objectKey, byteSize)
+
+	logger.LogDatabaseOperation(ctx, "update", "video_assets", time.Since(start), err)
+
+	if err != nil {
+		return fmt.Errorf("update asset size failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+type IdentityRepository interface {
+	GetIdentity(ctx context.Context, provider, subject string) (*model.UserIdentity, error)
+	CreateIdentity(ctx context.Context, identity *model.UserIdentity) error
+	// CreateUserWithIdentity inserts a new user and its first identity in
+	// one transaction
+	CreateUserWithIdentity(ctx context.Context, user *model.User, identity *model.UserIdentity) error
+	TouchIdentity(ctx context.Context, identityID string, at time.Time) error
+}
+
+type identityRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewIdentityRepository(pool *pgxpool.Pool) IdentityRepository {
+	return &identityRepo{db: pool}
+}
+
+// identityColumns is the column list matched by scanIdentity
+const identityColumns = `id, user_id, provider, subject, email, created_at, last_login_at`
+
+func scanIdentity(row pgx.Row) (*model.UserIdentity, error) {
+	var i model.UserIdentity
+	err := row.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt, &i.LastLoginAt)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+const insertIdentityQuery = `INSERT INTO user_identities (id, user_id, provider, subject, email, created_at, last_login_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+func (r *identityRepo) GetIdentity(ctx context.Context, provider, subject string) (*model.UserIdentity, error) {
+	start := time.Now()
+
+	query := `SELECT ` + identityColumns + ` FROM user_identities WHERE provider = $1 AND subject = $2`
+	identity, err := scanIdentity(r.db.QueryRow(ctx, query, provider, subject))
+
+	logger.LogDatabaseOperation(ctx, "select", "user_identities", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (r *identityRepo) CreateIdentity(ctx context.Context, identity *model.UserIdentity) error {
+	start := time.Now()
+
+	logger.Logger.Info("Linking identity in database",
+		"user_id", identity.UserID,
+		"provider", identity.Provider,
+	)
+
+	_, err := r.db.Exec(ctx, insertIdentityQuery,
+		identity.ID, identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.CreatedAt, identity.LastLoginAt,
+	)
+
+	logger.LogDatabaseOperation(ctx, "insert", "user_identities", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to insert identity",
+			"user_id", identity.UserID,
+			"provider", identity.Provider,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("insert identity failed: %w", err)
+	}
+	return nil
+}
+
+func (r *identityRepo) CreateUserWithIdentity(ctx context.Context, user *model.User, identity *model.UserIdentity) error {
+	start := time.Now()
+
+	logger.Logger.Info("Creating user with identity in database",
+		"username", user.Username,
+		"provider", identity.Provider,
+	)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin user insert failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO users (id, username, email, password, role, scopes, created_at, email_verified_at, display_name, avatar_url)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		user.ID, user.Username, user.Email, user.Password, user.Role, user.Scopes, user.CreatedAt, user.EmailVerifiedAt,
+		user.DisplayName, user.AvatarURL,
+	)
+	if err == nil {
+		_, err = tx.Exec(ctx, insertIdentityQuery,
+			identity.ID, identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.CreatedAt, identity.LastLoginAt,
+		)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	logger.LogDatabaseOperation(ctx, "insert", "users", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to insert user with identity",
+			"username", user.Username,
+			"provider", identity.Provider,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("insert user with identity failed: %w", err)
+	}
+	return nil
+}
+
+func (r *identityRepo) TouchIdentity(ctx context.Context, identityID string, at time.Time) error {
+	start := time.Now()
+
+	_, err := r.db.Exec(ctx, `UPDATE user_identities SET last_login_at=$2 WHERE id=$1`, identityID, at)
+
+	logger.LogDatabaseOperation(ctx, "update", "user_identities", time.Since(start), err)
+
+	if err != nil {
+		return fmt.Errorf("update identity failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+// QuotaRepository stores the limits of users and reads their usage. The
+// usage itself is counted by the video repository as videos change.
+type QuotaRepository interface {
+	// GetQuota returns the user's usage and overrides, all zero for users
+	// that never stored anything
+	GetQuota(ctx context.Context, userID string) (*model.Quota, error)
+	SetQuotaOverrides(ctx context.Context, userID string, overrides model.QuotaOverrides) (*model.Quota, error)
+}
+
+// quotaColumns is the column list matched by scanQuota
+const quotaColumns = `user_id, bytes_used, video_count, max_bytes, max_videos, max_file_bytes, updated_at`
+
+func scanQuota(row pgx.Row) (*model.Quota, error) {
+	var q model.Quota
+	err := row.Scan(&q.UserID, &q.BytesUsed, &q.VideoCount,
+		&q.Overrides.MaxBytes, &q.Overrides.MaxVideos, &q.Overrides.MaxFileBytes, &q.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+type quotaRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewQuotaRepository(pool *pgxpool.Pool) QuotaRepository {
+	return &quotaRepo{db: pool}
+}
+
+func (r *quotaRepo) GetQuota(ctx context.Context, userID string) (*model.Quota, error) {
+	start := time.Now()
+
+	q, err := scanQuota(r.db.QueryRow(ctx, `SELECT `+quotaColumns+` FROM user_quotas WHERE user_id = $1`, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		q, err = &model.Quota{UserID: userID}, nil
+	}
+
+	logger.LogDatabaseOperation(ctx, "select", "user_quotas", time.Since(start), err)
+
+	if err != nil {
+		return nil, fmt.Errorf("get quota failed: %w", err)
+	}
+	return q, nil
+}
+
+func (r *quotaRepo) SetQuotaOverrides(ctx context.Context, userID string, overrides model.QuotaOverrides) (*model.Quota, error) {
+	start := time.Now()
+
+	q, err := scanQuota(r.db.QueryRow(ctx,
+		`INSERT INTO user_quotas (user_id, max_bytes, max_videos, max_file_bytes, updated_at) VALUES ($1, $2, $3, $4, now())
+		 ON CONFLICT (user_id) DO UPDATE SET max_bytes = EXCLUDED.max_bytes, max_videos = EXCLUDED.max_videos,
+		     max_file_bytes = EXCLUDED.max_file_bytes, updated_at = now()
+		 RETURNING `+quotaColumns,
+		userID, overrides.MaxBytes, overrides.MaxVideos, overrides.MaxFileBytes,
+	))
+
+	logger.LogDatabaseOperation(ctx, "upsert", "user_quotas", time.Since(start), err)
+
+	if err != nil {
+		return nil, fmt.Errorf("set quota failed: %w", err)
+	}
+	return q, nil
+}
+
+// addUsage changes the usage of a user within tx by bytes and videos,
+// which are negative when originals are released
+func addUsage(ctx context.Context, tx pgx.Tx, userID string, bytes int64, videos int) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO user_quotas (user_id, bytes_used, video_count, updated_at) VALUES ($1, GREATEST($2::bigint, 0), GREATEST($3::integer, 0), now())
+		 ON CONFLICT (user_id) DO UPDATE SET bytes_used = GREATEST(user_quotas.bytes_used + $2, 0),
+		     video_count = GREATEST(user_quotas.video_count + $3, 0), updated_at = now()`,
+		userID, bytes, videos,
+	)
+	if err != nil {
+		return fmt.Errorf("update storage usage failed: %w", err)
+	}
+	return nil
+}
+
+// reserveUsage is addUsage for usage that has to fit the user's limits,
+// which the caller resolved. The row is only changed when it does, so
+// uploads that race each other cannot both take the last of the room.
+// A count of 0 videos only checks the bytes. It fails with
+// model.ErrQuotaExceeded, or model.ErrVideoLimitReached for the count.
+func reserveUsage(ctx context.Context, tx pgx.Tx, userID string, bytes int64, videos int, limits model.QuotaLimits) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO user_quotas (user_id, updated_at) VALUES ($1, now()) ON CONFLICT (user_id) DO NOTHING`, userID)
+	if err != nil {
+		return fmt.Errorf("update storage usage failed: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE user_quotas SET bytes_used = bytes_used + $2, video_count = video_count + $3, updated_at = now()
+		 WHERE user_id = $1
+		   AND ($3 = 0 OR $5::integer = 0 OR video_count + $3 <= $5)
+		   AND ($4::bigint = 0 OR (bytes_used + $2 <= $4 AND bytes_used < $4))`,
+		userID, bytes, videos, limits.MaxBytes, limits.MaxVideos,
+	)
+	if err != nil {
+		return fmt.Errorf("update storage usage failed: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
+		return nil
+	}
+
+	// Nothing changed, read the usage to tell which limit was hit
+	q, err := scanQuota(tx.QueryRow(ctx, `SELECT `+quotaColumns+` FROM user_quotas WHERE user_id = $1`, userID))
+	if err != nil {
+		return fmt.Errorf("get quota failed: %w", err)
+	}
+	q.Limits = limits
+	if videos > 0 {
+		err = q.Fits(bytes)
+	} else {
+		err = q.FitsBytes(bytes)
+	}
+	if err == nil {
+		// The usage changed between the statements
+		err = fmt.Errorf("%w: %d of %d bytes used", model.ErrQuotaExceeded, q.BytesUsed, limits.MaxBytes)
+	}
+	return err
+}
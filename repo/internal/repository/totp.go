@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	uuid "github.com/satori/go.uuid"
+)
+
+type TotpRepository interface {
+	GetTotp(ctx context.Context, userID string) (*model.UserTotp, error)
+	// SaveTotpEnrollment stores a pending secret, replacing any earlier
+	// pending one. It returns pgx.ErrNoRows when 2FA is already enabled.
+	SaveTotpEnrollment(ctx context.Context, userID, secretEncrypted string) error
+	// EnableTotp completes a pending enrollment and stores its recovery codes
+	EnableTotp(ctx context.Context, userID string, step int64, codeHashes []string) error
+	// UseTotpStep records the time step of an accepted code. It reports
+	// false when that step or a later one was already used.
+	UseTotpStep(ctx context.Context, userID string, step int64) (bool, error)
+	// UseRecoveryCode spends a recovery code, reporting false for unknown
+	// and used codes
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error
+	CountRecoveryCodes(ctx context.Context, userID string) (int, error)
+	DeleteTotp(ctx context.Context, userID string) error
+}
+
+type totpRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewTotpRepository(pool *pgxpool.Pool) TotpRepository {
+	return &totpRepo{db: pool}
+}
+
+func (r *totpRepo) GetTotp(ctx context.Context, userID string) (*model.UserTotp, error) {
+	start := time.Now()
+
+	var t model.UserTotp
+	err := r.db.QueryRow(ctx,
+		`SELECT user_id, secret_encrypted, enabled_at, last_used_step, created_at FROM user_totp WHERE user_id = $1`,
+		userID,
+	).Scan(&t.UserID, &t.SecretEncrypted, &t.EnabledAt, &t.LastUsedStep, &t.CreatedAt)
+
+	logger.LogDatabaseOperation(ctx, "select", "user_totp", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *totpRepo) SaveTotpEnrollment(ctx context.Context, userID, secretEncrypted string) error {
+	start := time.Now()
+
+	tag, err := r.db.Exec(ctx,
+		`INSERT INTO user_totp (user_id, secret_encrypted, created_at) VALUES ($1, $2, now())
+		 ON CONFLICT (user_id) DO UPDATE SET secret_encrypted = EXCLUDED.secret_encrypted, last_used_step = 0, created_at = now()
+		 WHERE user_totp.enabled_at IS NULL`,
+		userID, secretEncrypted,
+	)
+
+	logger.LogDatabaseOperation(ctx, "upsert", "user_totp", time.Since(start), err)
+
+	if err != nil {
+		return fmt.Errorf("save totp enrollment failed: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// insertRecoveryCodes replaces the user's recovery codes within tx
+func insertRecoveryCodes(ctx context.Context, tx pgx.Tx, userID string, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO totp_recovery_codes (id, user_id, code_hash, created_at) VALUES ($1, $2, $3, now())`,
+			uuid.NewV4().String(), userID, hash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *totpRepo) EnableTotp(ctx context.Context, userID string, step int64, codeHashes []string) error {
+	start := time.Now()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin totp enable failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE user_totp SET enabled_at = now(), last_used_step = $2 WHERE user_id = $1 AND enabled_at IS NULL`,
+		userID, step,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	if err == nil {
+		err = insertRecoveryCodes(ctx, tx, userID, codeHashes)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	logger.LogDatabaseOperation(ctx, "update", "user_totp", time.Since(start), err)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("enable totp failed: %w", err)
+	}
+	return nil
+}
+
+func (r *totpRepo) UseTotpStep(ctx context.Context, userID string, step int64) (bool, error) {
+	start := time.Now()
+
+	tag, err := r.db.Exec(ctx,
+		`UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`,
+		userID, step,
+	)
+
+	logger.LogDatabaseOperation(ctx, "update", "user_totp", time.Since(start), err)
+
+	if err != nil {
+		return false, fmt.Errorf("record totp step failed: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *totpRepo) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	start := time.Now()
+
+	tag, err := r.db.Exec(ctx,
+		`UPDATE totp_recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, codeHash,
+	)
+
+	logger.LogDatabaseOperation(ctx, "update", "totp_recovery_codes", time.Since(start), err)
+
+	if err != nil {
+		return false, fmt.Errorf("use recovery code failed: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *totpRepo) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	start := time.Now()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin recovery code insert failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = insertRecoveryCodes(ctx, tx, userID, codeHashes)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	logger.LogDatabaseOperation(ctx, "insert", "totp_recovery_codes", time.Since(start), err)
+
+	if err != nil {
+		return fmt.Errorf("replace recovery codes failed: %w", err)
+	}
+	return nil
+}
+
+func (r *totpRepo) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	start := time.Now()
+
+	var n int
+	err := r.db.QueryRow(ctx,
+		`SELECT count(*) FROM totp_recovery_codes WHERE user_id = $1 AND used_at IS NULL`,
+		userID,
+	).Scan(&n)
+
+	logger.LogDatabaseOperation(ctx, "select", "totp_recovery_codes", time.Since(start), err)
+
+	if err != nil {
+		return 0, fmt.Errorf("count recovery codes failed: %w", err)
+	}
+	return n, nil
+}
+
+func (r *totpRepo) DeleteTotp(ctx context.Context, userID string) error {
+	start := time.Now()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin totp delete failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	logger.LogDatabaseOperation(ctx, "delete", "user_totp", time.Since(start), err)
+
+	if err != nil {
+		return fmt.Errorf("delete totp failed: %w", err)
+	}
+	return nil
+}
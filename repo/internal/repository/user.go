@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lumbrjx/codek7/repo/internal/model"
 	"github.com/lumbrjx/codek7/repo/pkg/logger"
@@ -14,6 +15,15 @@ import (
 type UserRepository interface {
 	CreateUser(ctx context.Context, password, email, username string) (*model.User, error)
 	GetUser(ctx context.Context, userID string) (*model.User, error)
+	GetUserByID(ctx context.Context, userID string) (*model.User, error)
+	ListUsers(ctx context.Context, limit, offset int) ([]*model.User, error)
+	SetUserRole(ctx context.Context, userID string, role model.UserRole, scopes []string) (*model.User, error)
+	PromoteAdmins(ctx context.Context, usernames []string) (int64, error)
+	GetUserByEmail(ctx context.Context, email string) (*model.User, error)
+	MarkEmailVerified(ctx context.Context, userID string) (*model.User, error)
+	UpdatePassword(ctx context.Context, userID, password string) (*model.User, error)
+	UpdateProfile(ctx context.Context, userID string, update model.ProfileUpdate) (*model.User, error)
+	DeleteUser(ctx context.Context, userID string) error
 }
 
 type userRepo struct {
@@ -24,6 +34,20 @@ func NewUserRepository(pool *pgxpool.Pool) UserRepository {
 	return &userRepo{db: pool}
 }
 
+// userColumns is the column list matched by scanUser
+const userColumns = `id, username, email, password, role, scopes, created_at, email_verified_at, display_name, avatar_url,
+	EXISTS (SELECT 1 FROM user_totp t WHERE t.user_id = users.id AND t.enabled_at IS NOT NULL)`
+
+func scanUser(row pgx.Row) (*model.User, error) {
+	var user model.User
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Role, &user.Scopes, &user.CreatedAt, &user.EmailVerifiedAt,
+		&user.DisplayName, &user.AvatarURL, &user.TotpEnabled)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (r *userRepo) CreateUser(ctx context.Context, password, email, username string) (*model.User, error) {
 	start := time.Now()
 
@@ -37,11 +61,13 @@ func (r *userRepo) CreateUser(ctx context.Context, password, email, username str
 		Username:  username,
 		Password:  password,
 		Email:     email,
+		Role:      model.RoleUploader,
+		Scopes:    []string{},
 		CreatedAt: time.Now(),
 	}
 
-	query := `INSERT INTO users (id, username, email, password, created_at) VALUES ($1, $2, $3, $4, $5)`
-	_, err := r.db.Exec(ctx, query, user.ID, user.Username, user.Email, user.Password, user.CreatedAt)
+	query := `INSERT INTO users (id, username, email, password, role, scopes, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := r.db.Exec(ctx, query, user.ID, user.Username, user.Email, user.Password, user.Role, user.Scopes, user.CreatedAt)
 
 	logger.LogDatabaseOperation(ctx, "insert", "users", time.Since(start), err)
 
@@ -69,12 +95,8 @@ func (r *userRepo) GetUser(ctx context.Context, userID string) (*model.User, err
 		"username", userID,
 	)
 
-	query := `SELECT id, username, email, password, created_at FROM users WHERE username = $1`
-	row := r.db.QueryRow(ctx, query, userID)
-
-	var user model.User
-
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.CreatedAt)
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	user, err := scanUser(r.db.QueryRow(ctx, query, userID))
 
 	logger.LogDatabaseOperation(ctx, "select", "users", time.Since(start), err)
 
@@ -91,5 +113,199 @@ func (r *userRepo) GetUser(ctx context.Context, userID string) (*model.User, err
 		"username", user.Username,
 	)
 
-	return &user, nil
+	return user, nil
+}
+
+func (r *userRepo) GetUserByID(ctx context.Context, userID string) (*model.User, error) {
+	start := time.Now()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	user, err := scanUser(r.db.QueryRow(ctx, query, userID))
+
+	logger.LogDatabaseOperation(ctx, "select", "users", time.Since(start), err)
+
+	if err != nil {
+		return nil, fmt.Errorf("get user by id failed: %w", err)
+	}
+	return user, nil
+}
+
+// ListUsers pages through users by creation time
+func (r *userRepo) ListUsers(ctx context.Context, limit, offset int) ([]*model.User, error) {
+	start := time.Now()
+
+	query := `SELECT ` + userColumns + ` FROM users ORDER BY created_at, id LIMIT $1 OFFSET $2`
+	rows, err := r.db.Query(ctx, query, limit, offset)
+
+	logger.LogDatabaseOperation(ctx, "select", "users", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to query users",
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("query users failed: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*model.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user failed: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate users failed: %w", err)
+	}
+	return users, nil
+}
+
+func (r *userRepo) SetUserRole(ctx context.Context, userID string, role model.UserRole, scopes []string) (*model.User, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Updating user role in database",
+		"user_id", userID,
+		"role", role,
+	)
+
+	query := `UPDATE users SET role=$2, scopes=$3 WHERE id=$1 RETURNING ` + userColumns
+	user, err := scanUser(r.db.QueryRow(ctx, query, userID, role, scopes))
+
+	logger.LogDatabaseOperation(ctx, "update", "users", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to update user role",
+			"user_id", userID,
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("update user role failed: %w", err)
+	}
+	return user, nil
+}
+
+// PromoteAdmins gives the admin role to the named users and returns how
+// many were changed
+func (r *userRepo) PromoteAdmins(ctx context.Context, usernames []string) (int64, error) {
+	start := time.Now()
+
+	tag, err := r.db.Exec(ctx, `UPDATE users SET role='admin' WHERE username = ANY($1) AND role <> 'admin'`, usernames)
+
+	logger.LogDatabaseOperation(ctx, "update", "users", time.Since(start), err)
+
+	if err != nil {
+		return 0, fmt.Errorf("promote admins failed: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *userRepo) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	start := time.Now()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE lower(email) = lower($1)`
+	user, err := scanUser(r.db.QueryRow(ctx, query, email))
+
+	logger.LogDatabaseOperation(ctx, "select", "users", time.Since(start), err)
+
+	if err != nil {
+		return nil, fmt.Errorf("get user by email failed: %w", err)
+	}
+	return user, nil
+}
+
+// MarkEmailVerified records the first verification, later calls keep the
+// original time
+func (r *userRepo) MarkEmailVerified(ctx context.Context, userID string) (*model.User, error) {
+	start := time.Now()
+
+	query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, now()) WHERE id=$1 RETURNING ` + userColumns
+	user, err := scanUser(r.db.QueryRow(ctx, query, userID))
+
+	logger.LogDatabaseOperation(ctx, "update", "users", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to mark email verified",
+			"user_id", userID,
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("mark email verified failed: %w", err)
+	}
+	return user, nil
+}
+
+// UpdatePassword stores a new password hash
+func (r *userRepo) UpdatePassword(ctx context.Context, userID, password string) (*model.User, error) {
+	start := time.Now()
+
+	query := `UPDATE users SET password=$2 WHERE id=$1 RETURNING ` + userColumns
+	user, err := scanUser(r.db.QueryRow(ctx, query, userID, password))
+
+	logger.LogDatabaseOperation(ctx, "update", "users", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to update password",
+			"user_id", userID,
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("update password failed: %w", err)
+	}
+	return user, nil
+}
+
+// UpdateProfile changes the fields set in update. A new email address has
+// to be verified again.
+func (r *userRepo) UpdateProfile(ctx context.Context, userID string, update model.ProfileUpdate) (*model.User, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Updating user profile in database",
+		"user_id", userID,
+	)
+
+	query := `
+UPDATE users SET
+	display_name = COALESCE($2, display_name),
+	avatar_url = COALESCE($3, avatar_url),
+	email_verified_at = CASE WHEN $4::text IS NULL OR lower($4) = lower(email) THEN email_verified_at END,
+	email = COALESCE($4, email)
+WHERE id=$1
+RETURNING ` + userColumns
+	user, err := scanUser(r.db.QueryRow(ctx, query, userID, update.DisplayName, update.AvatarURL, update.Email))
+
+	logger.LogDatabaseOperation(ctx, "update", "users", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to update user profile",
+			"user_id", userID,
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("update profile failed: %w", err)
+	}
+	return user, nil
+}
+
+// DeleteUser removes the user row. API keys, tokens and shares go with it
+// through their foreign keys; videos must be purged first so their objects
+// are not orphaned.
+func (r *userRepo) DeleteUser(ctx context.Context, userID string) error {
+	start := time.Now()
+
+	logger.Logger.Info("Deleting user from database",
+		"user_id", userID,
+	)
+
+	tag, err := r.db.Exec(ctx, `DELETE FROM users WHERE id=$1`, userID)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+
+	logger.LogDatabaseOperation(ctx, "delete", "users", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to delete user",
+			"user_id", userID,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("delete user failed: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+type UserTokenRepository interface {
+	CreateUserToken(ctx context.Context, token *model.UserToken) error
+	ConsumeUserToken(ctx context.Context, tokenHash string, purpose model.TokenPurpose) (*model.UserToken, error)
+}
+
+type userTokenRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewUserTokenRepository(pool *pgxpool.Pool) UserTokenRepository {
+	return &userTokenRepo{db: pool}
+}
+
+// userTokenColumns is the column list matched by scanUserToken
+const userTokenColumns = `id, user_id, purpose, token_hash, expires_at, used_at, created_at`
+
+func scanUserToken(row pgx.Row) (*model.UserToken, error) {
+	var t model.UserToken
+	err := row.Scan(&t.ID, &t.UserID, &t.Purpose, &t.TokenHash, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateUserToken stores a token and retires the unused tokens the user
+// already had for the same purpose, so only the latest mail works
+func (r *userTokenRepo) CreateUserToken(ctx context.Context, token *model.UserToken) error {
+	start := time.Now()
+
+	logger.Logger.Info("Creating user token in database",
+		"user_id", token.UserID,
+		"purpose", token.Purpose,
+	)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin user token insert failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`UPDATE user_tokens SET used_at=now() WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL`,
+		token.UserID, token.Purpose,
+	)
+	if err == nil {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO user_tokens (id, user_id, purpose, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+			token.ID, token.UserID, token.Purpose, token.TokenHash, token.ExpiresAt, token.CreatedAt,
+		)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	logger.LogDatabaseOperation(ctx, "insert", "user_tokens", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to insert user token",
+			"user_id", token.UserID,
+			"purpose", token.Purpose,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("insert user token failed: %w", err)
+	}
+	return nil
+}
+
+// ConsumeUserToken marks a live token used and returns it. The update is
+// atomic, so a token is only ever consumed once; unknown, used and expired
+// tokens return pgx.ErrNoRows.
+func (r *userTokenRepo) ConsumeUserToken(ctx context.Context, tokenHash string, purpose model.TokenPurpose) (*model.UserToken, error) {
+	start := time.Now()
+
+	query := `
+UPDATE user_tokens SET used_at=now()
+WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now()
+RETURNING ` + userTokenColumns
+	t, err := scanUserToken(r.db.QueryRow(ctx, query, tokenHash, purpose))
+
+	logger.LogDatabaseOperation(ctx, "update", "user_tokens", time.Since(start), err)
+
+	if err != nil {
+		return nil, fmt.Errorf("consume user token failed: %w", err)
+	}
+	return t, nil
+}
@@ -3,20 +3,63 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lumbrjx/codek7/repo/internal/model"
 	"github.com/lumbrjx/codek7/repo/pkg/logger"
 )
 
 type VideoRepository interface {
-	CreateVideo(ctx context.Context, v *model.Video) (*model.Video, error)
+	// CreateVideo stores a video whose original is about to be uploaded. The
+	// video and reserve bytes are reserved in its owner's usage if they fit
+	// limits, it fails with model.ErrQuotaExceeded otherwise.
+	CreateVideo(ctx context.Context, v *model.Video, reserve int64, limits model.QuotaLimits) (*model.Video, error)
 	GetVideoByID(ctx context.Context, videoID string) (*model.Video, error)
+	// LockUpload takes the lock the upload of a video holds while it is
+	// stored, so a delivery of the same upload cannot touch it meanwhile. ok
+	// is false when another delivery holds it. The lock goes with the
+	// connection of a repo service that dies, so its uploads can be retried.
+	LockUpload(ctx context.Context, videoID string) (release func(), ok bool, err error)
 	GetVideosByUser(ctx context.Context, userID string) ([]*model.Video, error)
-	GetLast3VideosByUser(ctx context.Context, userID string) ([]*model.Video, error)
+	GetVideosByOwner(ctx context.Context, userID string) ([]*model.Video, error)
+	ListVideos(ctx context.Context, filter model.VideoListFilter) ([]*model.Video, error)
 	DeleteVideo(ctx context.Context, videoID string) error
+	SoftDeleteVideo(ctx context.Context, videoID string) error
+	RestoreVideo(ctx context.Context, videoID string) error
+	// RecordOriginalSize stores the size of a video's stored original and
+	// counts it in its owner's usage in place of the upload's reservation.
+	// It fails with model.ErrQuotaExceeded when the original is larger than
+	// reserved and the rest does not fit limits.
+	RecordOriginalSize(ctx context.Context, videoID string, size int64, limits model.QuotaLimits) error
+	// ReleaseUpload gives back the reservation of an upload that failed
+	ReleaseUpload(ctx context.Context, videoID string) error
+	GetPurgeableVideos(ctx context.Context, deletedBefore time.Time, limit int) ([]*model.Video, error)
+	RecordPurgeFailure(ctx context.Context, videoID string, retryAt time.Time, cause string) error
+	UpdateVideoStatus(ctx context.Context, videoID string, from, to model.VideoStatus, reason string) error
+	GetStatusHistory(ctx context.Context, videoID string) ([]*model.VideoStatusChange, error)
+	GetReferencedObjects(ctx context.Context) (map[string]bool, error)
+	IsSharedWith(ctx context.Context, videoID, userID string) (bool, error)
+	SetVideoVisibility(ctx context.Context, videoID string, visibility model.VideoVisibility, sharedWith []string) error
+}
+
+// videoColumns is the column list matched by scanVideo
+const videoColumns = `id, user_id, title, description, created_at, file_name, status, status_reason, status_updated_at, visibility, deleted_at, purge_attempts,
+	container, duration, video_codec, audio_codec, width, height, frame_rate, file_size`
+
+func scanVideo(row pgx.Row) (*model.Video, error) {
+	var v model.Video
+	err := row.Scan(&v.ID, &v.UserID, &v.Title, &v.Description, &v.CreatedAt, &v.FileName,
+		&v.Status, &v.StatusReason, &v.StatusUpdatedAt, &v.Visibility, &v.DeletedAt, &v.PurgeAttempts,
+		&v.Media.Container, &v.Media.Duration, &v.Media.VideoCodec, &v.Media.AudioCodec, &v.Media.Width, &v.Media.Height, &v.Media.FrameRate, &v.FileSize)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
 }
 
 type videoRepo struct {
@@ -27,7 +70,7 @@ func NewVideoRepository(pool *pgxpool.Pool) VideoRepository {
 	return &videoRepo{db: pool}
 }
 
-func (r *videoRepo) CreateVideo(ctx context.Context, v *model.Video) (*model.Video, error) {
+func (r *videoRepo) CreateVideo(ctx context.Context, v *model.Video, reserve int64, limits model.QuotaLimits) (*model.Video, error) {
 	start := time.Now()
 
 	logger.Logger.Info("Creating video in database",
@@ -37,12 +80,27 @@ func (r *videoRepo) CreateVideo(ctx context.Context, v *model.Video) (*model.Vid
 		"filename", v.FileName,
 	)
 
-	query := `INSERT INTO videos (id, user_id, file_name, title, description, created_at)
-	          VALUES ($1, $2, $3, $4, $5, $6)`
-	_, err := r.db.Exec(ctx, query, v.ID, v.UserID, v.FileName, v.Title, v.Description, v.CreatedAt)
+	if v.Status == "" {
+		v.Status = model.VideoStatusUploading
+	}
+	if v.Visibility == "" {
+		v.Visibility = model.VisibilityOwner
+	}
+	v.StatusUpdatedAt = v.CreatedAt
+
+	err := r.insertVideo(ctx, v, reserve, limits)
 
 	logger.LogDatabaseOperation(ctx, "insert", "videos", time.Since(start), err)
 
+	if errors.Is(err, model.ErrQuotaExceeded) {
+		logger.Logger.Info("Upload refused by quota",
+			"video_id", v.ID,
+			"user_id", v.UserID,
+			"file_size_bytes", reserve,
+			"error", err.Error(),
+		)
+		return nil, err
+	}
 	if err != nil {
 		logger.Logger.Error("Failed to insert video",
 			"video_id", v.ID,
@@ -61,6 +119,30 @@ func (r *videoRepo) CreateVideo(ctx context.Context, v *model.Video) (*model.Vid
 	return v, nil
 }
 
+// insertVideo reserves the video in its owner's usage and inserts it in one
+// transaction, so a refused upload leaves nothing behind
+func (r *videoRepo) insertVideo(ctx context.Context, v *model.Video, reserve int64, limits model.QuotaLimits) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := reserveUsage(ctx, tx, v.UserID, reserve, 1, limits); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO videos (id, user_id, file_name, title, description, created_at, status, status_updated_at, visibility,
+	              container, duration, video_codec, audio_codec, width, height, frame_rate, reserved_bytes)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`
+	_, err = tx.Exec(ctx, query, v.ID, v.UserID, v.FileName, v.Title, v.Description, v.CreatedAt, v.Status, v.StatusUpdatedAt, v.Visibility,
+		v.Media.Container, v.Media.Duration, v.Media.VideoCodec, v.Media.AudioCodec, v.Media.Width, v.Media.Height, v.Media.FrameRate, reserve)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
 func (r *videoRepo) GetVideoByID(ctx context.Context, videoID string) (*model.Video, error) {
 	start := time.Now()
 
@@ -68,11 +150,8 @@ func (r *videoRepo) GetVideoByID(ctx context.Context, videoID string) (*model.Vi
 		"video_id", videoID,
 	)
 
-	query := `SELECT id, user_id, title, description, created_at, file_name FROM videos WHERE id=$1`
-	row := r.db.QueryRow(ctx, query, videoID)
-
-	var v model.Video
-	err := row.Scan(&v.ID, &v.UserID, &v.Title, &v.Description, &v.CreatedAt, &v.FileName)
+	query := `SELECT ` + videoColumns + ` FROM videos WHERE id=$1`
+	v, err := scanVideo(r.db.QueryRow(ctx, query, videoID))
 
 	logger.LogDatabaseOperation(ctx, "select", "videos", time.Since(start), err)
 
@@ -90,28 +169,52 @@ func (r *videoRepo) GetVideoByID(ctx context.Context, videoID string) (*model.Vi
 		"user_id", v.UserID,
 	)
 
-	return &v, nil
+	return v, nil
+}
+func (r *videoRepo) LockUpload(ctx context.Context, videoID string) (func(), bool, error) {
+	conn, err := r.db.Acquire(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("lock upload failed: %w", err)
+	}
+
+	var ok bool
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtextextended($1, 0))`, videoID).Scan(&ok)
+	if err != nil || !ok {
+		conn.Release()
+		if err != nil {
+			return nil, false, fmt.Errorf("lock upload failed: %w", err)
+		}
+		return nil, false, nil
+	}
+
+	release := func() {
+		// The request may be cancelled by now
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock(hashtextextended($1, 0))`, videoID); err != nil {
+			logger.Logger.Error("Failed to unlock upload, closing its connection",
+				"video_id", videoID,
+				"error", err.Error(),
+			)
+			conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+	}
+	return release, true, nil
 }
-func (r *videoRepo) GetLast3VideosByUser(ctx context.Context, userID string) ([]*model.Video, error) {
+
+func (r *videoRepo) GetVideosByUser(ctx context.Context, userID string) ([]*model.Video, error) {
 	start := time.Now()
 
-	logger.Logger.Info("Fetching last 3 videos for user from database",
+	logger.Logger.Info("Fetching all videos for user from database",
 		"user_id", userID,
 	)
 
-	query := `
-SELECT id, user_id, title, description, created_at, file_name
-FROM videos
-WHERE user_id = $1
-ORDER BY created_at DESC
-LIMIT 3
-`
+	query := `SELECT ` + videoColumns + ` FROM videos WHERE user_id=$1 AND deleted_at IS NULL ORDER BY created_at DESC`
 	rows, err := r.db.Query(ctx, query, userID)
 
 	logger.LogDatabaseOperation(ctx, "select", "videos", time.Since(start), err)
 
 	if err != nil {
-		logger.Logger.Error("Failed to query last 3 videos",
+		logger.Logger.Error("Failed to query user videos",
 			"user_id", userID,
 			"error", err.Error(),
 		)
@@ -121,64 +224,49 @@ LIMIT 3
 
 	var videos []*model.Video
 	for rows.Next() {
-		var v model.Video
-		if err := rows.Scan(&v.ID, &v.UserID, &v.Title, &v.Description, &v.CreatedAt, &v.FileName); err != nil {
+		v, err := scanVideo(rows)
+		if err != nil {
 			logger.Logger.Error("Failed to scan video row",
 				"user_id", userID,
 				"error", err.Error(),
 			)
 			return nil, err
 		}
-		videos = append(videos, &v)
+		videos = append(videos, v)
 	}
 
-	logger.Logger.Info("Last 3 videos fetched from database successfully",
+	logger.Logger.Info("User videos fetched from database successfully",
 		"user_id", userID,
 		"video_count", len(videos),
 	)
 
 	return videos, nil
 }
-func (r *videoRepo) GetVideosByUser(ctx context.Context, userID string) ([]*model.Video, error) {
-	start := time.Now()
 
-	logger.Logger.Info("Fetching all videos for user from database",
-		"user_id", userID,
-	)
+// GetVideosByOwner returns every video of a user, trashed ones included
+func (r *videoRepo) GetVideosByOwner(ctx context.Context, userID string) ([]*model.Video, error) {
+	start := time.Now()
 
-	query := `SELECT id, user_id, title, description, created_at, file_name FROM videos WHERE user_id=$1 ORDER BY created_at DESC`
+	query := `SELECT ` + videoColumns + ` FROM videos WHERE user_id=$1 ORDER BY created_at`
 	rows, err := r.db.Query(ctx, query, userID)
 
 	logger.LogDatabaseOperation(ctx, "select", "videos", time.Since(start), err)
 
 	if err != nil {
-		logger.Logger.Error("Failed to query user videos",
-			"user_id", userID,
-			"error", err.Error(),
-		)
-		return nil, fmt.Errorf("query videos failed: %w", err)
+		return nil, fmt.Errorf("query owner videos failed: %w", err)
 	}
 	defer rows.Close()
 
 	var videos []*model.Video
 	for rows.Next() {
-		var v model.Video
-		if err := rows.Scan(&v.ID, &v.UserID, &v.Title, &v.Description, &v.CreatedAt, &v.FileName); err != nil {
-			logger.Logger.Error("Failed to scan video row",
-				"user_id", userID,
-				"error", err.Error(),
-			)
+		v, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
-		videos = append(videos, &v)
+		videos = append(videos, v)
 	}
 
-	logger.Logger.Info("User videos fetched from database successfully",
-		"user_id", userID,
-		"video_count", len(videos),
-	)
-
-	return videos, nil
+	return videos, rows.Err()
 }
 
 func (r *videoRepo) DeleteVideo(ctx context.Context, videoID string) error {
@@ -188,8 +276,14 @@ func (r *videoRepo) DeleteVideo(ctx context.Context, videoID string) error {
 		"video_id", videoID,
 	)
 
-	query := `DELETE FROM videos WHERE id=$1`
-	_, err := r.db.Exec(ctx, query, videoID)
+	// Videos outside the trash still count in their owner's usage, as do
+	// uploads that hold a reservation
+	err := r.changeVideo(ctx, -1,
+		`DELETE FROM videos WHERE id=$1
+		 RETURNING user_id, COALESCE(reserved_bytes, file_size), (reserved_bytes IS NOT NULL OR file_size > 0) AND deleted_at IS NULL`, videoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+	}
 
 	logger.LogDatabaseOperation(ctx, "delete", "videos", time.Since(start), err)
 
@@ -207,3 +301,536 @@ func (r *videoRepo) DeleteVideo(ctx context.Context, videoID string) error {
 
 	return nil
 }
+
+// changeVideo runs query, which changes one video and returns its owner,
+// original or reserved size and whether its usage is affected, and adds
+// sign times the video to the owner's usage in the same transaction. It
+// returns pgx.ErrNoRows when the query changed nothing.
+func (r *videoRepo) changeVideo(ctx context.Context, sign int, query string, args ...any) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID string
+	var size int64
+	var counted bool
+	if err := tx.QueryRow(ctx, query, args...).Scan(&userID, &size, &counted); err != nil {
+		return err
+	}
+	if counted {
+		if err := addUsage(ctx, tx, userID, int64(sign)*size, sign); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// SoftDeleteVideo moves a video to the trash. Its rows and objects are kept
+// until the purger removes them.
+func (r *videoRepo) SoftDeleteVideo(ctx context.Context, videoID string) error {
+	start := time.Now()
+
+	logger.Logger.Info("Moving video to trash in database",
+		"video_id", videoID,
+	)
+
+	// Trashing releases the original, or the reservation, from the owner's usage
+	err := r.changeVideo(ctx, -1,
+		`UPDATE videos SET deleted_at=now() WHERE id=$1 AND deleted_at IS NULL
+		 RETURNING user_id, COALESCE(reserved_bytes, file_size), reserved_bytes IS NOT NULL OR file_size > 0`, videoID)
+
+	logger.LogDatabaseOperation(ctx, "update", "videos", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to move video to trash",
+			"video_id", videoID,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("soft delete video failed: %w", err)
+	}
+	return nil
+}
+
+// RestoreVideo takes a video out of the trash
+func (r *videoRepo) RestoreVideo(ctx context.Context, videoID string) error {
+	start := time.Now()
+
+	logger.Logger.Info("Restoring video from trash in database",
+		"video_id", videoID,
+	)
+
+	err := r.changeVideo(ctx, 1,
+		`UPDATE videos SET deleted_at=NULL, purge_attempts=0, purge_error='', purge_after=NULL
+		 WHERE id=$1 AND deleted_at IS NOT NULL
+		 RETURNING user_id, COALESCE(reserved_bytes, file_size), reserved_bytes IS NOT NULL OR file_size > 0`, videoID)
+
+	logger.LogDatabaseOperation(ctx, "update", "videos", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to restore video",
+			"video_id", videoID,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("restore video failed: %w", err)
+	}
+	return nil
+}
+
+func (r *videoRepo) RecordOriginalSize(ctx context.Context, videoID string, size int64, limits model.QuotaLimits) error {
+	start := time.Now()
+
+	err := r.recordOriginalSize(ctx, videoID, size, limits)
+
+	logger.LogDatabaseOperation(ctx, "update", "videos", time.Since(start), err)
+
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		logger.Logger.Error("Failed to record original size",
+			"video_id", videoID,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("record original size failed: %w", err)
+	}
+	return nil
+}
+
+func (r *videoRepo) recordOriginalSize(ctx context.Context, videoID string, size int64, limits model.QuotaLimits) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Only the first recorded size counts, so a retry does not count twice
+	var userID string
+	var reserved int64
+	var counted bool
+	err = tx.QueryRow(ctx,
+		`SELECT user_id, reserved_bytes, deleted_at IS NULL FROM videos
+		 WHERE id=$1 AND file_size=0 AND reserved_bytes IS NOT NULL FOR UPDATE`,
+		videoID).Scan(&userID, &reserved, &counted)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE videos SET file_size=$2, reserved_bytes=NULL WHERE id=$1`, videoID, size); err != nil {
+		return err
+	}
+
+	// Trashing released the reservation already
+	if counted {
+		if size > reserved {
+			err = reserveUsage(ctx, tx, userID, size-reserved, 0, limits)
+		} else {
+			err = addUsage(ctx, tx, userID, size-reserved, 0)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *videoRepo) ReleaseUpload(ctx context.Context, videoID string) error {
+	start := time.Now()
+
+	err := r.releaseUpload(ctx, videoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+	}
+
+	logger.LogDatabaseOperation(ctx, "update", "videos", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to release upload reservation",
+			"video_id", videoID,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("release upload failed: %w", err)
+	}
+	return nil
+}
+
+func (r *videoRepo) releaseUpload(ctx context.Context, videoID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID string
+	var reserved int64
+	var counted bool
+	err = tx.QueryRow(ctx,
+		`SELECT user_id, reserved_bytes, deleted_at IS NULL FROM videos WHERE id=$1 AND reserved_bytes IS NOT NULL FOR UPDATE`,
+		videoID).Scan(&userID, &reserved, &counted)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE videos SET reserved_bytes=NULL WHERE id=$1`, videoID); err != nil {
+		return err
+	}
+	if counted {
+		if err := addUsage(ctx, tx, userID, -reserved, -1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// GetPurgeableVideos returns trashed videos deleted before deletedBefore
+// whose retry delay, if any, has passed, oldest first
+func (r *videoRepo) GetPurgeableVideos(ctx context.Context, deletedBefore time.Time, limit int) ([]*model.Video, error) {
+	start := time.Now()
+
+	query := `SELECT ` + videoColumns + ` FROM videos
+	          WHERE deleted_at < $1 AND (purge_after IS NULL OR purge_after <= now())
+	          ORDER BY deleted_at LIMIT $2`
+	rows, err := r.db.Query(ctx, query, deletedBefore, limit)
+
+	logger.LogDatabaseOperation(ctx, "select", "videos", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to query purgeable videos",
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("query purgeable videos failed: %w", err)
+	}
+	defer rows.Close()
+
+	var videos []*model.Video
+	for rows.Next() {
+		v, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, v)
+	}
+
+	return videos, rows.Err()
+}
+
+// RecordPurgeFailure counts a failed purge and postpones the next attempt
+func (r *videoRepo) RecordPurgeFailure(ctx context.Context, videoID string, retryAt time.Time, cause string) error {
+	start := time.Now()
+
+	_, err := r.db.Exec(ctx,
+		`UPDATE videos SET purge_attempts=purge_attempts+1, purge_error=$2, purge_after=$3 WHERE id=$1`,
+		videoID, cause, retryAt,
+	)
+
+	logger.LogDatabaseOperation(ctx, "update", "videos", time.Since(start), err)
+
+	if err != nil {
+		return fmt.Errorf("record purge failure failed: %w", err)
+	}
+	return nil
+}
+
+// UpdateVideoStatus moves a video from one status to another and records the
+// transition. It fails with model.ErrInvalidStatusTransition when the video is
+// no longer in the expected from status.
+func (r *videoRepo) UpdateVideoStatus(ctx context.Context, videoID string, from, to model.VideoStatus, reason string) error {
+	start := time.Now()
+
+	logger.Logger.Info("Updating video status in database",
+		"video_id", videoID,
+		"from_status", from,
+		"to_status", to,
+	)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin status update failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE videos SET status=$3, status_reason=$4, status_updated_at=now() WHERE id=$1 AND status=$2`,
+		videoID, from, to, reason,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = fmt.Errorf("video is no longer %s: %w", from, model.ErrInvalidStatusTransition)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO video_status_history (video_id, from_status, to_status, reason) VALUES ($1, $2, $3, $4)`,
+			videoID, from, to, reason,
+		)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	logger.LogDatabaseOperation(ctx, "update", "videos", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to update video status",
+			"video_id", videoID,
+			"from_status", from,
+			"to_status", to,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("update video status failed: %w", err)
+	}
+
+	logger.Logger.Info("Video status updated in database successfully",
+		"video_id", videoID,
+		"status", to,
+	)
+
+	return nil
+}
+
+// IsSharedWith reports whether the video was shared with userID
+func (r *videoRepo) IsSharedWith(ctx context.Context, videoID, userID string) (bool, error) {
+	start := time.Now()
+
+	var shared bool
+	err := r.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM video_shares WHERE video_id=$1 AND user_id=$2)`,
+		videoID, userID,
+	).Scan(&shared)
+
+	logger.LogDatabaseOperation(ctx, "select", "video_shares", time.Since(start), err)
+
+	if err != nil {
+		return false, fmt.Errorf("check video share failed: %w", err)
+	}
+	return shared, nil
+}
+
+// SetVideoVisibility changes the visibility of a video and replaces the list
+// of users it is shared with. The list is cleared unless visibility is shared.
+func (r *videoRepo) SetVideoVisibility(ctx context.Context, videoID string, visibility model.VideoVisibility, sharedWith []string) error {
+	start := time.Now()
+
+	logger.Logger.Info("Updating video visibility in database",
+		"video_id", videoID,
+		"visibility", visibility,
+		"shared_with_count", len(sharedWith),
+	)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin visibility update failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE videos SET visibility=$2 WHERE id=$1`, videoID, visibility)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM video_shares WHERE video_id=$1`, videoID)
+	}
+	if err == nil && visibility == model.VisibilityShared && len(sharedWith) > 0 {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO video_shares (video_id, user_id) SELECT $1, unnest($2::uuid[]) ON CONFLICT DO NOTHING`,
+			videoID, sharedWith,
+		)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	logger.LogDatabaseOperation(ctx, "update", "videos", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to update video visibility",
+			"video_id", videoID,
+			"visibility", visibility,
+			"error", err.Error(),
+		)
+		return fmt.Errorf("update video visibility failed: %w", err)
+	}
+
+	return nil
+}
+
+// GetStatusHistory returns the status transitions of a video, oldest first
+func (r *videoRepo) GetStatusHistory(ctx context.Context, videoID string) ([]*model.VideoStatusChange, error) {
+	start := time.Now()
+
+	rows, err := r.db.Query(ctx,
+		`SELECT id, video_id, from_status, to_status, reason, changed_at FROM video_status_history WHERE video_id=$1 ORDER BY changed_at, id`,
+		videoID,
+	)
+
+	logger.LogDatabaseOperation(ctx, "select", "video_status_history", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to query video status history",
+			"video_id", videoID,
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("query status history failed: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []*model.VideoStatusChange
+	for rows.Next() {
+		c := &model.VideoStatusChange{}
+		if err := rows.Scan(&c.ID, &c.VideoID, &c.FromStatus, &c.ToStatus, &c.Reason, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("scan status change failed: %w", err)
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+// GetReferencedObjects returns every object key that the database knows
+// about, from both videos.file_name and the asset manifest.
+func (r *videoRepo) GetReferencedObjects(ctx context.Context) (map[string]bool, error) {
+	start := time.Now()
+
+	objectKeys := make(map[string]bool)
+
+	rows, err := r.db.Query(ctx, `SELECT file_name FROM videos`)
+	if err == nil {
+		for rows.Next() {
+			var fileName string
+			if err = rows.Scan(&fileName); err != nil {
+				break
+			}
+			objectKeys[fileName] = true
+		}
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+	if err == nil {
+		rows, err = r.db.Query(ctx, `SELECT object_key FROM video_assets`)
+		if err == nil {
+			for rows.Next() {
+				var key string
+				if err = rows.Scan(&key); err != nil {
+					break
+				}
+				objectKeys[key] = true
+			}
+			rows.Close()
+			if err == nil {
+				err = rows.Err()
+			}
+		}
+	}
+
+	logger.LogDatabaseOperation(ctx, "select", "videos", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to load referenced objects",
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("load referenced objects failed: %w", err)
+	}
+
+	return objectKeys, nil
+}
+
+// videoSortColumns maps sort fields to their columns
+var videoSortColumns = map[model.VideoSortField]string{
+	model.VideoSortCreatedAt: "created_at",
+	model.VideoSortUpdatedAt: "status_updated_at",
+	model.VideoSortTitle:     "title",
+}
+
+// ListVideos returns one page of videos using keyset pagination on the sort
+// column and id, so pages stay stable while rows are inserted.
+func (r *videoRepo) ListVideos(ctx context.Context, f model.VideoListFilter) ([]*model.Video, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Listing videos from database",
+		"user_id", f.UserID,
+		"query", f.Query,
+		"status", f.Status,
+		"sort_by", f.SortBy,
+		"limit", f.Limit,
+	)
+
+	column, ok := videoSortColumns[f.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort field %q", f.SortBy)
+	}
+
+	where := []string{"deleted_at IS NULL"}
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.UserID != "" {
+		where = append(where, "user_id = "+arg(f.UserID))
+	}
+	if f.ViewerID != "" {
+		viewer := arg(f.ViewerID)
+		where = append(where, fmt.Sprintf(`(user_id = %s OR visibility = 'public' OR (visibility = 'shared' AND EXISTS (
+	SELECT 1 FROM video_shares s WHERE s.video_id = videos.id AND s.user_id = %s)))`, viewer, viewer))
+	}
+	if f.Query != "" {
+		where = append(where, "search_vector @@ websearch_to_tsquery('english', "+arg(f.Query)+")")
+	}
+	if f.Status != "" {
+		where = append(where, "status = "+arg(f.Status))
+	}
+	if !f.CreatedAfter.IsZero() {
+		where = append(where, "created_at >= "+arg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		where = append(where, "created_at < "+arg(f.CreatedBefore))
+	}
+	if f.AfterID != "" {
+		var after any = f.After
+		if f.SortBy != model.VideoSortTitle {
+			t, err := time.Parse(time.RFC3339Nano, f.After)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cursor value: %w", err)
+			}
+			after = t
+		}
+		cmp := ">"
+		if f.Descending {
+			cmp = "<"
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", column, cmp, arg(after), arg(f.AfterID)))
+	}
+
+	order := "ASC"
+	if f.Descending {
+		order = "DESC"
+	}
+
+	query := `SELECT ` + videoColumns + ` FROM videos WHERE ` + strings.Join(where, " AND ")
+	query += fmt.Sprintf(` ORDER BY %s %s, id %s LIMIT %s`, column, order, order, arg(f.Limit))
+
+	rows, err := r.db.Query(ctx, query, args...)
+
+	logger.LogDatabaseOperation(ctx, "select", "videos", time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to list videos",
+			"user_id", f.UserID,
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("list videos failed: %w", err)
+	}
+	defer rows.Close()
+
+	var videos []*model.Video
+	for rows.Next() {
+		v, err := scanVideo(rows)
+		if err != nil {
+			logger.Logger.Error("Failed to scan video row",
+				"user_id", f.UserID,
+				"error", err.Error(),
+			)
+			return nil, err
+		}
+		videos = append(videos, v)
+	}
+
+	return videos, rows.Err()
+}
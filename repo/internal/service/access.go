@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ErrInvalidVisibility is returned for unknown visibilities or share lists
+var ErrInvalidVisibility = errors.New("invalid video visibility")
+
+// VideoAction is what a caller wants to do with a video
+type VideoAction int
+
+const (
+	// ActionView covers reading metadata, assets and files
+	ActionView VideoAction = iota
+	// ActionManage covers changing or removing the video, owner only
+	ActionManage
+	// ActionRestore covers taking a video out of the trash, owner only
+	ActionRestore
+)
+
+// AuthorizeVideo loads a video and checks that callerID may perform action
+// on it. Videos the caller cannot see fail with model.ErrVideoNotFound,
+// visible videos the caller cannot change fail with model.ErrVideoAccessDenied.
+func (s *videoService) AuthorizeVideo(ctx context.Context, callerID, videoID string, action VideoAction) (*model.Video, error) {
+	if _, err := uuid.FromString(videoID); err != nil {
+		return nil, model.ErrVideoNotFound
+	}
+
+	video, err := s.repo.GetVideoByID(ctx, videoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, model.ErrVideoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Trashed videos only exist for their owner, and only to be restored
+	if (video.DeletedAt != nil) != (action == ActionRestore) {
+		return nil, model.ErrVideoNotFound
+	}
+
+	if video.UserID == callerID {
+		return video, nil
+	}
+	if action == ActionRestore {
+		return nil, model.ErrVideoNotFound
+	}
+
+	visible := video.Visibility == model.VisibilityPublic
+	if video.Visibility == model.VisibilityShared {
+		visible, err = s.repo.IsSharedWith(ctx, videoID, callerID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case !visible:
+		logger.Logger.Warn("Video hidden from caller",
+			"video_id", videoID,
+			"caller_id", callerID,
+		)
+		return nil, model.ErrVideoNotFound
+	case action != ActionView:
+		logger.Logger.Warn("Video change denied",
+			"video_id", videoID,
+			"caller_id", callerID,
+		)
+		return nil, model.ErrVideoAccessDenied
+	}
+	return video, nil
+}
+
+// AuthorizeFile resolves the video owning a stored file through the asset
+// manifest and checks that callerID may view it
+func (s *videoService) AuthorizeFile(ctx context.Context, callerID, fileName string) (*model.Video, error) {
+	asset, err := s.assets.GetAssetByObjectKey(ctx, fileName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, model.ErrVideoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.AuthorizeVideo(ctx, callerID, asset.VideoID, ActionView)
+}
+
+// SetVisibility changes who may see a video. sharedWith is only kept for
+// the shared visibility.
+func (s *videoService) SetVisibility(ctx context.Context, callerID, videoID string, visibility model.VideoVisibility, sharedWith []string) (*model.Video, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Setting video visibility",
+		"video_id", videoID,
+		"visibility", visibility,
+	)
+
+	if !visibility.IsValid() {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidVisibility, visibility)
+	}
+	for _, id := range sharedWith {
+		if _, err := uuid.FromString(id); err != nil {
+			return nil, fmt.Errorf("%w: invalid user ID %q in shared_with", ErrInvalidVisibility, id)
+		}
+	}
+
+	if _, err := s.AuthorizeVideo(ctx, callerID, videoID, ActionManage); err != nil {
+		return nil, err
+	}
+
+	err := s.repo.SetVideoVisibility(ctx, videoID, visibility, sharedWith)
+
+	logger.LogVideoOperation(ctx, "set_visibility", videoID, "", 0, time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetVideoByID(ctx, videoID)
+}
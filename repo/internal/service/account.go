@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = time.Hour
+)
+
+var (
+	// ErrEmailAlreadyVerified is returned when verification is requested
+	// for a verified address
+	ErrEmailAlreadyVerified = errors.New("email already verified")
+	ErrEmptyPassword        = errors.New("password must not be empty")
+	ErrPasswordTooLong      = errors.New("password must be at most 72 bytes")
+)
+
+// IssuedToken is a freshly created single-use token, to be mailed to User.
+// Token is the only copy of the secret.
+type IssuedToken struct {
+	User      *model.User
+	Token     string
+	ExpiresAt time.Time
+}
+
+// issueToken creates a token for purpose, retiring older ones
+func (s *userService) issueToken(ctx context.Context, user *model.User, purpose model.TokenPurpose, ttl time.Duration) (*IssuedToken, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return nil, fmt.Errorf("generate token failed: %w", err)
+	}
+
+	now := time.Now()
+	token := &model.UserToken{
+		ID:        uuid.NewV4().String(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashSecret(secret),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := s.tokens.CreateUserToken(ctx, token); err != nil {
+		return nil, err
+	}
+
+	return &IssuedToken{User: user, Token: secret, ExpiresAt: token.ExpiresAt}, nil
+}
+
+// consumeToken spends a token and returns the user it was issued to
+func (s *userService) consumeToken(ctx context.Context, secret string, purpose model.TokenPurpose) (*model.UserToken, error) {
+	if secret == "" {
+		return nil, model.ErrUserTokenInvalid
+	}
+	token, err := s.tokens.ConsumeUserToken(ctx, hashSecret(secret), purpose)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, model.ErrUserTokenInvalid
+	}
+	return token, err
+}
+
+func (s *userService) RequestEmailVerification(ctx context.Context, userID string) (*IssuedToken, error) {
+	start := time.Now()
+
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.EmailVerified() {
+		return nil, ErrEmailAlreadyVerified
+	}
+
+	issued, err := s.issueToken(ctx, user, model.PurposeVerifyEmail, emailVerificationTTL)
+
+	logger.LogUserOperation(ctx, "request_email_verification", userID, user.Username, time.Since(start), err)
+
+	return issued, err
+}
+
+func (s *userService) ConfirmEmail(ctx context.Context, secret string) (*model.User, error) {
+	start := time.Now()
+
+	token, err := s.consumeToken(ctx, secret, model.PurposeVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.MarkEmailVerified(ctx, token.UserID)
+
+	logger.LogUserOperation(ctx, "confirm_email", token.UserID, "", time.Since(start), err)
+
+	return user, err
+}
+
+// RequestPasswordReset issues a reset token for the account registered
+// with email. Unknown addresses return pgx.ErrNoRows, which callers must
+// not reveal.
+func (s *userService) RequestPasswordReset(ctx context.Context, email string) (*IssuedToken, error) {
+	start := time.Now()
+
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	issued, err := s.issueToken(ctx, user, model.PurposeResetPassword, passwordResetTTL)
+
+	logger.LogUserOperation(ctx, "request_password_reset", user.ID, user.Username, time.Since(start), err)
+
+	return issued, err
+}
+
+// ResetPassword stores password, already hashed by the gateway. Completing
+// a reset also proves the user owns the address, so it is marked verified.
+func (s *userService) ResetPassword(ctx context.Context, secret, password string) (*model.User, error) {
+	start := time.Now()
+
+	// Hash first so an unusable password does not use up the token
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.consumeToken(ctx, secret, model.PurposeResetPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.UpdatePassword(ctx, token.UserID, hash)
+	if err == nil && !user.EmailVerified() {
+		user, err = s.repo.MarkEmailVerified(ctx, token.UserID)
+	}
+
+	logger.LogUserOperation(ctx, "reset_password", token.UserID, "", time.Since(start), err)
+
+	return user, err
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/repository"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// apiKeyPrefix marks a bearer token as an API key rather than a JWT
+	apiKeyPrefix = "ck7_"
+	// apiKeyDisplayLength is how much of the key is kept in clear text to
+	// tell keys apart, the prefix and 8 random characters
+	apiKeyDisplayLength = len(apiKeyPrefix) + 8
+	maxAPIKeyNameLength = 100
+)
+
+var (
+	ErrInvalidAPIKeyName   = errors.New("invalid api key name")
+	ErrInvalidAPIKeyScopes = errors.New("invalid api key scopes")
+	ErrInvalidAPIKeyExpiry = errors.New("api key expiry must be in the future")
+)
+
+type APIKeyService interface {
+	// CreateAPIKey returns the stored key and the secret, which is shown once
+	CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (*model.APIKey, string, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]*model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, keyID string) error
+	// AuthenticateAPIKey resolves a presented secret to its active key, with
+	// the scopes its owner still holds
+	AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, error)
+}
+
+type apiKeyService struct {
+	repo  repository.APIKeyRepository
+	users repository.UserRepository
+}
+
+func NewAPIKeyService(repo repository.APIKeyRepository, users repository.UserRepository) APIKeyService {
+	return &apiKeyService{repo: repo, users: users}
+}
+
+// hashSecret is the lookup key of an API key or mailed token. Both carry
+// 256 random bits, so a fast unsalted hash is enough to keep them useless
+// if the table leaks.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSecret returns 256 random bits, URL safe
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newAPIKeySecret() (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + secret, nil
+}
+
+// grantableScopes validates the scopes requested for a key. A key needs at
+// least one scope and cannot exceed what its owner holds.
+func grantableScopes(owner *model.User, scopes []string) ([]string, error) {
+	for i := range scopes {
+		scopes[i] = strings.TrimSpace(scopes[i])
+	}
+	scopes, err := validateScopes(scopes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAPIKeyScopes, err)
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKeyScopes)
+	}
+	for _, s := range scopes {
+		if !owner.HasScope(model.Scope(s)) {
+			return nil, fmt.Errorf("%w: scope %q is not granted to the user", ErrInvalidAPIKeyScopes, s)
+		}
+	}
+	return scopes, nil
+}
+
+// intersectScopes keeps the key scopes its owner still holds, so taking a
+// scope from a user also takes it from their keys
+func intersectScopes(owner *model.User, scopes []string) []string {
+	out := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		if owner.HasScope(model.Scope(s)) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (s *apiKeyService) CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (*model.APIKey, string, error) {
+	start := time.Now()
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		return nil, "", ErrInvalidAPIKeyName
+	}
+	owner, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	scopes, err = grantableScopes(owner, scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if expiresAt != nil && !expiresAt.After(start) {
+		return nil, "", ErrInvalidAPIKeyExpiry
+	}
+
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		return nil, "", fmt.Errorf("generate api key failed: %w", err)
+	}
+
+	key := &model.APIKey{
+		ID:        uuid.NewV4().String(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    secret[:apiKeyDisplayLength],
+		TokenHash: hashSecret(secret),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: start,
+	}
+
+	err = s.repo.CreateAPIKey(ctx, key)
+
+	logger.LogUserOperation(ctx, "create_api_key", userID, "", time.Since(start), err)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	logger.Logger.Info("API key created",
+		"key_id", key.ID,
+		"user_id", userID,
+		"scopes", scopes,
+	)
+
+	return key, secret, nil
+}
+
+func (s *apiKeyService) ListAPIKeys(ctx context.Context, userID string) ([]*model.APIKey, error) {
+	return s.repo.ListAPIKeys(ctx, userID)
+}
+
+func (s *apiKeyService) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	start := time.Now()
+
+	if _, err := uuid.FromString(keyID); err != nil {
+		return model.ErrAPIKeyNotFound
+	}
+
+	err := s.repo.RevokeAPIKey(ctx, userID, keyID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = model.ErrAPIKeyNotFound
+	}
+
+	logger.LogUserOperation(ctx, "revoke_api_key", userID, "", time.Since(start), err)
+
+	return err
+}
+
+func (s *apiKeyService) AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return nil, model.ErrAPIKeyInvalid
+	}
+
+	key, err := s.repo.GetAPIKeyByHash(ctx, hashSecret(secret))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, model.ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !key.Active(now) {
+		logger.Logger.Warn("Inactive API key presented",
+			"key_id", key.ID,
+			"user_id", key.UserID,
+		)
+		return nil, model.ErrAPIKeyInvalid
+	}
+
+	owner, err := s.users.GetUserByID(ctx, key.UserID)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = intersectScopes(owner, key.Scopes)
+
+	// Usage tracking is best effort and never fails the request
+	if err := s.repo.TouchAPIKey(ctx, key.ID, now); err != nil {
+		logger.Logger.Warn("Failed to record API key use",
+			"key_id", key.ID,
+			"error", err.Error(),
+		)
+	}
+
+	return key, nil
+}
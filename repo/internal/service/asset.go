@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+// RegisterAssets records objects generated for a video. Generated files must
+// be registered before they are uploaded so UploadVideo can tell them apart
+// from original uploads.
+func (s *videoService) RegisterAssets(ctx context.Context, videoID string, assets []*model.VideoAsset) ([]*model.VideoAsset, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Registering video assets",
+		"video_id", videoID,
+		"asset_count", len(assets),
+	)
+
+	if videoID == "" || len(assets) == 0 {
+		return nil, fmt.Errorf("invalid input: videoID and at least one asset are required")
+	}
+	for _, a := range assets {
+		if a.ObjectKey == "" || !a.Kind.IsValid() {
+			return nil, fmt.Errorf("invalid input: asset %q needs an object key and a known kind", a.ObjectKey)
+		}
+		a.VideoID = videoID
+	}
+
+	video, err := s.repo.GetVideoByID(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	saved, err := s.assets.UpsertAssets(ctx, assets)
+
+	logger.LogVideoOperation(ctx, "register_assets", videoID, video.UserID, 0, time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func (s *videoService) GetAssetsByVideo(ctx context.Context, videoID string) ([]*model.VideoAsset, error) {
+	if videoID == "" {
+		return nil, fmt.Errorf("videoID cannot be empty")
+	}
+	if _, err := s.repo.GetVideoByID(ctx, videoID); err != nil {
+		return nil, err
+	}
+	return s.assets.GetAssetsByVideo(ctx, videoID)
+}
+
+// IsGeneratedFile reports whether fileName is a derived asset. Files missing
+// from the manifest are originals.
+func (s *videoService) IsGeneratedFile(ctx context.Context, fileName string) (bool, error) {
+	a, err := s.assets.GetAssetByObjectKey(ctx, fileName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return a.Kind != model.AssetKindOriginal, nil
+}
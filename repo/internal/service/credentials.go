@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned for unknown accounts and wrong passwords
+// alike, so callers cannot tell which accounts exist
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// dummyPasswordHash is compared against when no account matches, so an
+// unknown account costs as much time as a wrong password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("codek7-login-timing"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// hashPassword hashes a new password at the configured cost
+func (s *userService) hashPassword(password string) (string, error) {
+	switch {
+	case password == "":
+		return "", ErrEmptyPassword
+	case len(password) > 72: // bcrypt ignores the rest
+		return "", ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.bcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// ResolveLogin returns the ID of the account usernameOrEmail names, or ""
+func (s *userService) ResolveLogin(ctx context.Context, usernameOrEmail string) (string, error) {
+	usernameOrEmail = strings.TrimSpace(usernameOrEmail)
+	if usernameOrEmail == "" {
+		return "", nil
+	}
+	user, err := s.lookupLogin(ctx, usernameOrEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
+
+// lookupLogin finds the account a login names, by username first and by
+// email when the identifier looks like an address
+func (s *userService) lookupLogin(ctx context.Context, usernameOrEmail string) (*model.User, error) {
+	user, err := s.repo.GetUser(ctx, usernameOrEmail)
+	if errors.Is(err, pgx.ErrNoRows) && strings.Contains(usernameOrEmail, "@") {
+		user, err = s.repo.GetUserByEmail(ctx, usernameOrEmail)
+	}
+	return user, err
+}
+
+func (s *userService) VerifyCredentials(ctx context.Context, usernameOrEmail, password string) (*model.User, error) {
+	start := time.Now()
+
+	usernameOrEmail = strings.TrimSpace(usernameOrEmail)
+	if usernameOrEmail == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := s.lookupLogin(ctx, usernameOrEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		err = ErrInvalidCredentials
+	}
+	if err != nil {
+		logger.LogUserOperation(ctx, "verify_credentials", "", usernameOrEmail, time.Since(start), err)
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		logger.LogUserOperation(ctx, "verify_credentials", user.ID, user.Username, time.Since(start), ErrInvalidCredentials)
+		return nil, ErrInvalidCredentials
+	}
+
+	s.upgradePasswordHash(ctx, user, password)
+
+	logger.LogUserOperation(ctx, "verify_credentials", user.ID, user.Username, time.Since(start), nil)
+
+	return user, nil
+}
+
+// upgradePasswordHash rehashes a verified password stored at another cost.
+// It is best effort, the login succeeds either way.
+func (s *userService) upgradePasswordHash(ctx context.Context, user *model.User, password string) {
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	if err == nil && cost == s.bcryptCost {
+		return
+	}
+
+	hash, err := s.hashPassword(password)
+	if err == nil {
+		_, err = s.repo.UpdatePassword(ctx, user.ID, hash)
+	}
+	if err != nil {
+		logger.Logger.Warn("Failed to upgrade password hash",
+			"user_id", user.ID,
+			"error", err.Error(),
+		)
+		return
+	}
+
+	logger.Logger.Info("Password hash upgraded",
+		"user_id", user.ID,
+		"from_cost", cost,
+		"to_cost", s.bcryptCost,
+	)
+	user.Password = hash
+}
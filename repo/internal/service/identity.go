@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/repository"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// noPassword is stored for accounts created through a provider. It is
+	// not a bcrypt hash, so password login fails until the user sets one
+	// with a password reset.
+	noPassword = "!"
+
+	maxUsernameLength = 24
+	usernameAttempts  = 5
+)
+
+var ErrInvalidIdentity = errors.New("invalid identity")
+
+type IdentityService interface {
+	// LoginWithIdentity resolves an external identity to a user, linking it
+	// to the account with the same verified email or creating a new account.
+	// created reports whether the account is new.
+	LoginWithIdentity(ctx context.Context, ext model.ExternalIdentity) (user *model.User, created bool, err error)
+}
+
+type identityService struct {
+	repo  repository.IdentityRepository
+	users repository.UserRepository
+}
+
+func NewIdentityService(repo repository.IdentityRepository, users repository.UserRepository) IdentityService {
+	return &identityService{repo: repo, users: users}
+}
+
+func (s *identityService) LoginWithIdentity(ctx context.Context, ext model.ExternalIdentity) (*model.User, bool, error) {
+	start := time.Now()
+
+	ext.Provider = strings.TrimSpace(ext.Provider)
+	ext.Email = strings.TrimSpace(ext.Email)
+	if ext.Provider == "" || ext.Subject == "" {
+		return nil, false, ErrInvalidIdentity
+	}
+
+	identity, err := s.repo.GetIdentity(ctx, ext.Provider, ext.Subject)
+	switch {
+	case err == nil:
+		user, err := s.users.GetUserByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := s.repo.TouchIdentity(ctx, identity.ID, start); err != nil {
+			logger.Logger.Warn("Failed to record identity login",
+				"identity_id", identity.ID,
+				"error", err.Error(),
+			)
+		}
+		logger.LogUserOperation(ctx, "identity_login", user.ID, user.Username, time.Since(start), nil)
+		return user, false, nil
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, false, err
+	}
+
+	if ext.Email == "" {
+		return nil, false, model.ErrIdentityEmailRequired
+	}
+
+	user, err := s.users.GetUserByEmail(ctx, ext.Email)
+	switch {
+	case err == nil:
+		err = s.link(ctx, user, ext, start)
+		logger.LogUserOperation(ctx, "identity_link", user.ID, user.Username, time.Since(start), err)
+		if err != nil {
+			return nil, false, err
+		}
+		return user, false, nil
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, false, err
+	}
+
+	user, err = s.create(ctx, ext, start)
+	if err != nil {
+		logger.LogUserOperation(ctx, "identity_signup", "", "", time.Since(start), err)
+		return nil, false, err
+	}
+	logger.LogUserOperation(ctx, "identity_signup", user.ID, user.Username, time.Since(start), nil)
+	return user, true, nil
+}
+
+// link adds an identity to an existing account. Both sides must have
+// verified the address, otherwise whoever registered it first at either end
+// could take over the other account.
+func (s *identityService) link(ctx context.Context, user *model.User, ext model.ExternalIdentity, now time.Time) error {
+	if !ext.EmailVerified || !user.EmailVerified() {
+		logger.Logger.Warn("Refusing to link identity to account with unverified email",
+			"user_id", user.ID,
+			"provider", ext.Provider,
+			"provider_email_verified", ext.EmailVerified,
+		)
+		return model.ErrIdentityEmailConflict
+	}
+
+	return s.repo.CreateIdentity(ctx, newIdentity(user.ID, ext, now))
+}
+
+// create signs up a new account for the identity
+func (s *identityService) create(ctx context.Context, ext model.ExternalIdentity, now time.Time) (*model.User, error) {
+	user := &model.User{
+		ID:        uuid.NewV4().String(),
+		Email:     ext.Email,
+		Password:  noPassword,
+		Role:      model.RoleUploader,
+		Scopes:    []string{},
+		CreatedAt: now,
+	}
+	if ext.EmailVerified {
+		user.EmailVerifiedAt = &now
+	}
+
+	// Profile details are a courtesy of the provider, invalid ones are dropped
+	if name := ext.DisplayName; validateProfile(&model.ProfileUpdate{DisplayName: &name}) == nil {
+		user.DisplayName = strings.TrimSpace(name)
+	}
+	if avatar := ext.AvatarURL; validateProfile(&model.ProfileUpdate{AvatarURL: &avatar}) == nil {
+		user.AvatarURL = strings.TrimSpace(avatar)
+	}
+
+	base := usernameFromEmail(ext.Email)
+	for attempt := 0; attempt < usernameAttempts; attempt++ {
+		user.Username = base
+		if attempt > 0 {
+			suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+			if err != nil {
+				return nil, fmt.Errorf("generate username failed: %w", err)
+			}
+			user.Username = fmt.Sprintf("%s%04d", base, suffix.Int64())
+		}
+
+		err := s.repo.CreateUserWithIdentity(ctx, user, newIdentity(user.ID, ext, now))
+
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != pgUniqueViolation {
+			return user, err
+		}
+		switch pgErr.ConstraintName {
+		case "users_username_key":
+			continue
+		case "users_email_key":
+			return nil, model.ErrIdentityEmailConflict
+		}
+		return nil, err
+	}
+	return nil, fmt.Errorf("no free username for %q after %d attempts", base, usernameAttempts)
+}
+
+func newIdentity(userID string, ext model.ExternalIdentity, now time.Time) *model.UserIdentity {
+	return &model.UserIdentity{
+		ID:          uuid.NewV4().String(),
+		UserID:      userID,
+		Provider:    ext.Provider,
+		Subject:     ext.Subject,
+		Email:       ext.Email,
+		CreatedAt:   now,
+		LastLoginAt: &now,
+	}
+}
+
+// usernameFromEmail suggests a username from the local part of an address
+func usernameFromEmail(email string) string {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+
+	var b strings.Builder
+	for _, r := range local {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '_' || r == '-' {
+			b.WriteRune(r)
+		}
+		if b.Len() == maxUsernameLength {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "user"
+	}
+	return b.String()
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+var ErrInvalidListFilter = errors.New("invalid video list filter")
+
+// listCursor is the opaque position handed to clients as next_cursor. It
+// carries the sort it was produced for so it cannot be replayed on another.
+type listCursor struct {
+	SortBy     model.VideoSortField `json:"s"`
+	Descending bool                 `json:"d"`
+	Value      string               `json:"v"`
+	ID         string               `json:"id"`
+}
+
+func encodeListCursor(c listCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeListCursor(token string) (listCursor, error) {
+	var c listCursor
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(raw, &c)
+	}
+	if err != nil || c.ID == "" || !c.SortBy.IsValid() {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListFilter)
+	}
+	// Every sort but the title one is by a time
+	if c.SortBy != model.VideoSortTitle {
+		if _, err := time.Parse(time.RFC3339Nano, c.Value); err != nil {
+			return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListFilter)
+		}
+	}
+	return c, nil
+}
+
+// ListVideos returns one page of videos matching filter, starting after
+// cursor, and the cursor of the next page ("" on the last page).
+func (s *videoService) ListVideos(ctx context.Context, filter model.VideoListFilter, cursor string) ([]*model.Video, string, error) {
+	start := time.Now()
+
+	if filter.SortBy == "" {
+		filter.SortBy = model.VideoSortCreatedAt
+	}
+	if !filter.SortBy.IsValid() {
+		return nil, "", fmt.Errorf("%w: unsupported sort field %q", ErrInvalidListFilter, filter.SortBy)
+	}
+	if filter.Status != "" && !filter.Status.IsValid() {
+		return nil, "", fmt.Errorf("%w: unknown status %q", ErrInvalidListFilter, filter.Status)
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultListLimit
+	}
+	if filter.Limit > maxListLimit {
+		filter.Limit = maxListLimit
+	}
+
+	if cursor != "" {
+		c, err := decodeListCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if c.SortBy != filter.SortBy || c.Descending != filter.Descending {
+			return nil, "", fmt.Errorf("%w: cursor belongs to a different sort order", ErrInvalidListFilter)
+		}
+		filter.After = c.Value
+		filter.AfterID = c.ID
+	}
+
+	// Fetch one extra row to learn whether another page exists
+	pageSize := filter.Limit
+	filter.Limit++
+
+	videos, err := s.repo.ListVideos(ctx, filter)
+
+	logger.LogVideoOperation(ctx, "list", "", filter.UserID, 0, time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to list videos",
+			"user_id", filter.UserID,
+			"error", err.Error(),
+		)
+		return nil, "", err
+	}
+
+	var next string
+	if len(videos) > pageSize {
+		videos = videos[:pageSize]
+		last := videos[pageSize-1]
+		next = encodeListCursor(listCursor{
+			SortBy:     filter.SortBy,
+			Descending: filter.Descending,
+			Value:      last.SortValue(filter.SortBy),
+			ID:         last.ID,
+		})
+	}
+
+	return videos, next, nil
+}
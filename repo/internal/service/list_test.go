@@ -0,0 +1,41 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lumbrjx/codek7/repo/internal/model"
+)
+
+func TestDecodeListCursor(t *testing.T) {
+	at := time.Date(2025, 7, 1, 12, 0, 0, 5, time.UTC).Format(time.RFC3339Nano)
+	raw := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"created at", encodeListCursor(listCursor{SortBy: model.VideoSortCreatedAt, Value: at, ID: "v1"}), true},
+		{"updated at, descending", encodeListCursor(listCursor{SortBy: model.VideoSortUpdatedAt, Descending: true, Value: at, ID: "v1"}), true},
+		{"title", encodeListCursor(listCursor{SortBy: model.VideoSortTitle, Value: "any title", ID: "v1"}), true},
+		{"empty title", encodeListCursor(listCursor{SortBy: model.VideoSortTitle, ID: "v1"}), true},
+		{"malformed time", encodeListCursor(listCursor{SortBy: model.VideoSortCreatedAt, Value: "yesterday", ID: "v1"}), false},
+		{"empty time", encodeListCursor(listCursor{SortBy: model.VideoSortUpdatedAt, ID: "v1"}), false},
+		{"unknown sort", encodeListCursor(listCursor{SortBy: "views", Value: at, ID: "v1"}), false},
+		{"no ID", encodeListCursor(listCursor{SortBy: model.VideoSortCreatedAt, Value: at}), false},
+		{"not base64", "!!", false},
+		{"not JSON", raw("{"), false},
+	}
+	for _, tt := range tests {
+		_, err := decodeListCursor(tt.token)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidListFilter) {
+			t.Errorf("%s: err = %v, want ErrInvalidListFilter", tt.name, err)
+		}
+	}
+}
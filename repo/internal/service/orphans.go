@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lumbrjx/codek7/repo/internal/repository"
+	"github.com/lumbrjx/codek7/repo/internal/storage"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+// OrphanReport is the result of one orphan collection pass
+type OrphanReport struct {
+	Scanned    int
+	Orphans    []storage.Object
+	OrphanSize int64
+	Removed    int
+	DryRun     bool
+}
+
+// OrphanCollector finds objects in the bucket that belong to no video and
+// optionally deletes them
+type OrphanCollector struct {
+	repo   repository.VideoRepository
+	store  *storage.MinioClient
+	minAge time.Duration
+}
+
+// NewOrphanCollector creates a collector. Objects younger than minAge are
+// never reported, they may belong to an upload that is still in flight.
+func NewOrphanCollector(repo repository.VideoRepository, store *storage.MinioClient, minAge time.Duration) *OrphanCollector {
+	return &OrphanCollector{
+		repo:   repo,
+		store:  store,
+		minAge: minAge,
+	}
+}
+
+// Collect compares the bucket with the database once. With dryRun it only
+// reports the orphans it found.
+func (c *OrphanCollector) Collect(ctx context.Context, dryRun bool) (*OrphanReport, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Starting orphan object collection",
+		"dry_run", dryRun,
+		"min_age", c.minAge.String(),
+	)
+
+	// List the bucket before loading references so a video created in
+	// between is never mistaken for an orphan
+	objects, err := c.store.ListPrefix(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("list bucket failed: %w", err)
+	}
+
+	objectKeys, err := c.repo.GetReferencedObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &OrphanReport{Scanned: len(objects), DryRun: dryRun}
+	var keys []string
+	for _, obj := range objects {
+		if time.Since(obj.LastModified) < c.minAge || objectKeys[obj.Key] {
+			continue
+		}
+		report.Orphans = append(report.Orphans, obj)
+		report.OrphanSize += obj.Size
+		keys = append(keys, obj.Key)
+	}
+
+	if !dryRun && len(keys) > 0 {
+		failed, err := c.store.RemoveKeys(ctx, keys)
+		report.Removed = len(keys) - failed
+		if err != nil {
+			logger.Logger.Error("Failed to remove some orphan objects",
+				"failed_count", failed,
+				"error", err.Error(),
+			)
+		}
+	}
+
+	logger.LogStorageOperation(ctx, "collect_orphans", "", report.OrphanSize, time.Since(start), nil)
+	logger.Logger.Info("Orphan object collection finished",
+		"dry_run", dryRun,
+		"scanned", report.Scanned,
+		"orphans", len(report.Orphans),
+		"orphan_bytes", report.OrphanSize,
+		"removed", report.Removed,
+	)
+	for _, obj := range report.Orphans {
+		logger.Logger.Info("Orphan object",
+			"object_key", obj.Key,
+			"file_size_bytes", obj.Size,
+			"last_modified", obj.LastModified.Format(time.RFC3339),
+		)
+	}
+
+	return report, nil
+}
+
+// Run collects orphans every interval until ctx is cancelled
+func (c *OrphanCollector) Run(ctx context.Context, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.Collect(ctx, dryRun); err != nil {
+				logger.Logger.Error("Orphan object collection failed",
+					"error", err.Error(),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+const (
+	maxDisplayNameLength = 100
+	maxEmailLength       = 255
+	maxAvatarURLLength   = 2048
+
+	// pgUniqueViolation is the Postgres error code of a unique constraint
+	pgUniqueViolation = "23505"
+)
+
+var (
+	ErrInvalidProfile = errors.New("invalid profile")
+	ErrEmailTaken     = errors.New("email already in use")
+)
+
+// validateProfile normalizes the fields set in update and checks them
+func validateProfile(update *model.ProfileUpdate) error {
+	if update.DisplayName != nil {
+		name := strings.TrimSpace(*update.DisplayName)
+		if len(name) > maxDisplayNameLength {
+			return fmt.Errorf("%w: display name longer than %d characters", ErrInvalidProfile, maxDisplayNameLength)
+		}
+		update.DisplayName = &name
+	}
+	if update.Email != nil {
+		addr, err := mail.ParseAddress(strings.TrimSpace(*update.Email))
+		if err != nil || addr.Name != "" || len(addr.Address) > maxEmailLength {
+			return fmt.Errorf("%w: invalid email address", ErrInvalidProfile)
+		}
+		update.Email = &addr.Address
+	}
+	if update.AvatarURL != nil {
+		raw := strings.TrimSpace(*update.AvatarURL)
+		if raw != "" {
+			u, err := url.Parse(raw)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(raw) > maxAvatarURLLength {
+				return fmt.Errorf("%w: avatar must be an http or https URL", ErrInvalidProfile)
+			}
+		}
+		update.AvatarURL = &raw
+	}
+	return nil
+}
+
+func (s *userService) UpdateProfile(ctx context.Context, userID string, update model.ProfileUpdate) (*model.User, error) {
+	start := time.Now()
+
+	if err := validateProfile(&update); err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.UpdateProfile(ctx, userID, update)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		err = ErrEmailTaken
+	}
+
+	logger.LogUserOperation(ctx, "update_profile", userID, "", time.Since(start), err)
+
+	return user, err
+}
+
+// ChangePassword stores a new password once current is confirmed. A wrong
+// current password fails with ErrInvalidCredentials.
+func (s *userService) ChangePassword(ctx context.Context, userID, current, password string) error {
+	start := time.Now()
+
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.VerifyCredentials(ctx, user.Username, current); err != nil {
+		return err
+	}
+
+	_, err = s.repo.UpdatePassword(ctx, userID, hash)
+
+	logger.LogUserOperation(ctx, "change_password", userID, "", time.Since(start), err)
+
+	return err
+}
+
+// DeleteUser removes the account row, its videos must already be purged
+func (s *userService) DeleteUser(ctx context.Context, userID string) error {
+	start := time.Now()
+
+	err := s.repo.DeleteUser(ctx, userID)
+
+	logger.LogUserOperation(ctx, "delete", userID, "", time.Since(start), err)
+
+	return err
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/repository"
+	"github.com/lumbrjx/codek7/repo/internal/storage"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+const (
+	// purgeBatchSize bounds how many videos one pass purges
+	purgeBatchSize = 100
+	// purgeRetryBase and purgeRetryMax bound the backoff between attempts
+	// to purge a video whose objects could not be removed
+	purgeRetryBase = time.Minute
+	purgeRetryMax  = 6 * time.Hour
+)
+
+// VideoPurger permanently removes videos that stayed in the trash longer
+// than the retention period, objects first and database rows last
+type VideoPurger struct {
+	repo      repository.VideoRepository
+	assets    repository.AssetRepository
+	store     *storage.MinioClient
+	retention time.Duration
+}
+
+func NewVideoPurger(repo repository.VideoRepository, assets repository.AssetRepository, store *storage.MinioClient, retention time.Duration) *VideoPurger {
+	return &VideoPurger{
+		repo:      repo,
+		assets:    assets,
+		store:     store,
+		retention: retention,
+	}
+}
+
+// purgeRetryDelay doubles the delay with every failed attempt
+func purgeRetryDelay(attempts int) time.Duration {
+	delay := purgeRetryBase
+	for i := 0; i < attempts && delay < purgeRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, purgeRetryMax)
+}
+
+// Purge removes one batch of expired videos and returns how many were purged.
+// A video whose objects cannot all be removed keeps its row and is retried
+// later; removal is idempotent so a retry starts over safely.
+func (p *VideoPurger) Purge(ctx context.Context) (int, error) {
+	start := time.Now()
+
+	videos, err := p.repo.GetPurgeableVideos(ctx, time.Now().Add(-p.retention), purgeBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("list purgeable videos failed: %w", err)
+	}
+
+	var purged int
+	for _, v := range videos {
+		if err := p.purgeVideo(ctx, v); err != nil {
+			retryAt := time.Now().Add(purgeRetryDelay(v.PurgeAttempts))
+			logger.Logger.Warn("Failed to purge video, will retry",
+				"video_id", v.ID,
+				"attempts", v.PurgeAttempts+1,
+				"retry_at", retryAt.Format(time.RFC3339),
+				"error", err.Error(),
+			)
+			if err := p.repo.RecordPurgeFailure(ctx, v.ID, retryAt, err.Error()); err != nil {
+				logger.Logger.Error("Failed to record purge failure",
+					"video_id", v.ID,
+					"error", err.Error(),
+				)
+			}
+			continue
+		}
+		purged++
+	}
+
+	logger.Logger.Info("Trash purge completed",
+		"candidates", len(videos),
+		"purged", purged,
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+
+	return purged, nil
+}
+
+func (p *VideoPurger) purgeVideo(ctx context.Context, video *model.Video) error {
+	start := time.Now()
+
+	if err := p.removeObjects(ctx, video); err != nil {
+		return err
+	}
+
+	// The row goes last, once it is gone nothing points at the objects anymore
+	err := p.repo.DeleteVideo(ctx, video.ID)
+
+	logger.LogVideoOperation(ctx, "purge", video.ID, video.UserID, 0, time.Since(start), err)
+
+	if err != nil {
+		return fmt.Errorf("delete video metadata failed: %w", err)
+	}
+	return nil
+}
+
+// removeObjects removes the original and every object recorded in the
+// video's asset manifest. Missing objects are not an error, so it can run
+// again after a partial failure.
+func (p *VideoPurger) removeObjects(ctx context.Context, video *model.Video) error {
+	assets, err := p.assets.GetAssetsByVideo(ctx, video.ID)
+	if err != nil {
+		return fmt.Errorf("list video assets failed: %w", err)
+	}
+
+	keys := []string{video.FileName}
+	for _, a := range assets {
+		if a.ObjectKey != video.FileName {
+			keys = append(keys, a.ObjectKey)
+		}
+	}
+
+	if _, err := p.store.RemoveKeys(ctx, keys); err != nil {
+		return fmt.Errorf("remove video objects failed: %w", err)
+	}
+	return nil
+}
+
+// PurgeOwner permanently removes every video of a user, trashed or not,
+// ahead of deleting the account. It stops at the first video that cannot be
+// removed so the account, and the record of its remaining objects, stays
+// until a retry succeeds.
+func (p *VideoPurger) PurgeOwner(ctx context.Context, userID string) (int, error) {
+	videos, err := p.repo.GetVideosByOwner(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("list owner videos failed: %w", err)
+	}
+
+	for i, v := range videos {
+		if err := p.purgeVideo(ctx, v); err != nil {
+			return i, fmt.Errorf("purge video %s failed: %w", v.ID, err)
+		}
+	}
+
+	logger.Logger.Info("Owner videos purged",
+		"user_id", userID,
+		"purged", len(videos),
+	)
+
+	return len(videos), nil
+}
+
+// Run purges expired videos every interval until ctx is cancelled
+func (p *VideoPurger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := p.Purge(ctx); err != nil {
+				logger.Logger.Error("Trash purge failed",
+					"error", err.Error(),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/repository"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+var ErrInvalidQuota = errors.New("invalid quota")
+
+type QuotaService interface {
+	// GetQuota returns the usage of a user with the limits in effect
+	GetQuota(ctx context.Context, userID string) (*model.Quota, error)
+	// CheckUpload fails with model.ErrQuotaExceeded when the user may not
+	// upload an original of size bytes, 0 if the size is not known yet
+	CheckUpload(ctx context.Context, userID string, size int64) (*model.Quota, error)
+	// SetLimits changes the limits of a user
+	SetLimits(ctx context.Context, userID string, update model.QuotaUpdate) (*model.Quota, error)
+}
+
+type quotaService struct {
+	repo     repository.QuotaRepository
+	defaults model.QuotaLimits
+}
+
+// NewQuotaService applies defaults to users without limits of their own
+func NewQuotaService(repo repository.QuotaRepository, defaults model.QuotaLimits) QuotaService {
+	return &quotaService{repo: repo, defaults: defaults}
+}
+
+func (s *quotaService) GetQuota(ctx context.Context, userID string) (*model.Quota, error) {
+	q, err := s.repo.GetQuota(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	q.Resolve(s.defaults)
+	return q, nil
+}
+
+func (s *quotaService) CheckUpload(ctx context.Context, userID string, size int64) (*model.Quota, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("%w: size cannot be negative", ErrInvalidQuota)
+	}
+	q, err := s.GetQuota(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.AllowsUpload(size); err != nil {
+		logger.Logger.Info("Upload refused by quota",
+			"user_id", userID,
+			"file_size_bytes", size,
+			"bytes_used", q.BytesUsed,
+			"video_count", q.VideoCount,
+		)
+		return q, err
+	}
+	return q, nil
+}
+
+func (s *quotaService) SetLimits(ctx context.Context, userID string, update model.QuotaUpdate) (*model.Quota, error) {
+	start := time.Now()
+
+	q, err := s.repo.GetQuota(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := q.Overrides
+	overrides.MaxBytes = applyLimit(overrides.MaxBytes, update.MaxBytes)
+	overrides.MaxFileBytes = applyLimit(overrides.MaxFileBytes, update.MaxFileBytes)
+	if update.MaxVideos != nil {
+		overrides.MaxVideos = nil
+		if n := *update.MaxVideos; n >= 0 {
+			overrides.MaxVideos = &n
+		}
+	}
+
+	q, err = s.repo.SetQuotaOverrides(ctx, userID, overrides)
+
+	logger.LogUserOperation(ctx, "set_quota", userID, "", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	q.Resolve(s.defaults)
+	return q, nil
+}
+
+// applyLimit returns the override after update, see model.QuotaUpdate
+func applyLimit(current, update *int64) *int64 {
+	if update == nil {
+		return current
+	}
+	if *update < 0 {
+		return nil
+	}
+	n := *update
+	return &n
+}
+
+// quotaReader fails uploads once they read more than limit bytes
+type quotaReader struct {
+	r        io.Reader
+	limit    int64
+	n        int64
+	exceeded bool
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.n += int64(n)
+	if q.n > q.limit {
+		q.exceeded = true
+		return n, fmt.Errorf("%w: upload is larger than the %d bytes left", model.ErrQuotaExceeded, q.limit)
+	}
+	return n, err
+}
@@ -0,0 +1,393 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lumbrjx/codek7/repo/internal/model"
+	"github.com/lumbrjx/codek7/repo/internal/repository"
+	"github.com/lumbrjx/codek7/repo/pkg/logger"
+)
+
+const (
+	// RFC 6238 defaults, the only parameters every authenticator app supports
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many periods before and after now are accepted, to
+	// allow for clock drift and typing time
+	totpSkew = 1
+
+	totpSecretBytes    = 20
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+	// recoveryAlphabet has 32 symbols, so a random byte maps to one without
+	// bias, and leaves out the easily confused 0, O, 1 and I
+	recoveryAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	// sealedSecretVersion prefixes encrypted secrets so the format can change
+	sealedSecretVersion = "v1:"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TotpEnrollment is a pending secret for the user's authenticator app
+type TotpEnrollment struct {
+	Secret string
+	// URI is the otpauth:// provisioning URI, shown as a QR code
+	URI string
+}
+
+type TotpStatus struct {
+	Enabled                bool
+	RecoveryCodesRemaining int
+}
+
+type TotpService interface {
+	// BeginEnrollment generates a secret that becomes active once confirmed
+	BeginEnrollment(ctx context.Context, userID string) (*TotpEnrollment, error)
+	// ConfirmEnrollment enables 2FA with a first code from the app and
+	// returns the recovery codes, which are only shown this once
+	ConfirmEnrollment(ctx context.Context, userID, code string) ([]string, error)
+	// Verify checks a TOTP or recovery code. Each code works only once.
+	Verify(ctx context.Context, userID, code string) (*model.User, error)
+	RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error)
+	Disable(ctx context.Context, userID, code string) error
+	Status(ctx context.Context, userID string) (*TotpStatus, error)
+}
+
+type totpService struct {
+	repo   repository.TotpRepository
+	users  repository.UserRepository
+	aead   cipher.AEAD // nil when no encryption key is configured
+	issuer string
+}
+
+// NewTotpService encrypts secrets with key, a 32 byte AES-256 key. Without
+// a key every operation fails with model.ErrTotpNotConfigured.
+func NewTotpService(repo repository.TotpRepository, users repository.UserRepository, key []byte, issuer string) (TotpService, error) {
+	s := &totpService{repo: repo, users: users, issuer: issuer}
+	if len(key) > 0 {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("totp encryption key: %w", err)
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("totp encryption key: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// seal encrypts a secret. The user ID is authenticated with it, so a
+// ciphertext copied to another user's row does not decrypt.
+func (s *totpService) seal(userID string, secret []byte) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, secret, []byte(userID))
+	return sealedSecretVersion + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *totpService) open(userID, sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedSecretVersion))
+	if err != nil || !strings.HasPrefix(sealed, sealedSecretVersion) || len(data) < s.aead.NonceSize() {
+		return nil, errors.New("malformed totp secret")
+	}
+	n := s.aead.NonceSize()
+	return s.aead.Open(nil, data[:n], data[n:], []byte(userID))
+}
+
+// totpCode is the RFC 4226 HOTP value of secret at counter step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// matchTotp returns the time step code is valid for around now
+func matchTotp(secret []byte, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for d := int64(-totpSkew); d <= totpSkew; d++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, current+d)), []byte(code)) == 1 {
+			return current + d, true
+		}
+	}
+	return 0, false
+}
+
+// normalizeCode strips the spaces and dashes users type or paste
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func isTotpCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRecoveryCodes returns fresh codes formatted XXXXX-XXXXX and their hashes
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		for j := range b {
+			b[j] = recoveryAlphabet[int(b[j])%len(recoveryAlphabet)]
+		}
+		code := string(b[:recoveryCodeLength/2]) + "-" + string(b[recoveryCodeLength/2:])
+		codes = append(codes, code)
+		hashes = append(hashes, hashSecret(normalizeCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+func (s *totpService) BeginEnrollment(ctx context.Context, userID string) (*TotpEnrollment, error) {
+	start := time.Now()
+
+	if s.aead == nil {
+		return nil, model.ErrTotpNotConfigured
+	}
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generate totp secret failed: %w", err)
+	}
+	sealed, err := s.seal(userID, secret)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt totp secret failed: %w", err)
+	}
+
+	err = s.repo.SaveTotpEnrollment(ctx, userID, sealed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = model.ErrTotpAlreadyEnabled
+	}
+
+	logger.LogUserOperation(ctx, "totp_enroll", userID, user.Username, time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := totpEncoding.EncodeToString(secret)
+	query := url.Values{
+		"secret":    {encoded},
+		"issuer":    {s.issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + s.issuer + ":" + user.Username,
+		RawQuery: query.Encode(),
+	}
+	return &TotpEnrollment{Secret: encoded, URI: uri.String()}, nil
+}
+
+// loadSecret returns the user's 2FA record and decrypted secret
+func (s *totpService) loadSecret(ctx context.Context, userID string) (*model.UserTotp, []byte, error) {
+	if s.aead == nil {
+		return nil, nil, model.ErrTotpNotConfigured
+	}
+	t, err := s.repo.GetTotp(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, model.ErrTotpNotEnrolled
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := s.open(userID, t.SecretEncrypted)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decrypt totp secret failed: %w", err)
+	}
+	return t, secret, nil
+}
+
+func (s *totpService) ConfirmEnrollment(ctx context.Context, userID, code string) ([]string, error) {
+	start := time.Now()
+
+	t, secret, err := s.loadSecret(ctx, userID)
+	if errors.Is(err, model.ErrTotpNotEnrolled) {
+		return nil, model.ErrTotpEnrollmentStale
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.Enabled() {
+		return nil, model.ErrTotpAlreadyEnabled
+	}
+
+	step, ok := matchTotp(secret, normalizeCode(code), start)
+	if !ok {
+		logger.LogUserOperation(ctx, "totp_confirm", userID, "", time.Since(start), model.ErrTotpInvalidCode)
+		return nil, model.ErrTotpInvalidCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("generate recovery codes failed: %w", err)
+	}
+
+	err = s.repo.EnableTotp(ctx, userID, step, hashes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = model.ErrTotpAlreadyEnabled
+	}
+
+	logger.LogUserOperation(ctx, "totp_confirm", userID, "", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Info("Two-factor authentication enabled",
+		"user_id", userID,
+	)
+
+	return codes, nil
+}
+
+// checkCode accepts a current TOTP code or an unused recovery code of an
+// enabled 2FA record, spending it
+func (s *totpService) checkCode(ctx context.Context, userID, code string) error {
+	t, secret, err := s.loadSecret(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !t.Enabled() {
+		return model.ErrTotpNotEnrolled
+	}
+
+	code = normalizeCode(code)
+	if isTotpCode(code) {
+		step, ok := matchTotp(secret, code, time.Now())
+		if !ok {
+			return model.ErrTotpInvalidCode
+		}
+		// A code seen once, or one older than the last accepted, is a replay
+		fresh, err := s.repo.UseTotpStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return model.ErrTotpInvalidCode
+		}
+		return nil
+	}
+
+	used, err := s.repo.UseRecoveryCode(ctx, userID, hashSecret(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return model.ErrTotpInvalidCode
+	}
+
+	logger.Logger.Info("Recovery code used",
+		"user_id", userID,
+	)
+	return nil
+}
+
+func (s *totpService) Verify(ctx context.Context, userID, code string) (*model.User, error) {
+	start := time.Now()
+
+	err := s.checkCode(ctx, userID, code)
+
+	logger.LogUserOperation(ctx, "totp_verify", userID, "", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	return s.users.GetUserByID(ctx, userID)
+}
+
+func (s *totpService) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	start := time.Now()
+
+	if err := s.checkCode(ctx, userID, code); err != nil {
+		logger.LogUserOperation(ctx, "totp_recovery_codes", userID, "", time.Since(start), err)
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("generate recovery codes failed: %w", err)
+	}
+	err = s.repo.ReplaceRecoveryCodes(ctx, userID, hashes)
+
+	logger.LogUserOperation(ctx, "totp_recovery_codes", userID, "", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *totpService) Disable(ctx context.Context, userID, code string) error {
+	start := time.Now()
+
+	err := s.checkCode(ctx, userID, code)
+	if err == nil {
+		err = s.repo.DeleteTotp(ctx, userID)
+	}
+
+	logger.LogUserOperation(ctx, "totp_disable", userID, "", time.Since(start), err)
+
+	if err != nil {
+		return err
+	}
+
+	logger.Logger.Info("Two-factor authentication disabled",
+		"user_id", userID,
+	)
+	return nil
+}
+
+func (s *totpService) Status(ctx context.Context, userID string) (*TotpStatus, error) {
+	t, err := s.repo.GetTotp(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !t.Enabled()) {
+		return &TotpStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := s.repo.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &TotpStatus{Enabled: true, RecoveryCodesRemaining: n}, nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 appendix B, the last six of the eight digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Secret, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTotp(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		ok       bool
+	}{
+		{"current", totpCode(rfc6238Secret, step), step, true},
+		{"previous", totpCode(rfc6238Secret, step-1), step - 1, true},
+		{"next", totpCode(rfc6238Secret, step+1), step + 1, true},
+		{"too old", totpCode(rfc6238Secret, step-2), 0, false},
+		{"too new", totpCode(rfc6238Secret, step+2), 0, false},
+		{"empty", "", 0, false},
+		{"too long", totpCode(rfc6238Secret, step) + "0", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := matchTotp(rfc6238Secret, tt.code, now)
+		if ok != tt.ok || got != tt.wantStep {
+			t.Errorf("%s: matchTotp = %d, %v, want %d, %v", tt.name, got, ok, tt.wantStep, tt.ok)
+		}
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"123 456", "123456"},
+		{"abcde-fghjk", "ABCDEFGHJK"},
+		{" ab cd-e ", "ABCDE"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeCode(tt.in); got != tt.want {
+			t.Errorf("normalizeCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsTotpCode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"123456", true},
+		{"000000", true},
+		{"12345", false},
+		{"1234567", false},
+		{"12345a", false},
+		{"ABCDEFGHJK", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isTotpCode(tt.in); got != tt.want {
+			t.Errorf("isTotpCode(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+	for i, code := range codes {
+		if len(code) != recoveryCodeLength+1 || code[recoveryCodeLength/2] != '-' {
+			t.Errorf("code %q is not formatted XXXXX-XXXXX", code)
+		}
+		if strings.Trim(strings.Replace(code, "-", "", 1), recoveryAlphabet) != "" {
+			t.Errorf("code %q has symbols outside the alphabet", code)
+		}
+		if hashes[i] != hashSecret(normalizeCode(code)) {
+			t.Errorf("hash %d does not match its code", i)
+		}
+	}
+}
+
+func TestSealSecret(t *testing.T) {
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &totpService{aead: aead}
+
+	sealed, err := s.seal("user-1", rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		userID string
+		sealed string
+		ok     bool
+	}{
+		{"same user", "user-1", sealed, true},
+		{"other user", "user-2", sealed, false},
+		{"no version", "user-1", strings.TrimPrefix(sealed, sealedSecretVersion), false},
+		{"not base64", "user-1", sealedSecretVersion + "!!", false},
+		{"too short", "user-1", sealedSecretVersion + "AAAA", false},
+	}
+	for _, tt := range tests {
+		got, err := s.open(tt.userID, tt.sealed)
+		if tt.ok {
+			if err != nil || !bytes.Equal(got, rfc6238Secret) {
+				t.Errorf("%s: open = %q, %v", tt.name, got, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: open succeeded, want an error", tt.name)
+		}
+	}
+}
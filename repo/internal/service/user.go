@@ -3,6 +3,8 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/lumbrjx/codek7/repo/internal/model"
@@ -13,14 +15,57 @@ import (
 type UserService interface {
 	CreateUser(ctx context.Context, password, email, username string) (*model.User, error)
 	GetUser(ctx context.Context, id string) (*model.User, error)
+	GetUserByID(ctx context.Context, id string) (*model.User, error)
+	ListUsers(ctx context.Context, limit, offset int) ([]*model.User, error)
+	SetUserRole(ctx context.Context, userID string, role model.UserRole, scopes []string) (*model.User, error)
+	PromoteAdmins(ctx context.Context, usernames []string) error
+
+	// VerifyCredentials checks a password against the account named by
+	// username or email, see credentials.go
+	VerifyCredentials(ctx context.Context, usernameOrEmail, password string) (*model.User, error)
+	ResolveLogin(ctx context.Context, usernameOrEmail string) (string, error)
+
+	// Profile management, see profile.go
+	UpdateProfile(ctx context.Context, userID string, update model.ProfileUpdate) (*model.User, error)
+	ChangePassword(ctx context.Context, userID, current, password string) error
+	DeleteUser(ctx context.Context, userID string) error
+
+	// Email verification and password reset, see account.go
+	RequestEmailVerification(ctx context.Context, userID string) (*IssuedToken, error)
+	ConfirmEmail(ctx context.Context, token string) (*model.User, error)
+	RequestPasswordReset(ctx context.Context, email string) (*IssuedToken, error)
+	ResetPassword(ctx context.Context, token, password string) (*model.User, error)
+}
+
+var (
+	ErrInvalidRole   = errors.New("invalid user role")
+	ErrInvalidScopes = errors.New("invalid scopes")
+)
+
+// validateScopes checks scopes against the catalogue and drops duplicates
+func validateScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	out := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		if !model.Scope(s).IsValid() {
+			return nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidScopes, s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out, nil
 }
 
 type userService struct {
-	repo repository.UserRepository
+	repo       repository.UserRepository
+	tokens     repository.UserTokenRepository
+	bcryptCost int
 }
 
-func NewUserService(repo repository.UserRepository) UserService {
-	return &userService{repo: repo}
+func NewUserService(repo repository.UserRepository, tokens repository.UserTokenRepository, bcryptCost int) UserService {
+	return &userService{repo: repo, tokens: tokens, bcryptCost: bcryptCost}
 }
 
 func (s *userService) CreateUser(ctx context.Context, password, email, username string) (*model.User, error) {
@@ -31,7 +76,12 @@ func (s *userService) CreateUser(ctx context.Context, password, email, username
 		"email", email,
 	)
 
-	user, err := s.repo.CreateUser(ctx, password, email, username)
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.CreateUser(ctx, hash, email, username)
 
 	logger.LogUserOperation(ctx, "create", "", username, time.Since(start), err)
 
@@ -78,3 +128,59 @@ func (s *userService) GetUser(ctx context.Context, username string) (*model.User
 
 	return user, nil
 }
+
+func (s *userService) GetUserByID(ctx context.Context, id string) (*model.User, error) {
+	return s.repo.GetUserByID(ctx, id)
+}
+
+func (s *userService) ListUsers(ctx context.Context, limit, offset int) ([]*model.User, error) {
+	return s.repo.ListUsers(ctx, limit, offset)
+}
+
+// SetUserRole replaces the role and extra scopes of a user
+func (s *userService) SetUserRole(ctx context.Context, userID string, role model.UserRole, scopes []string) (*model.User, error) {
+	start := time.Now()
+
+	if !role.IsValid() {
+		return nil, ErrInvalidRole
+	}
+	scopes, err := validateScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.SetUserRole(ctx, userID, role, scopes)
+
+	logger.LogUserOperation(ctx, "set_role", userID, "", time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger.Info("User role updated",
+		"user_id", user.ID,
+		"role", user.Role,
+		"scopes", user.Scopes,
+	)
+
+	return user, nil
+}
+
+// PromoteAdmins makes sure the named users are admins, so a fresh install
+// has someone who can manage roles
+func (s *userService) PromoteAdmins(ctx context.Context, usernames []string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	n, err := s.repo.PromoteAdmins(ctx, usernames)
+	if err != nil {
+		return err
+	}
+
+	logger.Logger.Info("Admin users ensured",
+		"usernames", usernames,
+		"promoted", n,
+	)
+	return nil
+}
@@ -2,10 +2,13 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"io"
+	"path"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/lumbrjx/codek7/repo/internal/model"
 	"github.com/lumbrjx/codek7/repo/internal/repository"
 	"github.com/lumbrjx/codek7/repo/internal/storage"
@@ -14,120 +17,207 @@ import (
 )
 
 type VideoService interface {
-	// Original video upload - creates metadata in DB
-	UploadOriginalVideo(ctx context.Context, userID, title, description, originalFileName string, content []byte) (*model.Video, error)
+	// Original video upload - streams content to MinIO and creates metadata in DB.
+	// A repeated uploadID returns the video stored the first time. size is the
+	// size the client declared, 0 if unknown, and is reserved in the quota.
+	UploadOriginalVideo(ctx context.Context, userID, uploadID, title, description, originalFileName string, size int64, media model.MediaInfo, content io.Reader) (*model.Video, error)
 
-	// Generated files upload - only saves to MinIO, no DB metadata
-	UploadGeneratedFile(ctx context.Context, fileName string, content []byte) error
+	// Generated files upload - only streams to MinIO, no DB metadata
+	UploadGeneratedFile(ctx context.Context, fileName string, content io.Reader) error
 
 	// Query operations
 	GetVideoByID(ctx context.Context, videoID string) (*model.Video, error)
 	GetVideosByUser(ctx context.Context, userID string) ([]*model.Video, error)
 	GetLast3VideosByUser(ctx context.Context, userID string) ([]*model.Video, error)
+	ListVideos(ctx context.Context, filter model.VideoListFilter, cursor string) ([]*model.Video, string, error)
 	// Download operations
-	DownloadFile(ctx context.Context, fileName string) ([]byte, string, error)
+	OpenFile(ctx context.Context, fileName string, offset, length int64) (*FileRange, error)
 
-	// Remove operations
+	// Remove operations, removed videos stay in the trash until purged
 	RemoveVideo(ctx context.Context, videoID string) error
+	RestoreVideo(ctx context.Context, videoID string) (*model.Video, error)
+
+	// Status operations
+	UpdateVideoStatus(ctx context.Context, videoID string, status model.VideoStatus, reason string) (*model.Video, error)
+	GetStatusHistory(ctx context.Context, videoID string) ([]*model.VideoStatusChange, error)
+
+	// Asset manifest operations
+	RegisterAssets(ctx context.Context, videoID string, assets []*model.VideoAsset) ([]*model.VideoAsset, error)
+	GetAssetsByVideo(ctx context.Context, videoID string) ([]*model.VideoAsset, error)
+	IsGeneratedFile(ctx context.Context, fileName string) (bool, error)
+
+	// Access control
+	AuthorizeVideo(ctx context.Context, callerID, videoID string, action VideoAction) (*model.Video, error)
+	AuthorizeFile(ctx context.Context, callerID, fileName string) (*model.Video, error)
+	SetVisibility(ctx context.Context, callerID, videoID string, visibility model.VideoVisibility, sharedWith []string) (*model.Video, error)
 }
 
 type videoService struct {
-	repo  repository.VideoRepository
-	store *storage.MinioClient
+	repo   repository.VideoRepository
+	assets repository.AssetRepository
+	store  *storage.MinioClient
+	quotas QuotaService
 }
 
-func NewVideoService(repo repository.VideoRepository, store *storage.MinioClient) VideoService {
+func NewVideoService(repo repository.VideoRepository, assets repository.AssetRepository, store *storage.MinioClient, quotas QuotaService) VideoService {
 	return &videoService{
-		repo:  repo,
-		store: store,
+		repo:   repo,
+		assets: assets,
+		store:  store,
+		quotas: quotas,
 	}
 }
 
 // UploadOriginalVideo handles the initial video upload with metadata
-func (s *videoService) UploadOriginalVideo(ctx context.Context, userID, title, description, fileName string, content []byte) (*model.Video, error) {
+func (s *videoService) UploadOriginalVideo(ctx context.Context, userID, uploadID, title, description, fileName string, size int64, media model.MediaInfo, content io.Reader) (*model.Video, error) {
 	start := time.Now()
-	fileSize := int64(len(content))
 
 	logger.Logger.Info("Starting original video upload",
 		"user_id", userID,
 		"title", title,
 		"filename", fileName,
-		"file_size_bytes", fileSize,
 	)
 
-	if userID == "" || title == "" || fileName == "" || len(content) == 0 {
+	if userID == "" || title == "" || fileName == "" {
 		err := fmt.Errorf("invalid input: userID, title, and fileName cannot be empty")
 		logger.Logger.Error("Invalid video upload parameters",
 			"user_id", userID,
 			"title", title,
 			"filename", fileName,
-			"content_size", len(content),
 		)
 		return nil, err
 	}
 
-	// Generate unique video ID
-	videoID := uuid.NewV4().String()
+	// The video is stored under the ID the gateway gave the upload, which
+	// the client holds and the transcoder names its files after
+	videoID := uploadID
+	if videoID == "" {
+		videoID = uuid.NewV4().String()
+	} else {
+		if _, err := uuid.FromString(videoID); err != nil {
+			return nil, fmt.Errorf("%w: %q is not a UUID", ErrInvalidUploadID, uploadID)
+		}
 
-	base := strings.TrimSuffix(fileName, ".mp4")
-	// Create the original filename with video ID
-	originalFileName := fmt.Sprintf("%s_original.mp4", base)
+		release, ok, err := s.repo.LockUpload(ctx, videoID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrUploadInProgress
+		}
+		defer release()
 
-	logger.Logger.Info("Uploading original video to storage",
-		"video_id", videoID,
-		"original_filename", originalFileName,
-		"user_id", userID,
-	)
+		existing, err := s.resumeUpload(ctx, userID, videoID)
+		if err != nil || existing != nil {
+			return existing, err
+		}
+	}
 
-	// Upload original file to MinIO
-	if err := s.store.Upload(ctx, originalFileName, content); err != nil {
-		logger.Logger.Error("Failed to upload video to MinIO",
-			"video_id", videoID,
-			"filename", originalFileName,
-			"error", err.Error(),
-		)
-		return nil, fmt.Errorf("upload to MinIO failed: %w", err)
+	// The gateway checks the quota before ingesting, this catches clients
+	// that skip it. Uploads that race each other are caught by the
+	// reservation CreateVideo makes.
+	quota, err := s.quotas.CheckUpload(ctx, userID, size)
+	if err != nil {
+		return nil, err
+	}
+	var limited *quotaReader
+	if limit := quota.UploadLimit(); limit > 0 {
+		limited = &quotaReader{r: content, limit: limit}
+		content = limited
 	}
 
-	logger.Logger.Info("Video uploaded to storage successfully",
-		"video_id", videoID,
-		"filename", originalFileName,
-	)
+	// Keep the container's extension, files without one are taken for MP4
+	ext := path.Ext(fileName)
+	if ext == "" {
+		ext = ".mp4"
+	}
+	// Create the original filename with video ID
+	originalFileName := fmt.Sprintf("%s_original%s", videoID, ext)
 
-	// Create video metadata (only for original videos)
+	// Create video metadata first so the upload is visible while it streams
 	video := &model.Video{
 		ID:          videoID,
 		UserID:      userID,
 		Title:       title,
 		Description: description,
 		FileName:    originalFileName,
+		Status:      model.VideoStatusUploading,
 		CreatedAt:   time.Now(),
+		Media:       media,
 	}
 
 	logger.Logger.Info("Creating video metadata in database",
 		"video_id", videoID,
 	)
 
-	// Store metadata in database
-	v, err := s.repo.CreateVideo(ctx, video)
+	v, err := s.repo.CreateVideo(ctx, video, size, quota.Limits)
+	if errors.Is(err, model.ErrQuotaExceeded) {
+		return nil, err
+	}
 	if err != nil {
-		logger.Logger.Error("Failed to create video metadata, cleaning up storage",
+		logger.Logger.Error("Failed to create video metadata",
 			"video_id", videoID,
 			"filename", originalFileName,
 			"error", err.Error(),
 		)
-		// Cleanup MinIO on database failure
-		if cleanupErr := s.store.Remove(ctx, originalFileName); cleanupErr != nil {
-			logger.Logger.Error("Failed to cleanup storage after database error",
-				"video_id", videoID,
-				"filename", originalFileName,
-				"cleanup_error", cleanupErr.Error(),
-			)
-			return nil, fmt.Errorf("failed to create video metadata: %w, and failed to cleanup MinIO: %v", err, cleanupErr)
-		}
 		return nil, fmt.Errorf("failed to create video metadata: %w", err)
 	}
 
+	logger.Logger.Info("Uploading original video to storage",
+		"video_id", videoID,
+		"original_filename", originalFileName,
+		"user_id", userID,
+	)
+
+	// Stream original file to MinIO
+	fileSize, err := s.store.Upload(ctx, originalFileName, content, -1)
+	if err == nil && fileSize == 0 {
+		err = fmt.Errorf("invalid input: content cannot be empty")
+	}
+	if limited != nil && limited.exceeded {
+		err = fmt.Errorf("%w: upload is larger than the %d bytes left", model.ErrQuotaExceeded, limited.limit)
+	}
+	if err == nil {
+		err = s.repo.RecordOriginalSize(ctx, videoID, fileSize, quota.Limits)
+	}
+	if err != nil {
+		logger.Logger.Error("Failed to store original video, marking video as failed",
+			"video_id", videoID,
+			"filename", originalFileName,
+			"error", err.Error(),
+		)
+		s.failUpload(v, originalFileName, err)
+		return nil, fmt.Errorf("upload to MinIO failed: %w", err)
+	}
+
+	logger.Logger.Info("Video uploaded to storage successfully",
+		"video_id", videoID,
+		"filename", originalFileName,
+	)
+
+	if err := s.repo.UpdateVideoStatus(ctx, videoID, model.VideoStatusUploading, model.VideoStatusTranscoding, ""); err != nil {
+		logger.Logger.Error("Failed to mark video as transcoding",
+			"video_id", videoID,
+			"error", err.Error(),
+		)
+		return nil, fmt.Errorf("failed to update video status: %w", err)
+	}
+	v.Status = model.VideoStatusTranscoding
+
+	// Record the original in the asset manifest; the video row still
+	// references it through FileName if this bookkeeping fails
+	if _, err := s.assets.UpsertAssets(ctx, []*model.VideoAsset{{
+		VideoID:   videoID,
+		Kind:      model.AssetKindOriginal,
+		ByteSize:  fileSize,
+		ObjectKey: originalFileName,
+	}}); err != nil {
+		logger.Logger.Warn("Failed to record original video asset",
+			"video_id", videoID,
+			"error", err.Error(),
+		)
+	}
+
 	logger.LogVideoOperation(ctx, "upload_original", videoID, userID, fileSize, time.Since(start), nil)
 	logger.Logger.Info("Original video uploaded successfully",
 		"video_id", videoID,
@@ -139,27 +229,99 @@ func (s *videoService) UploadOriginalVideo(ctx context.Context, userID, title, d
 	return v, nil
 }
 
+// resumeUpload returns the video an earlier delivery of the upload stored,
+// so a handoff replayed after a crash does not store it twice. The caller
+// holds the upload's lock, so a video still uploading was left by a
+// delivery that died; it is failed, and failed videos are removed so the
+// upload starts over. It returns nil then, and when the upload is new.
+func (s *videoService) resumeUpload(ctx context.Context, userID, videoID string) (*model.Video, error) {
+	v, err := s.repo.GetVideoByID(ctx, videoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v.UserID != userID {
+		return nil, fmt.Errorf("%w: video %s belongs to another user", ErrInvalidUploadID, videoID)
+	}
+
+	switch v.Status {
+	case model.VideoStatusUploading:
+		logger.Logger.Info("Failing interrupted video of a repeated upload",
+			"video_id", v.ID,
+		)
+		err := s.repo.UpdateVideoStatus(ctx, v.ID, model.VideoStatusUploading, model.VideoStatusFailed, "upload was interrupted")
+		if err != nil {
+			return nil, err
+		}
+	case model.VideoStatusFailed:
+	default:
+		logger.Logger.Info("Upload was stored before, returning its video",
+			"video_id", v.ID,
+			"status", string(v.Status),
+		)
+		return v, nil
+	}
+
+	logger.Logger.Info("Removing failed video of a repeated upload",
+		"video_id", v.ID,
+	)
+	if err := s.store.Remove(ctx, v.FileName); err != nil {
+		return nil, fmt.Errorf("remove unfinished original failed: %w", err)
+	}
+	if err := s.repo.DeleteVideo(ctx, v.ID); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// failUpload marks a video whose original could not be stored as failed,
+// releases its reservation and drops any partial object. It runs detached
+// from the request context, which is usually what got cancelled.
+func (s *videoService) failUpload(v *model.Video, objectKey string, cause error) {
+	ctx := context.Background()
+
+	if err := s.repo.UpdateVideoStatus(ctx, v.ID, v.Status, model.VideoStatusFailed, cause.Error()); err != nil {
+		logger.Logger.Error("Failed to mark video as failed",
+			"video_id", v.ID,
+			"error", err.Error(),
+		)
+	}
+	if err := s.repo.ReleaseUpload(ctx, v.ID); err != nil {
+		logger.Logger.Error("Failed to release upload reservation",
+			"video_id", v.ID,
+			"error", err.Error(),
+		)
+	}
+	if err := s.store.Remove(ctx, objectKey); err != nil {
+		logger.Logger.Warn("Failed to cleanup storage after upload error",
+			"video_id", v.ID,
+			"filename", objectKey,
+			"error", err.Error(),
+		)
+	}
+}
+
 // UploadGeneratedFile handles generated files (HLS segments, different qualities) - no DB metadata
-func (s *videoService) UploadGeneratedFile(ctx context.Context, fileName string, content []byte) error {
+func (s *videoService) UploadGeneratedFile(ctx context.Context, fileName string, content io.Reader) error {
 	start := time.Now()
-	fileSize := int64(len(content))
 
 	logger.Logger.Info("Uploading generated file",
 		"filename", fileName,
-		"file_size_bytes", fileSize,
 	)
 
-	if fileName == "" || len(content) == 0 {
-		err := fmt.Errorf("invalid input: fileName and content cannot be empty")
+	if fileName == "" {
+		err := fmt.Errorf("invalid input: fileName cannot be empty")
 		logger.Logger.Error("Invalid generated file upload parameters",
 			"filename", fileName,
-			"content_size", len(content),
 		)
 		return err
 	}
 
-	// Just upload to MinIO - no database metadata for generated files
-	if err := s.store.Upload(ctx, fileName, content); err != nil {
+	// Just stream to MinIO - no database metadata for generated files
+	fileSize, err := s.store.Upload(ctx, fileName, content, -1)
+	if err != nil {
 		logger.Logger.Error("Failed to upload generated file",
 			"filename", fileName,
 			"error", err.Error(),
@@ -167,6 +329,13 @@ func (s *videoService) UploadGeneratedFile(ctx context.Context, fileName string,
 		return fmt.Errorf("upload generated file to MinIO failed: %w", err)
 	}
 
+	if err := s.assets.UpdateAssetSize(ctx, fileName, fileSize); err != nil {
+		logger.Logger.Warn("Failed to record generated file size",
+			"filename", fileName,
+			"error", err.Error(),
+		)
+	}
+
 	logger.LogStorageOperation(ctx, "upload_generated", fileName, fileSize, time.Since(start), nil)
 	logger.Logger.Info("Generated file uploaded successfully",
 		"filename", fileName,
@@ -223,7 +392,12 @@ func (s *videoService) GetLast3VideosByUser(ctx context.Context, userID string)
 		return nil, err
 	}
 
-	videos, err := s.repo.GetLast3VideosByUser(ctx, userID)
+	videos, err := s.repo.ListVideos(ctx, model.VideoListFilter{
+		UserID:     userID,
+		SortBy:     model.VideoSortCreatedAt,
+		Descending: true,
+		Limit:      3,
+	})
 
 	logger.LogVideoOperation(ctx, "get_last_3", "", userID, 0, time.Since(start), err)
 
@@ -275,45 +449,79 @@ func (s *videoService) GetVideosByUser(ctx context.Context, userID string) ([]*m
 	return videos, nil
 }
 
-// DownloadFile downloads any file (original, segments, playlists) from MinIO
-func (s *videoService) DownloadFile(ctx context.Context, fileName string) ([]byte, string, error) {
+// ErrRangeNotSatisfiable is returned when a download range lies outside the file
+var ErrRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// ErrUploadInProgress is returned for an upload that another delivery is
+// still storing
+var ErrUploadInProgress = errors.New("upload is in progress")
+
+// ErrInvalidUploadID is returned for upload IDs that cannot be video IDs,
+// because they are no UUID or name another user's video
+var ErrInvalidUploadID = errors.New("invalid upload ID")
+
+// FileRange is an open byte range of a stored file
+type FileRange struct {
+	Object *storage.Object
+	Offset int64
+	Length int64
+	Body   io.ReadCloser
+}
+
+// OpenFile opens length bytes of any file (original, segments, playlists)
+// starting at offset. A zero length reads through the end of the file.
+func (s *videoService) OpenFile(ctx context.Context, fileName string, offset, length int64) (*FileRange, error) {
 	start := time.Now()
 
-	logger.Logger.Info("Downloading file",
+	logger.Logger.Info("Opening file for download",
 		"filename", fileName,
+		"offset", offset,
+		"length", length,
 	)
 
 	if fileName == "" {
 		err := fmt.Errorf("fileName cannot be empty")
 		logger.Logger.Error("Invalid filename for download", "filename", fileName)
-		return nil, "", err
+		return nil, err
+	}
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("%w: offset and length cannot be negative", ErrRangeNotSatisfiable)
 	}
 
-	fileContent, err := s.store.Download(ctx, fileName)
+	obj, err := s.store.Stat(ctx, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("stat in MinIO failed: %w", err)
+	}
 
-	fileSize := int64(0)
-	if fileContent != nil {
-		fileSize = int64(len(fileContent))
+	if offset > obj.Size || (offset == obj.Size && obj.Size > 0) {
+		return nil, fmt.Errorf("%w: offset %d, file size %d", ErrRangeNotSatisfiable, offset, obj.Size)
+	}
+	if length == 0 || offset+length > obj.Size {
+		length = obj.Size - offset
 	}
 
-	logger.LogStorageOperation(ctx, "download", fileName, fileSize, time.Since(start), err)
+	body, err := s.store.DownloadRange(ctx, fileName, obj.ETag, offset, length)
+
+	logger.LogStorageOperation(ctx, "download", fileName, length, time.Since(start), err)
 
 	if err != nil {
 		logger.Logger.Error("Failed to download file",
 			"filename", fileName,
 			"error", err.Error(),
 		)
-		return nil, "", fmt.Errorf("download from MinIO failed: %w", err)
+		return nil, fmt.Errorf("download from MinIO failed: %w", err)
 	}
 
-	logger.Logger.Info("File downloaded successfully",
-		"filename", fileName,
-		"file_size_bytes", fileSize,
-	)
-
-	return fileContent, fileName, nil
+	return &FileRange{
+		Object: obj,
+		Offset: offset,
+		Length: length,
+		Body:   body,
+	}, nil
 }
 
+// RemoveVideo moves a video to the trash. Its files stay in storage until
+// the purger removes them after the retention period.
 func (s *videoService) RemoveVideo(ctx context.Context, videoID string) error {
 	start := time.Now()
 
@@ -327,102 +535,129 @@ func (s *videoService) RemoveVideo(ctx context.Context, videoID string) error {
 		return err
 	}
 
-	// Get video metadata
-	video, err := s.repo.GetVideoByID(ctx, videoID)
+	err := s.repo.SoftDeleteVideo(ctx, videoID)
+
+	logger.LogVideoOperation(ctx, "remove", videoID, "", 0, time.Since(start), err)
+
 	if err != nil {
-		logger.Logger.Error("Video not found for removal",
+		logger.Logger.Error("Failed to move video to trash",
 			"video_id", videoID,
 			"error", err.Error(),
 		)
-		return fmt.Errorf("video not found: %w", err)
+		return fmt.Errorf("failed to remove video: %w", err)
 	}
 
-	logger.Logger.Info("Removing original file from storage",
+	logger.Logger.Info("Video moved to trash",
 		"video_id", videoID,
-		"filename", video.FileName,
 	)
 
-	// Remove original file
-	if err := s.store.Remove(ctx, video.FileName); err != nil {
-		logger.Logger.Error("Failed to remove original file",
+	return nil
+}
+
+// RestoreVideo takes a video out of the trash before it is purged. The
+// original counts in the owner's usage again, so it has to fit their quota.
+func (s *videoService) RestoreVideo(ctx context.Context, videoID string) (*model.Video, error) {
+	start := time.Now()
+
+	video, err := s.repo.GetVideoByID(ctx, videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore video: %w", err)
+	}
+	if video.DeletedAt != nil && video.FileSize > 0 {
+		quota, err := s.quotas.GetQuota(ctx, video.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore video: %w", err)
+		}
+		if err := quota.Fits(video.FileSize); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.repo.RestoreVideo(ctx, videoID)
+
+	logger.LogVideoOperation(ctx, "restore", videoID, "", 0, time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to restore video",
 			"video_id", videoID,
-			"filename", video.FileName,
 			"error", err.Error(),
 		)
-		return fmt.Errorf("remove original file from MinIO failed: %w", err)
+		return nil, fmt.Errorf("failed to restore video: %w", err)
 	}
 
-	// Remove all generated files for this video ID
-	// This includes HLS segments, playlists, and different quality versions
-	logger.Logger.Info("Removing generated files",
+	logger.Logger.Info("Video restored from trash",
 		"video_id", videoID,
 	)
 
-	if err := s.removeGeneratedFiles(ctx, videoID); err != nil {
-		logger.Logger.Error("Failed to remove generated files",
+	return s.repo.GetVideoByID(ctx, videoID)
+}
+
+// UpdateVideoStatus moves a video to a new status if the lifecycle allows it.
+// Setting the status a video already has is a no-op so callers can retry.
+func (s *videoService) UpdateVideoStatus(ctx context.Context, videoID string, status model.VideoStatus, reason string) (*model.Video, error) {
+	start := time.Now()
+
+	logger.Logger.Info("Updating video status",
+		"video_id", videoID,
+		"status", status,
+	)
+
+	if videoID == "" || !status.IsValid() {
+		err := fmt.Errorf("invalid input: videoID and a known status are required")
+		logger.Logger.Error("Invalid video status update", "video_id", videoID, "status", status)
+		return nil, err
+	}
+
+	video, err := s.repo.GetVideoByID(ctx, videoID)
+	if err != nil {
+		logger.Logger.Warn("Video not found for status update",
 			"video_id", videoID,
 			"error", err.Error(),
 		)
-		return fmt.Errorf("failed to remove generated files: %w", err)
+		return nil, err
 	}
 
-	// Remove metadata from database
-	logger.Logger.Info("Removing video metadata from database",
-		"video_id", videoID,
-	)
+	if video.Status == status {
+		return video, nil
+	}
 
-	if err := s.repo.DeleteVideo(ctx, videoID); err != nil {
-		logger.Logger.Error("Failed to delete video metadata",
+	if !video.Status.CanTransitionTo(status) {
+		err := fmt.Errorf("%w: %s -> %s", model.ErrInvalidStatusTransition, video.Status, status)
+		logger.Logger.Warn("Rejected video status transition",
 			"video_id", videoID,
-			"error", err.Error(),
+			"from_status", video.Status,
+			"to_status", status,
 		)
-		return fmt.Errorf("failed to delete video metadata: %w", err)
+		return nil, err
 	}
 
-	logger.LogVideoOperation(ctx, "remove", videoID, video.UserID, 0, time.Since(start), nil)
-	logger.Logger.Info("Video removed successfully",
+	err = s.repo.UpdateVideoStatus(ctx, videoID, video.Status, status, reason)
+
+	logger.LogVideoOperation(ctx, "update_status", videoID, video.UserID, 0, time.Since(start), err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	video.Status = status
+	video.StatusReason = reason
+	video.StatusUpdatedAt = time.Now()
+
+	logger.Logger.Info("Video status updated successfully",
 		"video_id", videoID,
-		"title", video.Title,
-		"user_id", video.UserID,
+		"status", status,
 	)
 
-	return nil
+	return video, nil
 }
 
-// removeGeneratedFiles removes all files related to a video ID
-func (s *videoService) removeGeneratedFiles(ctx context.Context, videoID string) error {
-	// List of patterns for generated files based on your Rust code
-	patterns := []string{
-		fmt.Sprintf("%s_master.m3u8", videoID), // Master playlist
-		fmt.Sprintf("%s_144p.mp4", videoID),    // Resolution files
-		fmt.Sprintf("%s_240p.mp4", videoID),
-		fmt.Sprintf("%s_360p.mp4", videoID),
-		fmt.Sprintf("%s_480p.mp4", videoID),
-		fmt.Sprintf("%s_720p.mp4", videoID),
-		fmt.Sprintf("%s_1080p.mp4", videoID),
-	}
-
-	// Remove individual files
-	for _, pattern := range patterns {
-		if err := s.store.Remove(ctx, pattern); err != nil {
-			// Log error but don't fail the entire operation
-			fmt.Printf("Warning: failed to remove file %s: %v\n", pattern, err)
-		}
+// GetStatusHistory returns the status transitions of a video, oldest first
+func (s *videoService) GetStatusHistory(ctx context.Context, videoID string) ([]*model.VideoStatusChange, error) {
+	if videoID == "" {
+		return nil, fmt.Errorf("videoID cannot be empty")
 	}
-
-	// Remove HLS segment directories (144, 240, 360, etc.)
-	resolutions := []string{"144", "240", "360", "480", "720", "1080"}
-	for _, res := range resolutions {
-		// Remove playlist file
-		playlistFile := fmt.Sprintf("%s/%s/index.m3u8", videoID, res)
-		if err := s.store.Remove(ctx, playlistFile); err != nil {
-			fmt.Printf("Warning: failed to remove playlist %s: %v\n", playlistFile, err)
-		}
-
-		// Note: MinIO doesn't have directory concept, but segment files follow pattern
-		// videoID/resolution/seg_XXX.ts - you might need to list and remove these
-		// This depends on your MinIO client implementation
+	if _, err := s.repo.GetVideoByID(ctx, videoID); err != nil {
+		return nil, err
 	}
-
-	return nil
+	return s.repo.GetStatusHistory(ctx, videoID)
 }
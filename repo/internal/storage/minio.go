@@ -1,10 +1,11 @@
 package storage
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/lumbrjx/codek7/repo/pkg/logger"
@@ -72,22 +73,31 @@ func New(endpoint, accessKey, secretKey, bucket string, useSSL bool) (*MinioClie
 	}, nil
 }
 
-// Upload uploads a video file using objectKey
-func (m *MinioClient) Upload(ctx context.Context, objectKey string, content []byte) error {
+// uploadPartSize bounds the memory used per upload when the size is unknown,
+// minio buffers exactly one part at a time before sending it
+const uploadPartSize = 16 * 1024 * 1024
+
+// Upload streams content to objectKey. A negative size means the length is
+// unknown and the object is sent as a multipart upload. Returns the bytes stored.
+func (m *MinioClient) Upload(ctx context.Context, objectKey string, content io.Reader, size int64) (int64, error) {
 	start := time.Now()
-	fileSize := int64(len(content))
 
 	logger.Logger.Info("Uploading file to MinIO",
 		"object_key", objectKey,
-		"file_size_bytes", fileSize,
+		"file_size_bytes", size,
 		"bucket", m.bucket,
 	)
 
-	_, err := m.client.PutObject(ctx, m.bucket, objectKey, bytes.NewReader(content), fileSize, minio.PutObjectOptions{
+	opts := minio.PutObjectOptions{
 		ContentType: "video/mp4",
-	})
+	}
+	if size < 0 {
+		opts.PartSize = uploadPartSize
+	}
+
+	info, err := m.client.PutObject(ctx, m.bucket, objectKey, content, size, opts)
 
-	logger.LogStorageOperation(ctx, "upload", objectKey, fileSize, time.Since(start), err)
+	logger.LogStorageOperation(ctx, "upload", objectKey, info.Size, time.Since(start), err)
 
 	if err != nil {
 		logger.Logger.Error("Failed to upload file to MinIO",
@@ -95,61 +105,82 @@ func (m *MinioClient) Upload(ctx context.Context, objectKey string, content []by
 			"bucket", m.bucket,
 			"error", err.Error(),
 		)
-		return fmt.Errorf("upload failed: %w", err)
+		return 0, fmt.Errorf("upload failed: %w", err)
 	}
 
 	logger.Logger.Info("File uploaded to MinIO successfully",
 		"object_key", objectKey,
-		"file_size_bytes", fileSize,
+		"file_size_bytes", info.Size,
 	)
 
-	return nil
+	return info.Size, nil
 }
 
-// Download retrieves the object using its objectKey
-func (m *MinioClient) Download(ctx context.Context, objectKey string) ([]byte, error) {
+// Stat returns the size, ETag and modification time of an object
+func (m *MinioClient) Stat(ctx context.Context, objectKey string) (*Object, error) {
 	start := time.Now()
 
-	logger.Logger.Info("Downloading file from MinIO",
-		"object_key", objectKey,
-		"bucket", m.bucket,
-	)
+	info, err := m.client.StatObject(ctx, m.bucket, objectKey, minio.StatObjectOptions{})
+
+	logger.LogStorageOperation(ctx, "stat", objectKey, info.Size, time.Since(start), err)
 
-	obj, err := m.client.GetObject(ctx, m.bucket, objectKey, minio.GetObjectOptions{})
 	if err != nil {
-		logger.Logger.Error("Failed to get object from MinIO",
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("stat object failed: %w", ErrObjectNotFound)
+		}
+		logger.Logger.Error("Failed to stat object in MinIO",
 			"object_key", objectKey,
 			"bucket", m.bucket,
 			"error", err.Error(),
 		)
-		return nil, fmt.Errorf("get object failed: %w", err)
+		return nil, fmt.Errorf("stat object failed: %w", err)
 	}
-	defer func() {
-		if closeErr := obj.Close(); closeErr != nil {
-			logger.Logger.Warn("Failed to close object",
-				"object_key", objectKey,
-				"error", closeErr.Error(),
-			)
+
+	return &Object{
+		Key:          info.Key,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ETag:         info.ETag,
+		ContentType:  info.ContentType,
+	}, nil
+}
+
+// DownloadRange streams length bytes of the object starting at offset.
+// The read fails if the object no longer matches etag, so a range is never
+// stitched together from two versions of the same key.
+func (m *MinioClient) DownloadRange(ctx context.Context, objectKey, etag string, offset, length int64) (io.ReadCloser, error) {
+	logger.Logger.Info("Downloading file range from MinIO",
+		"object_key", objectKey,
+		"bucket", m.bucket,
+		"offset", offset,
+		"length", length,
+	)
+
+	if length <= 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	opts := minio.GetObjectOptions{}
+	if etag != "" {
+		if err := opts.SetMatchETag(etag); err != nil {
+			return nil, fmt.Errorf("set etag condition failed: %w", err)
 		}
-	}()
+	}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, fmt.Errorf("set range failed: %w", err)
+	}
 
-	buf := new(bytes.Buffer)
-	if _, err = io.Copy(buf, obj); err != nil {
-		logger.Logger.Error("Failed to copy object content",
+	obj, err := m.client.GetObject(ctx, m.bucket, objectKey, opts)
+	if err != nil {
+		logger.Logger.Error("Failed to get object from MinIO",
 			"object_key", objectKey,
+			"bucket", m.bucket,
 			"error", err.Error(),
 		)
-		return nil, fmt.Errorf("copy object content failed: %w", err)
+		return nil, fmt.Errorf("get object failed: %w", err)
 	}
 
-	fileSize := int64(buf.Len())
-	logger.LogStorageOperation(ctx, "download", objectKey, fileSize, time.Since(start), nil)
-	logger.Logger.Info("File downloaded from MinIO successfully",
-		"object_key", objectKey,
-		"file_size_bytes", fileSize,
-	)
-
-	return buf.Bytes(), nil
+	return obj, nil
 }
 
 func (m *MinioClient) Remove(ctx context.Context, objectKey string) error {
@@ -179,3 +210,148 @@ func (m *MinioClient) Remove(ctx context.Context, objectKey string) error {
 
 	return nil
 }
+
+// ErrObjectNotFound is returned when the requested key does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// Object describes a stored object returned by ListPrefix and Stat.
+// ETag and ContentType are only filled in by Stat.
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+	ContentType  string
+}
+
+// ListPrefix lists every object whose key starts with prefix, recursively.
+// An empty prefix lists the whole bucket.
+func (m *MinioClient) ListPrefix(ctx context.Context, prefix string) ([]Object, error) {
+	start := time.Now()
+
+	var objects []Object
+	for info := range m.client.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			logger.Logger.Error("Failed to list objects in MinIO",
+				"prefix", prefix,
+				"bucket", m.bucket,
+				"error", info.Err.Error(),
+			)
+			return nil, fmt.Errorf("list objects failed: %w", info.Err)
+		}
+		objects = append(objects, Object{
+			Key:          info.Key,
+			Size:         info.Size,
+			LastModified: info.LastModified,
+		})
+	}
+
+	logger.LogStorageOperation(ctx, "list", prefix, 0, time.Since(start), nil)
+
+	return objects, nil
+}
+
+// RemoveKeys deletes the given objects with batched DeleteObjects requests.
+// Missing keys are not an error. Returns how many keys could not be removed.
+func (m *MinioClient) RemoveKeys(ctx context.Context, keys []string) (int, error) {
+	start := time.Now()
+
+	objectsCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for _, key := range keys {
+			select {
+			case objectsCh <- minio.ObjectInfo{Key: key}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	failed, err := m.drainRemoveErrors(m.client.RemoveObjects(ctx, m.bucket, objectsCh, minio.RemoveObjectsOptions{}))
+
+	logger.LogStorageOperation(ctx, "remove_batch", "", 0, time.Since(start), err)
+
+	return failed, err
+}
+
+// RemovePrefix deletes every object whose key starts with prefix. The prefix
+// must not be empty, so a bad caller cannot wipe the bucket.
+func (m *MinioClient) RemovePrefix(ctx context.Context, prefix string) (int, error) {
+	start := time.Now()
+
+	if prefix == "" {
+		return 0, fmt.Errorf("remove prefix: prefix cannot be empty")
+	}
+
+	logger.Logger.Info("Removing objects by prefix from MinIO",
+		"prefix", prefix,
+		"bucket", m.bucket,
+	)
+
+	listCh := m.client.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+
+	var listErr error
+	objectsCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for info := range listCh {
+			if info.Err != nil {
+				listErr = info.Err
+				continue
+			}
+			select {
+			case objectsCh <- info:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	failed, err := m.drainRemoveErrors(m.client.RemoveObjects(ctx, m.bucket, objectsCh, minio.RemoveObjectsOptions{}))
+	if err == nil && listErr != nil {
+		err = fmt.Errorf("list objects failed: %w", listErr)
+	}
+
+	logger.LogStorageOperation(ctx, "remove_prefix", prefix, 0, time.Since(start), err)
+
+	if err != nil {
+		logger.Logger.Error("Failed to remove objects by prefix",
+			"prefix", prefix,
+			"failed_count", failed,
+			"error", err.Error(),
+		)
+	}
+
+	return failed, err
+}
+
+// drainRemoveErrors consumes a RemoveObjects error channel until it closes
+func (m *MinioClient) drainRemoveErrors(errCh <-chan minio.RemoveObjectError) (int, error) {
+	var failed int
+	var firstErr error
+	for rErr := range errCh {
+		err := rErr.Err
+		if err == nil {
+			err = errors.New("unknown remove error")
+		}
+		failed++
+		logger.Logger.Warn("Failed to remove object from MinIO",
+			"object_key", rErr.ObjectName,
+			"error", err.Error(),
+		)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return failed, fmt.Errorf("remove objects failed for %d keys: %w", failed, firstErr)
+	}
+	return 0, nil
+}
@@ -9,7 +9,7 @@ mod rpc;
 mod video;
 use std::sync::Arc;
 
-use crate::processor::consume_video_chunks;
+use crate::processor::{consume_ready_videos, consume_video_chunks};
 use crate::rmq::RabbitMQ;
 
 #[tokio::main]
@@ -24,7 +24,13 @@ async fn main() -> anyhow::Result<()> {
     let queue2 = "notify.q";
 
     let rmq = RabbitMQ::connect(uri, queue, queue2).await?;
-    consume_video_chunks(rpc_client, rmq).await;
+    // Originals come from the gateway's assembler on video-ready. With
+    // VCODEC_SOURCE=chunks vcodec reassembles video-chunks itself instead,
+    // for setups that do not run the assembler.
+    match std::env::var("VCODEC_SOURCE").as_deref() {
+        Ok("chunks") => consume_video_chunks(rpc_client, rmq).await,
+        _ => consume_ready_videos(rpc_client, rmq).await,
+    }
 
     Ok(())
 }
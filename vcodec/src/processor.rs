@@ -1,5 +1,9 @@
 use crate::consts::{NSFW_RESOLUTIONS, RESOLUTIONS};
-use crate::repo::{upload_video_request::Data, UploadVideoRequest, VideoChunk, VideoMetadata};
+use crate::repo::{
+    upload_video_request::Data, video_file_response::Data as FileData, DownloadVideoRequest,
+    RegisterVideoAssetsRequest, UpdateVideoStatusRequest, UploadVideoRequest, VideoAsset,
+    VideoChunk, VideoMetadata,
+};
 use crate::rmq::RabbitMQ;
 use crate::video::save_video;
 use rdkafka::config::ClientConfig;
@@ -7,15 +11,69 @@ use rdkafka::consumer::{Consumer, StreamConsumer};
 use rdkafka::message::Headers;
 use rdkafka::message::Message;
 use rdkafka::util::get_rdkafka_version;
+use serde::Deserialize;
 use std::collections::HashMap;
 use std::sync::{Arc, Mutex};
 use tokio::fs::File;
-use tokio::io::{AsyncReadExt, BufReader};
+use tokio::io::{AsyncReadExt, AsyncWriteExt, BufReader};
 use tokio::sync::mpsc;
 use tokio_stream::wrappers::ReceiverStream;
 use tokio_stream::StreamExt;
 use tonic::Request;
 
+// Records files in the video's asset manifest. The repo service only takes
+// uploads of registered files as generated, anything else is an original.
+async fn register_assets(
+    rpc_client: &crate::rpc::RpcClient,
+    video_id: &str,
+    user_id: &str,
+    assets: Vec<VideoAsset>,
+) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
+    let mut request = Request::new(RegisterVideoAssetsRequest {
+        video_id: video_id.to_string(),
+        assets,
+    });
+    // The repo service lets the owner register assets
+    request.metadata_mut().insert("x-user-id", user_id.parse()?);
+
+    let mut rpc = rpc_client.get_client().clone();
+    rpc.register_video_assets(request).await?;
+    Ok(())
+}
+
+// Reports a processing status of the video to the repo service
+async fn update_status(
+    rpc_client: &crate::rpc::RpcClient,
+    video_id: &str,
+    user_id: &str,
+    status: &str,
+    reason: &str,
+) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
+    let mut request = Request::new(UpdateVideoStatusRequest {
+        video_id: video_id.to_string(),
+        status: status.to_string(),
+        reason: reason.to_string(),
+    });
+    // The repo service lets the owner report the status
+    request.metadata_mut().insert("x-user-id", user_id.parse()?);
+
+    let mut rpc = rpc_client.get_client().clone();
+    rpc.update_video_status(request).await?;
+    Ok(())
+}
+
+// Describes a generated file of the given kind at one resolution
+fn generated_asset(kind: &str, height: u32, object_key: &str) -> VideoAsset {
+    VideoAsset {
+        kind: kind.to_string(),
+        rendition: format!("{}p", height),
+        codec: "h264".to_string(),
+        height: height as i32,
+        object_key: object_key.to_string(),
+        ..Default::default()
+    }
+}
+
 // Helper function to upload a single file
 async fn upload_file(
     rpc_client: Arc<crate::rpc::RpcClient>,
@@ -33,10 +91,13 @@ async fn upload_file(
     let (tx, rx) = mpsc::channel(16);
     let rpc = rpc_client.get_client();
 
+    // The repo service only stores files on behalf of the video's owner
+    let mut request = Request::new(ReceiverStream::new(rx));
+    request.metadata_mut().insert("x-user-id", user_id.parse()?);
+
     let upload_handle = tokio::spawn({
         let mut rpc = rpc.clone();
-        let stream = ReceiverStream::new(rx);
-        async move { rpc.upload_video(Request::new(stream)).await }
+        async move { rpc.upload_video(request).await }
     });
 
     let metadata = UploadVideoRequest {
@@ -46,6 +107,7 @@ async fn upload_file(
             description: description.to_string(),
             file_name: file_path.to_string(),
             file_size: file_size as i64,
+            ..Default::default()
         })),
     };
 
@@ -159,6 +221,14 @@ async fn generate_and_upload_resolutions(
                 match status {
                     Ok(status) if status.success() => {
                         println!("✅ Created {}", output_file);
+
+                        let asset = generated_asset("rendition", *height, &output_file);
+                        if let Err(e) =
+                            register_assets(&rpc_client, &video_id, &user_id, vec![asset]).await
+                        {
+                            eprintln!("❌ Failed to register {}: {}", output_file, e);
+                            return;
+                        }
                         paths.lock().unwrap().push(output_file.clone());
 
                         // Start upload immediately after generation
@@ -204,24 +274,8 @@ async fn generate_and_upload_resolutions(
     let tasks = upload_tasks.lock().unwrap().drain(..).collect::<Vec<_>>();
     futures::future::join_all(tasks).await;
 
-    // Add the original file and upload it
-    let mut final_paths = paths.lock().unwrap().clone();
-    final_paths.push(input_file.clone());
-
-    // Upload original file
-    println!("📦 Uploading original file: {}", input_file);
-    if let Err(e) = upload_file(
-        rpc_client,
-        &input_file,
-        video_id,
-        title,
-        user_id,
-        description,
-    )
-    .await
-    {
-        eprintln!("❌ Upload failed for original file: {}", e);
-    }
+    // The original is already stored, it was downloaded from the repo service
+    let final_paths = paths.lock().unwrap().clone();
 
     println!("📁 Generated and uploaded files: {:?}", final_paths);
     final_paths
@@ -238,7 +292,7 @@ async fn generate_and_upload_segments(
     user_id: &str,
     description: &str,
     rmq: Arc<RabbitMQ>, // Changed from &RabbitMQ to Arc<RabbitMQ>
-) -> Vec<String> {
+) -> Result<Vec<String>, String> {
     let semaphore = Arc::new(tokio::sync::Semaphore::new(2));
     let mut handles = vec![];
     let upload_tasks = Arc::new(Mutex::new(vec![]));
@@ -317,6 +371,22 @@ async fn generate_and_upload_segments(
                     }
                 }
 
+                let assets = paths
+                    .iter()
+                    .map(|path| {
+                        let kind = if path.ends_with(".m3u8") {
+                            "playlist"
+                        } else {
+                            "segment"
+                        };
+                        generated_asset(kind, height, path)
+                    })
+                    .collect();
+                if let Err(e) = register_assets(&rpc_client, &video_id, &user_id, assets).await {
+                    eprintln!("❌ Failed to register HLS files of {}p: {}", height, e);
+                    return None;
+                }
+
                 // Upload all generated files immediately
                 for (index, path) in paths.iter().enumerate() {
                     let upload_task = tokio::spawn({
@@ -331,7 +401,7 @@ async fn generate_and_upload_segments(
 
                         async move {
                             println!("📦 Uploading segment file: {}", path);
-                            if let Err(e) = upload_file(
+                            let uploaded = match upload_file(
                                 rpc_client.clone(),
                                 &path,
                                 &video_id,
@@ -341,8 +411,12 @@ async fn generate_and_upload_segments(
                             )
                             .await
                             {
-                                eprintln!("❌ Upload failed for {}: {}", path, e);
-                            }
+                                Ok(()) => true,
+                                Err(e) => {
+                                    eprintln!("❌ Upload failed for {}: {}", path, e);
+                                    false
+                                }
+                            };
 
                             let progress = (index + 1) * 100 / paths_clone.len(); // Removed unnecessary parentheses
                             let _ = rmq
@@ -355,6 +429,7 @@ async fn generate_and_upload_segments(
                                     "video_processor".to_string(),
                                 )
                                 .await;
+                            uploaded
                         }
                     });
 
@@ -376,11 +451,15 @@ async fn generate_and_upload_segments(
 
     let mut all_paths = vec![];
     let mut master_entries = vec![];
+    let mut complete = true;
 
     for handle in handles {
-        if let Ok(Some((paths, entry))) = handle.await {
-            all_paths.extend(paths);
-            master_entries.push(entry);
+        match handle.await {
+            Ok(Some((paths, entry))) => {
+                all_paths.extend(paths);
+                master_entries.push(entry);
+            }
+            _ => complete = false,
         }
     }
 
@@ -396,41 +475,311 @@ async fn generate_and_upload_segments(
         .expect("Failed to write master playlist");
     all_paths.insert(0, master_path.clone());
 
-    // Upload master playlist
-    let upload_task = tokio::spawn({
+    // Register and upload master playlist
+    let master = VideoAsset {
+        kind: "master".to_string(),
+        object_key: master_path.clone(),
+        ..Default::default()
+    };
+    match register_assets(&rpc_client, video_id, user_id, vec![master]).await {
+        Err(e) => {
+            eprintln!("❌ Failed to register master playlist: {}", e);
+            complete = false;
+        }
+        Ok(()) => {
+            let upload_task = tokio::spawn({
+                let rpc_client = rpc_client.clone();
+                let master_path = master_path.clone();
+                let video_id = video_id.to_string();
+                let title = title.to_string();
+                let user_id = user_id.to_string();
+                let description = description.to_string();
+
+                async move {
+                    println!("📦 Uploading master playlist: {}", master_path);
+                    match upload_file(
+                        rpc_client.clone(),
+                        &master_path,
+                        &video_id,
+                        &title,
+                        &user_id,
+                        &description,
+                    )
+                    .await
+                    {
+                        Ok(()) => true,
+                        Err(e) => {
+                            eprintln!("❌ Upload failed for master playlist: {}", e);
+                            false
+                        }
+                    }
+                }
+            });
+
+            upload_tasks.lock().unwrap().push(upload_task);
+        }
+    }
+
+    // Wait for all upload tasks to complete
+    let tasks = upload_tasks.lock().unwrap().drain(..).collect::<Vec<_>>();
+    let uploaded = futures::future::join_all(tasks)
+        .await
+        .into_iter()
+        .all(|result| matches!(result, Ok(true)));
+
+    println!("📜 Master playlist: {}", master_path);
+    println!("📜 All paths: {:?}", all_paths);
+    if !complete {
+        return Err("some HLS renditions could not be generated".to_string());
+    }
+    if !uploaded {
+        return Err("some HLS files could not be uploaded".to_string());
+    }
+    Ok(all_paths)
+}
+
+// Transcodes the original saved as `<video_id>.mp4`, uploads the renditions
+// and HLS files, reports the video ready or failed, queues the NSFW check
+// and removes the local files
+async fn process_video(
+    rpc_client: Arc<crate::rpc::RpcClient>,
+    rmq: &RabbitMQ,
+    video_id: String,
+    title: String,
+    user_id: String,
+    description: String,
+) {
+    // Spawn both tasks concurrently
+    let resolution_task = tokio::spawn({
         let rpc_client = rpc_client.clone();
-        let master_path = master_path.clone();
-        let video_id = video_id.to_string();
-        let title = title.to_string();
-        let user_id = user_id.to_string();
-        let description = description.to_string();
+        let video_id = video_id.clone();
+        let title = title.clone();
+        let user_id = user_id.clone();
+        let description = description.clone();
+        let rmq_clone = rmq.clone(); // Clone rmq here
 
         async move {
-            println!("📦 Uploading master playlist: {}", master_path);
-            if let Err(e) = upload_file(
+            println!("🎬 Starting resolution generation and upload...");
+            let nsfw_resolution_paths = generate_and_upload_resolutions(
+                &format!("{}.mp4", &video_id),
+                &video_id,
+                NSFW_RESOLUTIONS,
                 rpc_client.clone(),
-                &master_path,
                 &video_id,
                 &title,
                 &user_id,
                 &description,
+                &rmq_clone, // Use the cloned rmq
             )
-            .await
-            {
-                eprintln!("❌ Upload failed for master playlist: {}", e);
+            .await;
+            println!("✅ Resolution generation and upload complete");
+            let _ = rmq_clone
+                .send_progress_message(
+                    video_id.clone(),
+                    "processing".to_string(),
+                    30,
+                    user_id.clone(),
+                    "Finished generating pre check resolution".to_string(),
+                    "video_processor".to_string(),
+                )
+                .await;
+            nsfw_resolution_paths
+        }
+    });
+
+    let segment_task = tokio::spawn({
+        let rpc_client = rpc_client.clone();
+        let video_id = video_id.clone();
+        let title = title.clone();
+        let user_id = user_id.clone();
+        let description = description.clone();
+        let rmq_clone = rmq.clone(); // Clone rmq here
+
+        async move {
+            println!("🎬 Starting segment generation and upload...");
+            let segment_paths = generate_and_upload_segments(
+                &format!("{}.mp4", &video_id),
+                &video_id,
+                RESOLUTIONS,
+                rpc_client.clone(),
+                &video_id,
+                &title,
+                &user_id,
+                &description,
+                Arc::new(rmq_clone), // Use the cloned rmq
+            )
+            .await;
+
+            println!("✅ Segment generation and upload complete");
+            segment_paths
+        }
+    });
+
+    // Wait for both tasks to complete
+    let (resolution_result, segment_result) = tokio::join!(resolution_task, segment_task);
+
+    // The video is playable once all its HLS files are stored
+    let (status, reason) = match segment_result {
+        Ok(Ok(_)) => ("ready", String::new()),
+        Ok(Err(e)) => ("failed", e),
+        Err(e) => ("failed", format!("transcoding stopped: {}", e)),
+    };
+    if let Err(e) = update_status(&rpc_client, &video_id, &user_id, status, &reason).await {
+        eprintln!("❌ Failed to mark video {} {}: {}", video_id, status, e);
+    }
+
+    // Send NSFW verification message
+    if let Ok(nsfw_resolution_paths) = resolution_result {
+        if !nsfw_resolution_paths.is_empty() {
+            let _ = rmq
+                .clone()
+                .send_message("verify_nsfw", nsfw_resolution_paths[0].as_bytes())
+                .await;
+            println!("Message sent to queue `verify_nsfw`");
+        }
+    }
+
+    // Cleanup
+    if let Err(e) = tokio::fs::remove_dir_all(&video_id).await {
+        eprintln!("❌ Failed to delete directory {:?}: {}", &video_id, e)
+    } else {
+        println!("📁 Directory {:?} deleted successfully.", &video_id);
+    }
+
+    for file_path in [
+        format!("{}_master.m3u8", &video_id),
+        format!("{}_360p.mp4", &video_id),
+        format!("{}.mp4", &video_id),
+    ] {
+        match tokio::fs::remove_file(&file_path).await {
+            Ok(_) => {
+                println!("📁 Video file {} deleted successfully.", file_path)
+            }
+            Err(e) => {
+                eprintln!("❌ Failed to delete video {}: {}", file_path, e)
             }
         }
+    }
+}
+
+// ReadyEvent is what the gateway's assembler publishes on `video-ready` for
+// every original it stored in the repo service
+#[derive(Debug, Deserialize)]
+struct ReadyEvent {
+    video_id: String,
+    user_id: String,
+    title: String,
+    #[serde(default)]
+    description: String,
+}
+
+// Saves the stored original of the event's video at path
+async fn download_original(
+    rpc_client: &crate::rpc::RpcClient,
+    event: &ReadyEvent,
+    path: &str,
+) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
+    let mut request = Request::new(DownloadVideoRequest {
+        video_id: event.video_id.clone(),
+        ..Default::default()
     });
+    // The repo service lets the owner download the original
+    request
+        .metadata_mut()
+        .insert("x-user-id", event.user_id.parse()?);
+
+    let mut rpc = rpc_client.get_client().clone();
+    let mut stream = rpc.download_video(request).await?.into_inner();
+    let mut file = File::create(path).await?;
+    while let Some(message) = stream.message().await? {
+        if let Some(FileData::Chunk(chunk)) = message.data {
+            file.write_all(&chunk.data).await?;
+        }
+    }
+    file.flush().await?;
+    Ok(())
+}
 
-    upload_tasks.lock().unwrap().push(upload_task);
+// Transcodes the originals the gateway's assembler stores and announces on
+// `video-ready`. Renditions and HLS files are named after the video ID,
+// like the stored original.
+pub async fn consume_ready_videos(
+    rpc_client: Arc<crate::rpc::RpcClient>,
+    rmq: crate::rmq::RabbitMQ,
+) {
+    let consumer: StreamConsumer = ClientConfig::new()
+        .set("bootstrap.servers", "localhost:9092")
+        .set("group.id", "video-transcoder-group")
+        .set("auto.offset.reset", "earliest")
+        .create()
+        .expect("Consumer creation failed");
 
-    // Wait for all upload tasks to complete
-    let tasks = upload_tasks.lock().unwrap().drain(..).collect::<Vec<_>>();
-    futures::future::join_all(tasks).await;
+    consumer
+        .subscribe(&["video-ready"])
+        .expect("Subscribe failed");
 
-    println!("📜 Master playlist: {}", master_path);
-    println!("📜 All paths: {:?}", all_paths);
-    all_paths
+    println!("🚀 Listening for stored videos...");
+
+    let mut stream = consumer.stream();
+
+    while let Some(result) = stream.next().await {
+        let msg = match result {
+            Ok(msg) => msg,
+            Err(e) => {
+                eprintln!("Kafka error: {}", e);
+                continue;
+            }
+        };
+        let event = match msg.payload().map(serde_json::from_slice::<ReadyEvent>) {
+            Some(Ok(event)) => event,
+            _ => {
+                eprintln!("❌ Skipping malformed video-ready event");
+                continue;
+            }
+        };
+
+        println!("✅ Video '{}' is stored. Processing...", event.video_id);
+        let _ = rmq
+            .clone()
+            .send_progress_message(
+                event.video_id.clone(),
+                "processing".to_string(),
+                10,
+                event.user_id.clone(),
+                "Fetching the stored video".to_string(),
+                "video_processor".to_string(),
+            )
+            .await;
+
+        let input_file = format!("{}.mp4", &event.video_id);
+        if let Err(e) = download_original(&rpc_client, &event, &input_file).await {
+            eprintln!("❌ Failed to download video {}: {}", event.video_id, e);
+            let _ = tokio::fs::remove_file(&input_file).await;
+            let reason = "the original could not be fetched for transcoding";
+            if let Err(e) = update_status(
+                &rpc_client,
+                &event.video_id,
+                &event.user_id,
+                "failed",
+                reason,
+            )
+            .await
+            {
+                eprintln!("❌ Failed to mark video {} failed: {}", event.video_id, e);
+            }
+            continue;
+        }
+
+        process_video(
+            rpc_client.clone(),
+            &rmq,
+            event.video_id,
+            event.title,
+            event.user_id,
+            event.description,
+        )
+        .await;
+    }
 }
 
 pub async fn consume_video_chunks(
@@ -540,112 +889,16 @@ pub async fn consume_video_chunks(
                         let user_id_ = user_id.clone().unwrap_or("unknown".to_string());
                         let description_ = description.clone().unwrap_or_default();
 
-                        // Spawn both tasks concurrently
-                        let resolution_task = tokio::spawn({
-                            let rpc_client = rpc_client.clone();
-                            let video_id = video_id.clone();
-                            let title = title_.clone();
-                            let user_id = user_id_.clone();
-                            let description = description_.clone();
-                            let rmq_clone = rmq.clone(); // Clone rmq here
-                            let user_id_ = user_id_.clone();
-
-                            async move {
-                                println!("🎬 Starting resolution generation and upload...");
-                                let nsfw_resolution_paths = generate_and_upload_resolutions(
-                                    &format!("{}.mp4", &video_id),
-                                    &video_id,
-                                    NSFW_RESOLUTIONS,
-                                    rpc_client.clone(),
-                                    &video_id,
-                                    &title,
-                                    &user_id,
-                                    &description,
-                                    &rmq_clone, // Use the cloned rmq
-                                )
-                                .await;
-                                println!("✅ Resolution generation and upload complete");
-                                let _ = rmq_clone
-                                    .send_progress_message(
-                                        video_id.clone(),
-                                        "processing".to_string(),
-                                        30,
-                                        user_id_.clone(),
-                                        "Finished generating pre check resolution".to_string(),
-                                        "video_processor".to_string(),
-                                    )
-                                    .await;
-                                nsfw_resolution_paths
-                            }
-                        });
-
-                        let segment_task = tokio::spawn({
-                            let rpc_client = rpc_client.clone();
-                            let video_id = video_id.clone();
-                            let title = title_.clone();
-                            let user_id = user_id_.clone();
-                            let description = description_.clone();
-                            let rmq_clone = rmq.clone(); // Clone rmq here
-
-                            async move {
-                                println!("🎬 Starting segment generation and upload...");
-                                let _segment_paths = generate_and_upload_segments(
-                                    &format!("{}.mp4", &video_id),
-                                    &video_id,
-                                    RESOLUTIONS,
-                                    rpc_client.clone(),
-                                    &video_id,
-                                    &title,
-                                    &user_id,
-                                    &description,
-                                    Arc::new(rmq_clone), // Use the cloned rmq
-                                )
-                                .await;
-
-                                println!("✅ Segment generation and upload complete");
-                            }
-                        });
-
-                        // Wait for both tasks to complete
-                        let (resolution_result, _) = tokio::join!(resolution_task, segment_task);
-
-                        // Send NSFW verification message
-                        if let Ok(nsfw_resolution_paths) = resolution_result {
-                            if !nsfw_resolution_paths.is_empty() {
-                                let _ = rmq
-                                    .clone()
-                                    .send_message(
-                                        "verify_nsfw",
-                                        nsfw_resolution_paths[0].as_bytes(),
-                                    )
-                                    .await;
-                                println!("Message sent to queue `verify_nsfw`");
-                            }
-                        }
-
-                        // Cleanup
+                        process_video(
+                            rpc_client.clone(),
+                            &rmq,
+                            video_id.clone(),
+                            title_,
+                            user_id_,
+                            description_,
+                        )
+                        .await;
                         map.remove(&video_id);
-
-                        if let Err(e) = tokio::fs::remove_dir_all(&video_id).await {
-                            eprintln!("❌ Failed to delete directory {:?}: {}", &video_id, e)
-                        } else {
-                            println!("📁 Directory {:?} deleted successfully.", &video_id);
-                        }
-
-                        for file_path in [
-                            format!("{}_master.m3u8", &video_id),
-                            format!("{}_360p.mp4", &video_id),
-                            format!("{}.mp4", &video_id),
-                        ] {
-                            match tokio::fs::remove_file(&file_path).await {
-                                Ok(_) => {
-                                    println!("📁 Video file {} deleted successfully.", file_path)
-                                }
-                                Err(e) => {
-                                    eprintln!("❌ Failed to delete video {}: {}", file_path, e)
-                                }
-                            }
-                        }
                     }
                 }
             }